@@ -0,0 +1,68 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// artifactsHeaders returns the headers declaring the artifacts of a task, if
+// any
+func artifactsHeaders(patterns []string) tasks.Headers {
+	if len(patterns) == 0 {
+		return nil
+	}
+
+	return tasks.Headers{benchdrilltasks.ArtifactsHeader: strings.Join(patterns, "\n")}
+}
+
+// fetchArtifacts downloads the archive of the artifacts of a task and
+// unpacks it in a directory named after the task
+func fetchArtifacts(taskUUID, output string) error {
+	if taskUUID == "" {
+		return errors.New("A task UUID is required")
+	}
+
+	server, store, err := startStoreClient("fetch_artifacts")
+
+	if err != nil {
+		return err
+	}
+
+	blobs := benchdrilltasks.NewBlobStore(store, artifactsDir)
+	info, archive, err := benchdrilltasks.GetArtifacts(store, blobs, taskUUID)
+
+	if err == benchdrilltasks.ErrNotFound {
+		state, stateErr := server.GetBackend().GetState(taskUUID)
+
+		if stateErr == nil && !state.IsCompleted() {
+			return fmt.Errorf("Task %s has not completed yet (%s)", taskUUID, state.State)
+		}
+
+		return fmt.Errorf("No artifacts for task %s", taskUUID)
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not get artifacts: %s", err.Error())
+	}
+
+	dest := filepath.Join(output, taskUUID)
+	files, err := benchdrilltasks.UnpackArchive(archive, dest)
+
+	if err != nil {
+		return fmt.Errorf("Could not unpack artifacts: %s", err.Error())
+	}
+
+	for _, file := range files {
+		fmt.Println(file)
+	}
+
+	benchdrilltasks.Log.Task(info.TaskUUID, "").With(benchdrilltasks.Fields{benchdrilltasks.LogWorker: info.Worker}).Infof("%d files collected on worker %s unpacked to %s", len(files), info.Worker, dest)
+
+	return nil
+}
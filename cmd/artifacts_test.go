@@ -0,0 +1,64 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+func TestArtifacts(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	// The workload file written in the directory of the task matches too
+	out, code := h.run(testWorkload, "--times", "2", "--artifact", "*", "--detach", "send_cmd_file", "--set", "nthreads=4", "sysbench --histogram=on -f")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	var uuids []string
+
+	for _, line := range strings.Split(out, "\n") {
+		if strings.HasPrefix(line, "group_") || strings.HasPrefix(line, "task_") {
+			uuids = append(uuids, line)
+		}
+	}
+
+	if len(uuids) != 3 {
+		t.Fatalf("Expected the UUIDs of the group and of 2 tasks, got:\n%s", out)
+	}
+
+	output := filepath.Join(h.dir, "artifacts")
+
+	for _, taskUUID := range uuids[1:] {
+		// Artifacts are collected once the task completed
+		for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+			if out, code = h.run("", "fetch_artifacts", "--output", output, taskUUID); code == 0 {
+				break
+			}
+
+			if time.Now().After(deadline) {
+				t.Fatalf("Exit code %d, expected the artifacts of task %s:\n%s", code, taskUUID, out)
+			}
+		}
+
+		if strings.Contains(out, benchdrilltasks.OutputFile) || strings.Contains(out, "workload.f") {
+			t.Errorf("Expected the files of the worker to be left out:\n%s", out)
+		}
+
+		hist, err := ioutil.ReadFile(filepath.Join(output, taskUUID, "hist.txt"))
+
+		if err != nil || string(hist) != "1.620 | 12346\n" {
+			t.Errorf("Expected hist.txt unpacked, got %q (%v):\n%s", hist, err, out)
+		}
+	}
+
+	if _, code := h.run("", "fetch_artifacts", "--output", output, "task_unknown"); code == 0 {
+		t.Error("Expected fetching the artifacts of an unknown task to fail")
+	}
+}
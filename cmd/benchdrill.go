@@ -1,16 +1,16 @@
 package main
 
 import (
+	"errors"
 	"fmt"
 	"io/ioutil"
+	"net/http"
 	"os"
 	"time"
 
 	"github.com/Wolphin-project/benchdrill/pkg"
 
 	"github.com/RichardKnop/machinery/v1"
-	"github.com/RichardKnop/machinery/v1/config"
-	"github.com/RichardKnop/machinery/v1/log"
 	"github.com/RichardKnop/machinery/v1/tasks"
 
 	"github.com/urfave/cli"
@@ -24,6 +24,24 @@ var (
 	resultBackend string
 	defaultQueue  string
 	times         int
+	detach        bool
+	timeout       time.Duration
+	retries       int
+	retryDelay    int
+	aggregate     bool
+	libraryDir    string
+	artifacts     cli.StringSlice
+	artifactsDir  string
+	inputFiles    cli.StringSlice
+	inputDirs     cli.StringSlice
+	local         bool
+	localWorkers  int
+	logFormat     string
+	logLevel      string
+	traceOTLP     string
+	traceFile     string
+	limits        benchdrilltasks.Limits
+	memoryLimit   string
 )
 
 func init() {
@@ -39,26 +57,24 @@ func init() {
 		cli.StringFlag{
 			Name:        "c",
 			Value:       "config_benchdrill.yml",
+			EnvVar:      "BENCHDRILL_CONFIG",
 			Destination: &configPath,
-			Usage:       "Path to a configuration file",
+			Usage:       "Path to a configuration file, overridden by the BENCHDRILL_* environment variables and the flags",
 		},
 		cli.StringFlag{
 			Name:        "b",
-			Value:       "redis://127.0.0.1:6379/",
 			Destination: &broker,
-			Usage:       "Broker URL",
+			Usage:       "Broker URL (default: " + defaultBrokerURL + ")",
 		},
 		cli.StringFlag{
 			Name:        "r",
-			Value:       "redis://127.0.0.1:6379/",
 			Destination: &resultBackend,
-			Usage:       "Result backend",
+			Usage:       "Result backend (default: " + defaultBrokerURL + ")",
 		},
 		cli.StringFlag{
 			Name:        "q",
-			Value:       "benchdrill_tasks",
 			Destination: &defaultQueue,
-			Usage:       "Ephemeral Redis queue name",
+			Usage:       "Ephemeral Redis queue name (default: " + defaultQueueName + ")",
 		},
 		cli.IntFlag{
 			Name:        "times",
@@ -66,27 +82,173 @@ func init() {
 			Destination: &times,
 			Usage:       "Number of times tasks are sent",
 		},
+		cli.BoolFlag{
+			Name:        "detach",
+			Destination: &detach,
+			Usage:       "Print the group and task UUIDs and exit without waiting for the results",
+		},
+		cli.DurationFlag{
+			Name:        "timeout",
+			Destination: &timeout,
+			Usage:       "Overall time to wait for the results, instances without result are reported as timed out (0 to wait forever)",
+		},
+		cli.IntFlag{
+			Name:        "retries",
+			Destination: &retries,
+			Usage:       "Number of times a failed instance is retried",
+		},
+		cli.IntFlag{
+			Name:        "retry-delay",
+			Value:       1,
+			Destination: &retryDelay,
+			Usage:       "Seconds before the first retry, following retries back off along the Fibonacci sequence",
+		},
+		cli.BoolFlag{
+			Name:        "aggregate",
+			Destination: &aggregate,
+			Usage:       "Let a worker compute the aggregate metrics of the group, only this summary is downloaded",
+		},
+		cli.StringSliceFlag{
+			Name:  "artifact",
+			Value: &artifacts,
+			Usage: "Path or glob of files collected after each instance ran, relative to its working directory, may be repeated",
+		},
+		cli.StringFlag{
+			Name:        "artifacts-dir",
+			EnvVar:      "BENCHDRILL_ARTIFACTS_DIR",
+			Destination: &artifactsDir,
+			Usage:       "Directory shared by the workers and the clients where artifacts are stored, rather than in Redis",
+		},
+		cli.StringSliceFlag{
+			Name:  "file",
+			Value: &inputFiles,
+			Usage: "Input file unpacked in the working directory of each instance, as name=path or path, may be repeated",
+		},
+		cli.StringSliceFlag{
+			Name:  "dir",
+			Value: &inputDirs,
+			Usage: "Directory whose files are unpacked in the working directory of each instance, may be repeated",
+		},
+		cli.StringFlag{
+			Name:        "library",
+			EnvVar:      "BENCHDRILL_LIBRARY",
+			Destination: &libraryDir,
+			Usage:       "Directory of workloads (YAML files) added to the built-in library",
+		},
+		cli.BoolFlag{
+			Name:        "local, standalone",
+			Destination: &local,
+			Usage:       "Run the workers and an in-memory stand-in for Redis in this process, without any other service",
+		},
+		cli.IntFlag{
+			Name:        "local-workers",
+			Value:       1,
+			Destination: &localWorkers,
+			Usage:       "Number of workers run with --local",
+		},
+		cli.StringFlag{
+			Name:        "log-format",
+			Value:       benchdrilltasks.LogText,
+			EnvVar:      "BENCHDRILL_LOG_FORMAT",
+			Destination: &logFormat,
+			Usage:       "Format of the logs, text or json (one object per line)",
+		},
+		cli.StringFlag{
+			Name:        "log-level",
+			Value:       "info",
+			EnvVar:      "BENCHDRILL_LOG_LEVEL",
+			Destination: &logLevel,
+			Usage:       "Lowest level logged: debug, info, warning or error",
+		},
+		cli.StringFlag{
+			Name:        "trace-otlp",
+			EnvVar:      "BENCHDRILL_TRACE_OTLP,OTEL_EXPORTER_OTLP_ENDPOINT",
+			Destination: &traceOTLP,
+			Usage:       "OTLP/HTTP endpoint of a collector the spans of the tasks are exported to, e.g. http://localhost:4318",
+		},
+		cli.StringFlag{
+			Name:        "trace-file",
+			EnvVar:      "BENCHDRILL_TRACE_FILE",
+			Destination: &traceFile,
+			Usage:       "File the spans of the tasks are appended to, in OTLP JSON (one request per line)",
+		},
+		cli.Float64Flag{
+			Name:        "cpus",
+			Destination: &limits.CPUs,
+			Usage:       "CPU quota of each instance, e.g. 1.5, on workers run with --cgroups (default: no limit)",
+		},
+		cli.StringFlag{
+			Name:        "cpuset",
+			Destination: &limits.Cpuset,
+			Usage:       "CPUs each instance may run on, e.g. 0-3,6, on workers run with --cgroups (default: all)",
+		},
+		cli.StringFlag{
+			Name:        "memory",
+			Destination: &memoryLimit,
+			Usage:       "Memory limit of each instance, e.g. 512M, on workers run with --cgroups (default: no limit)",
+		},
+		cli.IntFlag{
+			Name:        "io-weight",
+			Destination: &limits.IOWeight,
+			Usage:       "IO weight of each instance, from 1 to 10000, on workers run with --cgroups (default: 100)",
+		},
 	}
-}
 
-func startServer() (*machinery.Server, error) {
-	cnf := config.Config{
-		Broker:        broker,
-		DefaultQueue:  defaultQueue,
-		ResultBackend: resultBackend,
+	app.Before = func(c *cli.Context) error {
+		level, err := benchdrilltasks.ParseLogLevel(logLevel)
+
+		if err != nil {
+			return err
+		}
+
+		if err := benchdrilltasks.Log.Configure(logFormat, level); err != nil {
+			return err
+		}
+
+		configureTracing(traceOTLP, traceFile)
+
+		if memoryLimit != "" {
+			if limits.Memory, err = benchdrilltasks.ParseMemory(memoryLimit); err != nil {
+				return err
+			}
+		}
+
+		if err := limits.Validate(); err != nil {
+			return err
+		}
+
+		if local {
+			return startLocal(localWorkers)
+		}
+
+		return nil
 	}
 
-	// If present, the config file takes priority over CLI flags
-	data, err := config.ReadFromFile(configPath)
+	app.After = func(c *cli.Context) error {
+		if stopLocal != nil {
+			stopLocal()
+		}
+
+		return nil
+	}
+
+	// Commands returning an exit code exit from the CLI app, the pending
+	// spans are exported first
+	cli.OsExiter = func(code int) {
+		benchdrilltasks.Tracing.Flush()
+		os.Exit(code)
+	}
+}
+
+func startServer() (*machinery.Server, error) {
+	cnf, _, err := loadConfig()
 
 	if err != nil {
-		log.WARNING.Printf("Could not load config from file: %s", err.Error())
-	} else if err = config.ParseYAMLConfig(&data, &cnf); err != nil {
-		return nil, fmt.Errorf("Could not parse config file: %s", err.Error())
+		return nil, err
 	}
 
 	// Create server instance
-	server, err := machinery.NewServer(&cnf)
+	server, err := machinery.NewServer(cnf)
 
 	if err != nil {
 		return nil, fmt.Errorf("Could not initialize server: %s", err.Error())
@@ -94,171 +256,443 @@ func startServer() (*machinery.Server, error) {
 
 	// Register tasks
 	tasks := map[string]interface{}{
-		"task_args": benchdrilltasks.TaskArgs,
-		"task_file": benchdrilltasks.TaskFile,
+		"task_args":    benchdrilltasks.TaskArgs,
+		"task_file":    benchdrilltasks.TaskFile,
+		"task_cleanup": benchdrilltasks.TaskCleanup,
+		"task_summary": benchdrilltasks.NewTaskSummary(server.GetBackend()),
 	}
 
 	return server, server.RegisterTasks(tasks)
 }
 
-func sendCmdArgs(cmd string) error {
+// startClient starts the server and opens the Store of its result backend
+func startClient() (*machinery.Server, benchdrilltasks.Store, error) {
 	server, err := startServer()
 
 	if err != nil {
-		return err
+		return nil, nil, err
 	}
 
-	var task0 tasks.Signature
+	store, err := benchdrilltasks.NewStore(server.GetConfig())
 
-	var initTasks = func() {
-		task0 = tasks.Signature{
-			Name: "task_args",
-			Args: []tasks.Arg{
-				{
-					Type:  "string",
-					Value: cmd,
-				},
-			},
-		}
+	return server, store, err
+}
+
+// startStoreClient starts a client for a feature built on the Store, which
+// requires a Redis result backend
+func startStoreClient(feature string) (*machinery.Server, benchdrilltasks.Store, error) {
+	server, store, err := startClient()
+
+	if err == nil {
+		err = benchdrilltasks.RequireStore(store, feature)
 	}
 
-	initTasks()
+	return server, store, err
+}
 
-	var s []*tasks.Signature
+func sendCmdArgs(cmd string) error {
+	return sendTasks("task_args", []tasks.Arg{
+		{
+			Type:  "string",
+			Value: cmd,
+		},
+	})
+}
 
-	for i := 0; i < times; i++ {
-		s = append(s, &task0)
+// sendCmdFile sends a command run on a workload file, rendered from a
+// template for each instance. Unless lint is false, the workloads of
+// Filebench are checked before anything is sent
+func sendCmdFile(cmd, file string, vars map[string]string, lint bool) error {
+	server, store, err := startClient()
+
+	if err != nil {
+		return err
 	}
 
-	groupedTasks := tasks.NewGroup(s...)
-	asyncResults, err := server.SendGroup(groupedTasks)
+	groupedTasks, chord, info := newTaskGroup("task_file", []tasks.Arg{
+		{
+			Type:  "string",
+			Value: cmd,
+		},
+	}, flagOptions())
 
-	if err != nil {
-		return fmt.Errorf("Could not send task: %s", err.Error())
+	if err := renderWorkloads(groupedTasks, info, file, vars, lint); err != nil {
+		return err
+	}
+
+	return dispatch(server, store, groupedTasks, chord, info)
+}
+
+// renderWorkloads renders the workload file of each instance of a group of
+// task_file and adds it to the arguments of the instance
+func renderWorkloads(groupedTasks *tasks.Group, info *benchdrilltasks.GroupInfo, file string, vars map[string]string, lint bool) error {
+	used := make(map[string]bool)
+
+	for _, name := range benchdrilltasks.Placeholders(file) {
+		used[name] = true
 	}
 
-	log.INFO.Println("Command passed to worker…")
+	for name := range vars {
+		if !used[name] {
+			benchdrilltasks.Log.Warningf("Variable %s is not used by the workload", name)
+		}
+	}
 
-	for _, asyncResult := range asyncResults {
-		results, err := asyncResult.Get(time.Duration(time.Millisecond * 5))
+	for i, signature := range groupedTasks.Tasks {
+		workload, err := benchdrilltasks.RenderWorkload(file, vars, groupedTasks.GroupUUID, signature.UUID, i, len(groupedTasks.Tasks))
 
 		if err != nil {
-			return fmt.Errorf("Getting task result failed with error: %s", err.Error())
+			return err
 		}
 
-		for _, result := range results {
-			log.INFO.Printf("%v", result.Interface())
+		if lint && isFilebench(info.Command) {
+			if err := lintWorkload(workload, i == 0); err != nil {
+				return fmt.Errorf("Not sending the workload of instance %d: %s", i, err.Error())
+			}
+		}
+
+		// The rendered workload is kept with the description of the instance
+		info.Instances[i].Workload = workload
+		signature.Args = []tasks.Arg{
+			signature.Args[0],
+			{
+				Type:  "string",
+				Value: workload,
+			},
 		}
 	}
 
 	return nil
 }
 
-func sendCmdFile(cmd, file string) error {
-	server, err := startServer()
+// sendTasks sends a group of `times` instances of a task then, unless
+// detached, waits for their results
+func sendTasks(name string, args []tasks.Arg) error {
+	server, store, err := startClient()
 
 	if err != nil {
 		return err
 	}
 
-	var task0 tasks.Signature
+	groupedTasks, chord, info := newTaskGroup(name, args, flagOptions())
 
-	var initTasks = func() {
-		task0 = tasks.Signature{
-			Name: "task_file",
-			Args: []tasks.Arg{
-				{
-					Type:  "string",
-					Value: cmd,
-				},
-				{
-					Type:  "string",
-					Value: file,
-				},
-			},
-		}
-	}
+	return dispatch(server, store, groupedTasks, chord, info)
+}
 
-	initTasks()
+// groupOptions are the options of the instances of a group, given by the
+// flags or, for serve, by each request
+type groupOptions struct {
+	Times      int
+	Retries    int
+	RetryDelay int
+	Aggregate  bool
+	Artifacts  []string
+	Labels     map[string]string
+	// Resources each instance may use, nil without limits
+	Limits *benchdrilltasks.Limits
+}
 
+// flagOptions returns the options given by the flags
+func flagOptions() *groupOptions {
+	return &groupOptions{
+		Times:      times,
+		Retries:    retries,
+		RetryDelay: retryDelay,
+		Aggregate:  aggregate,
+		Artifacts:  artifacts,
+		Limits:     &limits,
+	}
+}
+
+// newTaskGroup makes a group of instances of a task, as a chord if the group
+// is aggregated, and its description
+func newTaskGroup(name string, args []tasks.Arg, opts *groupOptions) (*tasks.Group, *tasks.Chord, *benchdrilltasks.GroupInfo) {
+	// Each instance needs its own signature to get its own task UUID
 	var s []*tasks.Signature
 
-	for i := 0; i < times; i++ {
-		s = append(s, &task0)
+	for i := 0; i < opts.Times; i++ {
+		s = append(s, &tasks.Signature{
+			Name:         name,
+			Args:         args,
+			Headers:      benchdrilltasks.SetLimits(artifactsHeaders(opts.Artifacts), opts.Limits),
+			RetryCount:   opts.Retries,
+			RetryTimeout: retryTimeout(opts.RetryDelay),
+		})
 	}
 
 	groupedTasks := tasks.NewGroup(s...)
-	asyncResults, err := server.SendGroup(groupedTasks)
+
+	info := &benchdrilltasks.GroupInfo{
+		GroupUUID: groupedTasks.GroupUUID,
+		TaskName:  name,
+		Command:   fmt.Sprint(args[0].Value),
+		CreatedAt: time.Now().UTC(),
+		Labels:    opts.Labels,
+	}
+
+	for _, signature := range s {
+		info.Instances = append(info.Instances, &benchdrilltasks.InstanceInfo{TaskUUID: signature.UUID})
+	}
+
+	var chord *tasks.Chord
+
+	if opts.Aggregate {
+		chord = newSummaryChord(groupedTasks)
+		info.SummaryUUID = chord.Callback.UUID
+	}
+
+	return groupedTasks, chord, info
+}
+
+// sendGroup records the description of a group and sends it, as a chord if
+// given, with the input files of its tasks
+func sendGroup(server *machinery.Server, store benchdrilltasks.Store, groupedTasks *tasks.Group, chord *tasks.Chord, info *benchdrilltasks.GroupInfo) error {
+	// Root of the trace of the group, the workers and the pickup of the
+	// results add their spans below
+	span := benchdrilltasks.Tracing.Start("submit "+info.TaskName, benchdrilltasks.SpanContext{}, benchdrilltasks.SpanClient)
+	span.SetAttribute(benchdrilltasks.TraceGroupAttribute, info.GroupUUID)
+	span.SetAttribute("benchdrill.command", info.Command)
+	span.SetAttribute("benchdrill.instances", len(info.Instances))
+	defer span.Finish()
+
+	info.Trace = span.Context.Traceparent()
+
+	if err := attachBundle(server, store, groupedTasks, info); err != nil {
+		span.SetError(err)
+		return err
+	}
+
+	if err := benchdrilltasks.SaveGroupInfo(store, info, benchdrilltasks.ExpireIn(server.GetConfig())); err != nil {
+		span.SetError(err)
+		return fmt.Errorf("Could not save group: %s", err.Error())
+	}
+
+	publish := span.Child("publish")
+	publish.Kind = benchdrilltasks.SpanProducer
+	defer publish.Finish()
+
+	sentAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+	for _, signature := range groupedTasks.Tasks {
+		if signature.Headers == nil {
+			signature.Headers = make(tasks.Headers)
+		}
+
+		signature.Headers[benchdrilltasks.SentAtHeader] = sentAt
+		benchdrilltasks.SetTraceContext(signature.Headers, publish.Context)
+	}
+
+	var err error
+
+	if chord != nil {
+		_, err = server.SendChord(chord)
+	} else {
+		_, err = server.SendGroup(groupedTasks)
+	}
 
 	if err != nil {
+		publish.SetError(err)
+		span.SetError(err)
 		return fmt.Errorf("Could not send task: %s", err.Error())
 	}
 
-	log.INFO.Println("Command passed to worker…")
+	return nil
+}
 
-	for _, asyncResult := range asyncResults {
-		results, err := asyncResult.Get(time.Duration(time.Millisecond * 5))
+// dispatch sends a group then, unless detached, waits for the results of its
+// instances (or their summary) and reports them
+func dispatch(server *machinery.Server, store benchdrilltasks.Store, groupedTasks *tasks.Group, chord *tasks.Chord, info *benchdrilltasks.GroupInfo) error {
+	// Detached groups are fetched, and artifacts downloaded, from the Store
+	if detach {
+		if err := benchdrilltasks.RequireStore(store, "--detach"); err != nil {
+			return err
+		}
+	}
 
-		if err != nil {
-			return fmt.Errorf("Getting task result failed with error: %s", err.Error())
+	if len(artifacts) > 0 {
+		if err := benchdrilltasks.RequireStore(store, "--artifact"); err != nil {
+			return err
 		}
+	}
 
-		for _, result := range results {
-			log.INFO.Printf("%v", result.Interface())
+	if err := sendGroup(server, store, groupedTasks, chord, info); err != nil {
+		return err
+	}
+
+	sent := time.Now()
+
+	if detach {
+		// Only the UUIDs go to stdout so they can be captured by scripts
+		fmt.Println(info.GroupUUID)
+
+		for _, instance := range info.Instances {
+			fmt.Println(instance.TaskUUID)
+		}
+
+		fmt.Fprintf(os.Stderr, "Results can be collected later with: fetch %s\n", info.GroupUUID)
+
+		if len(artifacts) > 0 {
+			fmt.Fprintln(os.Stderr, "Artifacts of each task can be downloaded with: fetch_artifacts <task-uuid>")
 		}
+
+		return nil
 	}
 
-	return nil
+	benchdrilltasks.Log.Group(info.GroupUUID).Infof("Command passed to worker… (group %s)", info.GroupUUID)
+
+	if info.SummaryUUID != "" {
+		summary, err := collectSummary(server, info.SummaryUUID, timeout)
+
+		if err != nil {
+			return err
+		}
+
+		return reportSummary(summary)
+	}
+
+	results, err := collectResults(server, store, info.GroupUUID, timeout)
+
+	if err != nil {
+		return fmt.Errorf("Getting task results failed with error: %s", err.Error())
+	}
+
+	pickedUp := time.Now()
+
+	if len(artifacts) > 0 {
+		benchdrilltasks.Log.Group(info.GroupUUID).Infof("Artifacts of each task can be downloaded with: fetch_artifacts <task-uuid> (see status %s)", info.GroupUUID)
+	}
+
+	err = report(store, results)
+	tracePickup(info, results, sent, pickedUp)
+
+	return err
+}
+
+// retryTimeout converts the delay before the first retry to the timeout
+// machinery expects: workers wait for the next Fibonacci number above it
+func retryTimeout(delay int) int {
+	if delay < 1 {
+		return 0
+	}
+
+	return delay - 1
 }
 
-func worker() error {
+func worker(workdir string, scheduler bool, metricsListen string, taskTimeout time.Duration, cgroups bool, cgroupRoot string) error {
 	server, err := startServer()
 
 	if err != nil {
 		return err
 	}
 
-	// The second argument is a consumer tag
-	// Ideally, each worker should have a unique tag (worker1, worker2 etc)
-	worker := server.NewWorker("benchdrill_worker")
+	store, err := benchdrilltasks.NewStore(server.GetConfig())
 
-	if err := worker.Launch(); err != nil {
+	if err != nil {
 		return err
 	}
 
-	return nil
-}
+	if scheduler {
+		if err := benchdrilltasks.RequireStore(store, "--scheduler"); err != nil {
+			return err
+		}
+	}
 
-func getStatus(queue string) error {
-	server, err := startServer()
+	if _, ok := store.(benchdrilltasks.NopStore); ok {
+		benchdrilltasks.Log.Warningf("The result backend is not Redis: tasks run, but status, fetch, cancellation, input files and artifacts are not available")
+	}
+
+	// Each worker is identified (and used as consumer tag) by its hostname and PID
+	worker, err := benchdrilltasks.NewWorker(server, store, "")
 
 	if err != nil {
 		return err
 	}
 
-	fmt.Printf("%v\n", queue)
+	if workdir != "" {
+		worker.Workdir = workdir
+	}
 
-	waitingTasks, err := server.GetBroker().GetPendingTasks(queue)
+	worker.Blobs = benchdrilltasks.NewBlobStore(store, artifactsDir)
+	worker.TaskTimeout = taskTimeout
 
-	if err != nil {
+	benchdrilltasks.Tracing.SetResource("service.instance.id", worker.ID)
+
+	// All the lines of the process are about this worker, machinery's too
+	benchdrilltasks.SetLogger(benchdrilltasks.Log.With(benchdrilltasks.Fields{benchdrilltasks.LogWorker: worker.ID}))
+
+	if worker.Exporters, err = loadExporters(); err != nil {
 		return err
 	}
 
-	for _, waitingTask := range waitingTasks {
-		log.INFO.Printf("%v | %v | %v", waitingTask.UUID, waitingTask.Name, waitingTask.GroupUUID)
+	if cgroups {
+		// Tasks still run, without limits, where cgroups cannot be written
+		if worker.Cgroups, err = benchdrilltasks.NewCgroups(cgroupRoot); err != nil {
+			benchdrilltasks.Log.Warningf("Tasks run without cgroups: %s", err.Error())
+		} else {
+			benchdrilltasks.Log.Infof("Tasks run in their own cgroup below %s", worker.Cgroups.Root())
+		}
+	}
+
+	if metricsListen != "" {
+		go func() {
+			benchdrilltasks.Log.Infof("Serving metrics on %s/metrics", metricsListen)
+
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", worker.Metrics)
+
+			if err := http.ListenAndServe(metricsListen, mux); err != nil {
+				benchdrilltasks.Log.Errorf("Could not serve metrics: %s", err.Error())
+			}
+		}()
+	}
+
+	if scheduler {
+		go runScheduler(server, store, scheduleTick)
+	}
+
+	if err := worker.Launch(); err != nil {
+		return err
 	}
 
 	return nil
 }
 
-func main() {
+func init() {
 	// Set the CLI app commands
 	app.Commands = []cli.Command{
 		{
 			Name:  "worker",
 			Usage: "Launch Benchdrill worker",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "workdir",
+					Usage: "Directory where each task gets its own working directory (default: benchdrill in the temporary directory)",
+				},
+				cli.BoolFlag{
+					Name:  "scheduler",
+					Usage: "Also submit the runs of the schedules which are due, as schedule run does",
+				},
+				cli.StringFlag{
+					Name:   "metrics-listen",
+					Usage:  "Address where Prometheus metrics are served on /metrics, e.g. :9100 (default: not served)",
+					EnvVar: "BENCHDRILL_METRICS_LISTEN",
+				},
+				cli.DurationFlag{
+					Name:  "task-timeout",
+					Usage: "Kill the command of a task running longer, e.g. 2h (default: no limit)",
+				},
+				cli.BoolFlag{
+					Name:   "cgroups",
+					Usage:  "Run the command of each task in its own cgroup v2, with the limits of the task, and report its accounting",
+					EnvVar: "BENCHDRILL_CGROUPS",
+				},
+				cli.StringFlag{
+					Name:  "cgroup-root",
+					Usage: "Cgroup v2 delegated to the worker, below which the cgroups of the tasks are created (default: the cgroup of the worker)",
+				},
+			},
 			Action: func(c *cli.Context) error {
-				return worker()
+				return worker(c.String("workdir"), c.Bool("scheduler"), c.String("metrics-listen"), c.Duration("task-timeout"), c.Bool("cgroups"), c.String("cgroup-root"))
 			},
 		},
 		{
@@ -271,27 +705,499 @@ func main() {
 		{
 			Name:  "send_cmd_file",
 			Usage: "Send command with file",
+			Description: `The file read from stdin is a template: placeholders such as {{nthreads}}
+   are replaced by the values given with --set, and {{instance}} (index of
+   the instance from 0), {{instances}}, {{group}} and {{task}} by those of
+   each instance.`,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "set",
+					Usage: "Value of a workload variable, name=value, may be repeated",
+				},
+				cli.BoolFlag{
+					Name:  "no-lint",
+					Usage: "Do not check the workloads of Filebench before sending them",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				vars, err := benchdrilltasks.ParseVars(c.StringSlice("set"))
+
+				if err != nil {
+					return err
+				}
+
+				file, err := ioutil.ReadAll(os.Stdin)
+
+				if err != nil {
+					return err
+				}
+
+				return sendCmdFile(c.Args().First(), string(file), vars, !c.Bool("no-lint"))
+			},
+		},
+		{
+			Name:      "send_pipeline",
+			Usage:     "Send commands run one after another on the same worker: prepare, run then cleanup",
+			ArgsUsage: "<command>",
+			Description: `Each instance runs "<command> prepare", "<command> run" then
+   "<command> cleanup" (e.g. for "sysbench fileio --file-total-size=1G"),
+   unless a step is given explicitly. The cleanup always runs, even if a
+   previous step failed, and only the output of the run step is reported.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "prepare",
+					Usage: "Command of the prepare step",
+				},
+				cli.StringFlag{
+					Name:  "run",
+					Usage: "Command of the run step",
+				},
+				cli.StringFlag{
+					Name:  "cleanup",
+					Usage: "Command of the cleanup step",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				steps, err := pipelineSteps(c.Args().First(), c.String("prepare"), c.String("run"), c.String("cleanup"))
+
+				if err != nil {
+					return err
+				}
+
+				return sendPipeline(steps)
+			},
+		},
+		{
+			Name:      "sweep",
+			Usage:     "Send a command template once per combination of the values of its parameters",
+			ArgsUsage: "<command-template>",
+			Description: `Placeholders of the template, e.g. "sysbench --threads={{threads}} cpu run",
+   are replaced by the values given with --param, e.g. "threads=1,2,4..8"
+   ("first..last" and "first..last..step" are integer ranges). Each
+   combination is sent as its own group of --times instances, one after
+   another, and a row of its aggregate metrics is reported.`,
+			Flags: []cli.Flag{
+				cli.StringSliceFlag{
+					Name:  "param, p",
+					Usage: "Values of a parameter, name=values, may be repeated",
+				},
+				cli.BoolFlag{
+					Name:  "zip",
+					Usage: "Take the values of the parameters together rather than their cartesian product",
+				},
+				cli.BoolFlag{
+					Name:  "shuffle",
+					Usage: "Run the combinations in random order",
+				},
+				cli.Int64Flag{
+					Name:  "seed",
+					Usage: "Seed of the random order (0 for a random seed)",
+				},
+				cli.StringSliceFlag{
+					Name:  "metric, m",
+					Usage: "Metric to report, may be repeated (default: the main metrics of the benchmark)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return sweep(c.Args().First(), c.StringSlice("param"), c.Bool("zip"), c.Bool("shuffle"), c.Int64("seed"), c.StringSlice("metric"))
+			},
+		},
+		{
+			Name:      "scalability",
+			Usage:     "Run a command at several levels of concurrency and report how its throughput scales",
+			ArgsUsage: "<command>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "levels",
+					Value: "1,2,4,8",
+					Usage: "Parallel instances run at each level, as a list or a range (1..8)",
+				},
+				cli.IntFlag{
+					Name:  "repeat",
+					Value: 3,
+					Usage: "Groups run at each level",
+				},
+				cli.StringFlag{
+					Name:  "metric, m",
+					Usage: "Rate summed over the instances as the throughput (default: the main rate of the benchmark, else the instances per second)",
+				},
+				cli.Float64Flag{
+					Name:  "min-efficiency",
+					Value: 0.8,
+					Usage: "Efficiency (speedup over the ideal one) below which the throughput per instance is flagged as degraded",
+				},
+				cli.BoolFlag{
+					Name:  "replicas",
+					Usage: "Scale the Swarm service of the workers to each level before running it, as scale does",
+				},
+				cli.StringFlag{
+					Name:  "service",
+					Value: defaultWorkerService,
+					Usage: "Swarm service of the workers, with --replicas",
+				},
+				cli.StringFlag{
+					Name:  "docker-host",
+					Usage: "Docker engine of a manager node, with --replicas (default: DOCKER_HOST, else " + benchdrilltasks.DefaultDockerHost + ")",
+				},
+				cli.DurationFlag{
+					Name:  "wait",
+					Value: 5 * time.Minute,
+					Usage: "Time to wait for the workers of each level to be registered and idle, with --replicas",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				levels, err := benchdrilltasks.ParseLevels(c.String("levels"))
+
+				if err != nil {
+					return err
+				}
+
+				opts := &scalabilityOptions{
+					Levels:        levels,
+					Repeat:        c.Int("repeat"),
+					Metric:        c.String("metric"),
+					MinEfficiency: c.Float64("min-efficiency"),
+					Service:       c.String("service"),
+					Wait:          c.Duration("wait"),
+				}
+
+				if c.Bool("replicas") {
+					if opts.Docker, err = benchdrilltasks.NewDockerAPI(c.String("docker-host")); err != nil {
+						return err
+					}
+				}
+
+				return scalability(c.Args().First(), opts)
+			},
+		},
+		{
+			Name:  "serve",
+			Usage: "Serve an HTTP/JSON API to submit jobs and collect their results, and a live dashboard",
+			Description: `Jobs are sent as with --detach and tracked through the API, described
+   by GET /openapi.yaml. The dashboard, on /, shows the workers, the queues,
+   the output of the running instances and charts of the finished jobs.`,
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:   "listen",
+					Value:  "127.0.0.1:8080",
+					EnvVar: "BENCHDRILL_LISTEN",
+					Usage:  "Address the API listens on, e.g. :8080 to be reachable from other hosts",
+				},
+				cli.StringFlag{
+					Name:   "token",
+					EnvVar: "BENCHDRILL_API_TOKEN",
+					Usage:  "Bearer token required to submit and cancel jobs (default: generated and logged)",
+				},
+				cli.DurationFlag{
+					Name:  "refresh",
+					Value: 2 * time.Second,
+					Usage: "Time between two updates pushed to the dashboard",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return serve(c.String("listen"), c.String("token"), c.Duration("refresh"))
+			},
+		},
+		{
+			Name:      "cancel",
+			Usage:     "Cancel the instances of a group which have not started yet",
+			ArgsUsage: "<group-uuid>",
+			Action: func(c *cli.Context) error {
+				return cancel(c.Args().First())
+			},
+		},
+		{
+			Name:  "schedule",
+			Usage: "Submit jobs again and again, as given by cron expressions",
+			Subcommands: []cli.Command{
+				{
+					Name:      "add",
+					Usage:     "Add a schedule of a command, sent with the global flags (--times, --file…) on each run",
+					ArgsUsage: "<command>",
+					Description: `Cron expressions have 5 fields: minute, hour, day of month, month and day
+   of week, e.g. "0 2 * * *" every night at 2:00, or a macro such as
+   @hourly. A run due while the previous one is still running is handled
+   by the --overlap policy: skipped, queued (at most one run waits for the
+   previous one to complete) or allowed. Runs are submitted by schedule run,
+   or workers started with --scheduler, labelled schedule=<id>.`,
+					Flags: []cli.Flag{
+						cli.StringFlag{
+							Name:  "cron",
+							Usage: "When the command runs, as a cron expression",
+						},
+						cli.StringFlag{
+							Name:  "timezone",
+							Usage: "Time zone of the cron expression, e.g. Europe/Paris (default: the one of the scheduler)",
+						},
+						cli.StringFlag{
+							Name:  "id",
+							Usage: "ID of the schedule (default: generated)",
+						},
+						cli.StringFlag{
+							Name:  "overlap",
+							Value: benchdrilltasks.OverlapSkip,
+							Usage: "Policy for a run due while the previous one is still running: skip, queue or allow",
+						},
+						cli.StringFlag{
+							Name:  "workload",
+							Usage: "Workload file appended to the command, rendered for each instance as with send_cmd_file",
+						},
+						cli.StringSliceFlag{
+							Name:  "set",
+							Usage: "Value of a workload variable, name=value, may be repeated",
+						},
+						cli.BoolFlag{
+							Name:  "no-lint",
+							Usage: "Do not check the workloads of Filebench",
+						},
+						cli.BoolFlag{
+							Name:  "pipeline",
+							Usage: "Run the command as a pipeline, as send_pipeline does",
+						},
+						cli.StringSliceFlag{
+							Name:  "label",
+							Usage: "Label of the groups submitted, name=value, may be repeated",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						if c.String("cron") == "" {
+							return errors.New("--cron is required")
+						}
+
+						vars, err := benchdrilltasks.ParseVars(c.StringSlice("set"))
+
+						if err != nil {
+							return err
+						}
+
+						labels, err := benchdrilltasks.ParseVars(c.StringSlice("label"))
+
+						if err != nil {
+							return err
+						}
+
+						opts := flagOptions()
+						req := &jobRequest{
+							Command:    c.Args().First(),
+							Vars:       vars,
+							NoLint:     c.Bool("no-lint"),
+							Pipeline:   c.Bool("pipeline"),
+							Times:      opts.Times,
+							Retries:    opts.Retries,
+							RetryDelay: &opts.RetryDelay,
+							Aggregate:  opts.Aggregate,
+							Artifacts:  opts.Artifacts,
+							Labels:     labels,
+						}
+
+						if !opts.Limits.IsZero() {
+							req.Limits = opts.Limits
+						}
+
+						return addSchedule(c.String("cron"), c.String("timezone"), c.String("id"), c.String("overlap"), c.String("workload"), req)
+					},
+				},
+				{
+					Name:  "list",
+					Usage: "List the schedules, when they run next and their last run",
+					Action: func(c *cli.Context) error {
+						return listSchedules()
+					},
+				},
+				{
+					Name:      "rm",
+					Usage:     "Remove a schedule, the groups it submitted are kept",
+					ArgsUsage: "<id>",
+					Action: func(c *cli.Context) error {
+						return removeSchedule(c.Args().First())
+					},
+				},
+				{
+					Name:  "run",
+					Usage: "Run a scheduler, submitting the runs of the schedules which are due",
+					Action: func(c *cli.Context) error {
+						server, store, err := startStoreClient("schedule run")
+
+						if err != nil {
+							return err
+						}
+
+						runScheduler(server, store, scheduleTick)
+
+						return nil
+					},
+				},
+			},
+		},
+		{
+			Name:  "config",
+			Usage: "Inspect the configuration",
+			Subcommands: []cli.Command{
+				{
+					Name:  "show",
+					Usage: "Show the effective configuration and where each value comes from, with passwords masked",
+					Action: func(c *cli.Context) error {
+						return showConfig()
+					},
+				},
+			},
+		},
+		{
+			Name:  "workload",
+			Usage: "Use the library of named workloads",
+			Subcommands: []cli.Command{
+				{
+					Name:  "list",
+					Usage: "List the workloads of the library",
+					Action: func(c *cli.Context) error {
+						return listWorkloads()
+					},
+				},
+				{
+					Name:      "show",
+					Usage:     "Show the variables and the content of a workload",
+					ArgsUsage: "<name>",
+					Action: func(c *cli.Context) error {
+						return showWorkload(c.Args().First())
+					},
+				},
+				{
+					Name:      "lint",
+					Usage:     "Check a workload file of Filebench, read from stdin if not given",
+					ArgsUsage: "[file | name]",
+					Description: `The workload is a file, or a workload of the library, rendered with the
+   variables given with --set (and the defaults of the library) as for the
+   first instance of a group.`,
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "set",
+							Usage: "Value of a workload variable, name=value, may be repeated",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return lintWorkloadFile(c.Args().First(), c.StringSlice("set"))
+					},
+				},
+				{
+					Name:      "run",
+					Usage:     "Send a workload of the library",
+					ArgsUsage: "<name>",
+					Flags: []cli.Flag{
+						cli.StringSliceFlag{
+							Name:  "set",
+							Usage: "Value of a variable of the workload, name=value, may be repeated",
+						},
+					},
+					Action: func(c *cli.Context) error {
+						return runWorkload(c.Args().First(), c.StringSlice("set"))
+					},
+				},
+			},
+		},
+		{
+			Name:      "fetch_artifacts",
+			Usage:     "Download and unpack the artifacts of a task sent with --artifact",
+			ArgsUsage: "<task-uuid>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "output",
+					Value: "artifacts",
+					Usage: "Directory where the artifacts are unpacked, in a directory named after the task",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return fetchArtifacts(c.Args().First(), c.String("output"))
+			},
+		},
+		{
+			Name:      "fetch",
+			Usage:     "Collect the results available for a group sent with --detach",
+			ArgsUsage: "<group-uuid>",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "workloads",
+					Usage: "Print the workload file each instance was sent, as rendered",
+				},
+			},
 			Action: func(c *cli.Context) error {
-				file, err := ioutil.ReadFile("/dev/stdin")
+				return fetch(c.Args().First(), c.Bool("workloads"))
+			},
+		},
+		{
+			Name:      "scale",
+			Usage:     "Scale the Swarm service of the workers and wait until its workers are registered and idle",
+			ArgsUsage: "<replicas>",
+			Flags: []cli.Flag{
+				cli.StringFlag{
+					Name:  "service",
+					Value: defaultWorkerService,
+					Usage: "Swarm service of the workers",
+				},
+				cli.StringFlag{
+					Name:  "docker-host",
+					Usage: "Docker engine of a manager node, e.g. tcp://manager:2375 (default: DOCKER_HOST, else " + benchdrilltasks.DefaultDockerHost + ")",
+				},
+				cli.DurationFlag{
+					Name:  "wait",
+					Value: 5 * time.Minute,
+					Usage: "Time to wait for the workers to be registered and idle (0 not to wait)",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				docker, err := benchdrilltasks.NewDockerAPI(c.String("docker-host"))
 
 				if err != nil {
 					return err
 				}
 
-				return sendCmdFile(c.Args().First(), string(file))
+				return scale(docker, c.String("service"), c.Args().First(), c.Duration("wait"))
 			},
 		},
 		{
-			Name:  "get_status",
-			Usage: "Get tasks which are waiting in the queue",
+			Name:      "status",
+			Usage:     "Get the state of each instance of a group and the depth of the queues",
+			ArgsUsage: "[group-uuid]",
+			Flags: []cli.Flag{
+				cli.BoolFlag{
+					Name:  "watch",
+					Usage: "Redraw the status until the group completes",
+				},
+				cli.DurationFlag{
+					Name:  "interval",
+					Value: 2 * time.Second,
+					Usage: "Time between two redraws in watch mode",
+				},
+				cli.StringFlag{
+					Name:  "queue",
+					Usage: "Only show the depth of this queue",
+				},
+			},
+			Action: func(c *cli.Context) error {
+				return status(c.Args().First(), c.String("queue"), c.Bool("watch"), c.Duration("interval"))
+			},
+		},
+		{
+			// Kept for the scripts calling it, the queue is given to status
+			Name:      "get_status",
+			Usage:     "Deprecated, use status --queue",
+			ArgsUsage: "[queue]",
+			Hidden:    true,
 			Action: func(c *cli.Context) error {
-				return getStatus(c.Args().First())
+				benchdrilltasks.Log.Warning("get_status is deprecated and will be removed, use status --queue instead")
+				return status("", c.Args().First(), false, 0)
 			},
 		},
 	}
+}
 
+func main() {
 	// Run the CLI app
-	if err := app.Run(os.Args); err != nil {
-		log.FATAL.Print(err)
+	// Errors carrying an exit code are handled by the CLI app itself
+	err := app.Run(os.Args)
+	benchdrilltasks.Tracing.Flush()
+
+	if err != nil {
+		benchdrilltasks.Log.Error(err.Error())
+		os.Exit(1)
 	}
 }
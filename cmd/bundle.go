@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// Bundle of input files once packed, the groups of a sweep share it
+var (
+	bundleHash    string
+	bundleArchive []byte
+	bundleFiles   int
+)
+
+// attachBundle uploads the input files given with --file and --dir, unless
+// the same bundle is already stored, and has the tasks of the group unpack
+// them
+func attachBundle(server *machinery.Server, store benchdrilltasks.Store, groupedTasks *tasks.Group, info *benchdrilltasks.GroupInfo) error {
+	if len(inputFiles) == 0 && len(inputDirs) == 0 {
+		return nil
+	}
+
+	if err := benchdrilltasks.RequireStore(store, "--file and --dir"); err != nil {
+		return err
+	}
+
+	if bundleHash == "" {
+		files, err := benchdrilltasks.BundleFiles(inputFiles, inputDirs)
+
+		if err != nil {
+			return err
+		}
+
+		archive, hash, err := benchdrilltasks.PackBundle(files)
+
+		if err != nil {
+			return err
+		}
+
+		bundleHash, bundleArchive, bundleFiles = hash, archive, len(files)
+	}
+
+	// Saved for each group, which references the bundle until it completes
+	if err := saveBundle(server, store, bundleHash, bundleArchive, bundleFiles, groupedTasks.GroupUUID); err != nil {
+		return err
+	}
+
+	setBundle(groupedTasks, info, bundleHash)
+
+	return nil
+}
+
+// saveBundle uploads a bundle of input files for a group unless it is
+// already stored
+func saveBundle(server *machinery.Server, store benchdrilltasks.Store, hash string, archive []byte, files int, groupUUID string) error {
+	blobs := benchdrilltasks.NewBlobStore(store, artifactsDir)
+	stored, err := benchdrilltasks.SaveBundle(store, blobs, hash, archive, groupUUID)
+
+	if err != nil {
+		return fmt.Errorf("Could not save input files: %s", err.Error())
+	}
+
+	if stored {
+		benchdrilltasks.Log.Infof("Input files: %d files, %d bytes (bundle %s)", files, len(archive), hash)
+	} else {
+		benchdrilltasks.Log.Infof("Input files: %d files, already stored (bundle %s)", files, hash)
+	}
+
+	return nil
+}
+
+// setBundle has the tasks of a group unpack a bundle. Only the first step of
+// a pipeline gets it, the following steps run in the same directory
+func setBundle(groupedTasks *tasks.Group, info *benchdrilltasks.GroupInfo, hash string) {
+	for _, signature := range groupedTasks.Tasks {
+		if signature.Headers == nil {
+			signature.Headers = make(tasks.Headers)
+		}
+
+		signature.Headers[benchdrilltasks.BundleHeader] = hash
+	}
+
+	info.Bundle = hash
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// cancel cancels a group: its instances which have not started yet fail
+// once a worker picks them up, the running ones run to their end
+func cancel(groupUUID string) error {
+	if groupUUID == "" {
+		return errors.New("A group UUID is required")
+	}
+
+	server, store, err := startStoreClient("cancel")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := benchdrilltasks.GetGroupInfo(store, groupUUID); err != nil {
+		return fmt.Errorf("Could not get group %s: %s", groupUUID, err.Error())
+	}
+
+	if err := benchdrilltasks.CancelGroup(store, groupUUID, benchdrilltasks.ExpireIn(server.GetConfig())); err != nil {
+		return fmt.Errorf("Could not cancel group %s: %s", groupUUID, err.Error())
+	}
+
+	benchdrilltasks.Log.Group(groupUUID).Infof("Group %s cancelled, see status %s", groupUUID, groupUUID)
+
+	return nil
+}
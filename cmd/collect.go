@@ -0,0 +1,117 @@
+package main
+
+import (
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+)
+
+// Bounds of the exponential backoff used to poll the result backend
+const (
+	minPollInterval = 100 * time.Millisecond
+	maxPollInterval = 5 * time.Second
+)
+
+// poll calls check, backing off exponentially, until it returns true or the
+// timeout (if not 0) expires, in which case it returns false
+func poll(timeout time.Duration, check func() bool) bool {
+	var deadline <-chan time.Time
+
+	if timeout > 0 {
+		deadline = time.After(timeout)
+	}
+
+	interval := minPollInterval
+
+	for !check() {
+		select {
+		case <-deadline:
+			return false
+		case <-time.After(interval):
+		}
+
+		if interval *= 2; interval > maxPollInterval {
+			interval = maxPollInterval
+		}
+	}
+
+	return true
+}
+
+// collectResults polls the states of the instances of a group until they
+// all completed or the timeout expired. A failed instance does not stop
+// the collection
+func collectResults(server *machinery.Server, store benchdrilltasks.Store, groupUUID string, timeout time.Duration) ([]*instanceResult, error) {
+	states, completed, err := waitStates(server, store, groupUUID, timeout)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !completed {
+		return newInstanceResults(states, TimedOutState)
+	}
+
+	return newInstanceResults(states, "")
+}
+
+// waitStates polls the states of the instances of a group until they all
+// completed or the timeout expired, it returns the last states read and
+// whether they all completed
+func waitStates(server *machinery.Server, store benchdrilltasks.Store, groupUUID string, timeout time.Duration) ([]*instanceState, bool, error) {
+	var (
+		states []*instanceState
+		err    error
+	)
+
+	completed := poll(timeout, func() bool {
+		states, err = instanceStates(server, store, groupUUID)
+
+		if err != nil {
+			benchdrilltasks.Log.Warning(err.Error())
+			return false
+		}
+
+		for _, state := range states {
+			if !state.IsCompleted() {
+				return false
+			}
+		}
+
+		return true
+	})
+
+	return states, completed, err
+}
+
+// tracePickup adds to the trace of a group the span of the wait for its
+// results since it was sent, if known, and for each instance the span from
+// the end of its task to the pickup of its result
+func tracePickup(info *benchdrilltasks.GroupInfo, results []*instanceResult, sent, pickedUp time.Time) {
+	parent := benchdrilltasks.ParseTraceparent(info.Trace)
+
+	if !parent.IsValid() {
+		return
+	}
+
+	if !sent.IsZero() {
+		wait := benchdrilltasks.Tracing.StartAt("wait results", parent, benchdrilltasks.SpanInternal, sent)
+		wait.SetAttribute(benchdrilltasks.TraceGroupAttribute, info.GroupUUID)
+		wait.FinishAt(pickedUp)
+	}
+
+	for _, result := range results {
+		if result.FinishedAt.IsZero() || result.FinishedAt.After(pickedUp) {
+			continue
+		}
+
+		span := benchdrilltasks.Tracing.StartAt("client pickup", parent, benchdrilltasks.SpanClient, result.FinishedAt)
+		span.SetAttribute(benchdrilltasks.TraceTaskAttribute, result.TaskUUID)
+		span.SetAttribute(benchdrilltasks.TraceGroupAttribute, info.GroupUUID)
+		span.SetAttribute(benchdrilltasks.TraceWorkerAttribute, result.Worker)
+		span.Error = result.Error
+		span.FinishAt(pickedUp)
+	}
+}
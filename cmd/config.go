@@ -0,0 +1,349 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/config"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultBrokerURL = "redis://127.0.0.1:6379/"
+	defaultQueueName = "benchdrill_tasks"
+)
+
+var queueName = regexp.MustCompile(`^[A-Za-z0-9_.:-]+$`)
+
+// setting is a value of the configuration, read from the config file (under
+// key), then from the environment variable env and from the flag, if any
+type setting struct {
+	key    string
+	env    string
+	flag   string
+	secret bool
+	get    func(cnf *config.Config) string
+	set    func(cnf *config.Config, value string) error
+}
+
+var settings = []*setting{
+	{
+		key:    "broker",
+		env:    "BENCHDRILL_BROKER",
+		flag:   "-b",
+		secret: true,
+		get:    func(cnf *config.Config) string { return cnf.Broker },
+		set:    func(cnf *config.Config, value string) error { cnf.Broker = value; return nil },
+	},
+	{
+		key:    "result_backend",
+		env:    "BENCHDRILL_RESULT_BACKEND",
+		flag:   "-r",
+		secret: true,
+		get:    func(cnf *config.Config) string { return cnf.ResultBackend },
+		set:    func(cnf *config.Config, value string) error { cnf.ResultBackend = value; return nil },
+	},
+	{
+		key:  "default_queue",
+		env:  "BENCHDRILL_DEFAULT_QUEUE",
+		flag: "-q",
+		get:  func(cnf *config.Config) string { return cnf.DefaultQueue },
+		set:  func(cnf *config.Config, value string) error { cnf.DefaultQueue = value; return nil },
+	},
+	{
+		key: "results_expire_in",
+		env: "BENCHDRILL_RESULTS_EXPIRE_IN",
+		get: func(cnf *config.Config) string { return strconv.Itoa(cnf.ResultsExpireIn) },
+		set: func(cnf *config.Config, value string) (err error) {
+			cnf.ResultsExpireIn, err = strconv.Atoi(value)
+			return
+		},
+	},
+	{
+		key: "max_worker_instances",
+		env: "BENCHDRILL_MAX_WORKER_INSTANCES",
+		get: func(cnf *config.Config) string { return strconv.Itoa(cnf.MaxWorkerInstances) },
+		set: func(cnf *config.Config, value string) (err error) {
+			cnf.MaxWorkerInstances, err = strconv.Atoi(value)
+			return
+		},
+	},
+}
+
+// loadConfig builds the configuration from the defaults, overridden by the
+// config file, then by the environment variables, then by the flags. It
+// returns where each value comes from, by key
+func loadConfig() (*config.Config, map[string]string, error) {
+	cnf := &config.Config{
+		Broker:          defaultBrokerURL,
+		ResultBackend:   defaultBrokerURL,
+		DefaultQueue:    defaultQueueName,
+		ResultsExpireIn: 3600,
+	}
+	sources := make(map[string]string)
+
+	for _, s := range settings {
+		sources[s.key] = "default"
+	}
+
+	if configPath != "" {
+		data, err := ioutil.ReadFile(configPath)
+
+		if err != nil {
+			benchdrilltasks.Log.Warningf("Could not load config from file: %s", err.Error())
+		} else {
+			keys := make(map[string]interface{})
+
+			if err := yaml.Unmarshal(data, keys); err != nil {
+				return nil, nil, fmt.Errorf("Could not parse config file: %s", err.Error())
+			}
+
+			if err := config.ParseYAMLConfig(&data, cnf); err != nil {
+				return nil, nil, fmt.Errorf("Could not parse config file: %s", err.Error())
+			}
+
+			for _, s := range settings {
+				if _, ok := keys[s.key]; ok {
+					sources[s.key] = "file " + configPath
+				}
+			}
+		}
+	}
+
+	for _, s := range settings {
+		value := os.Getenv(s.env)
+
+		if value == "" {
+			continue
+		}
+
+		if err := s.set(cnf, value); err != nil {
+			return nil, nil, fmt.Errorf("Invalid %s: %s", s.env, err.Error())
+		}
+
+		sources[s.key] = "env " + s.env
+	}
+
+	flags := map[string]string{"-b": broker, "-r": resultBackend, "-q": defaultQueue}
+
+	for _, s := range settings {
+		if value := flags[s.flag]; value != "" {
+			s.set(cnf, value)
+			sources[s.key] = "flag " + s.flag
+		}
+	}
+
+	if err := validateConfig(cnf); err != nil {
+		return nil, nil, err
+	}
+
+	return cnf, sources, nil
+}
+
+// validateConfig checks the URLs of the broker and the result backend, the
+// name of the queue and the numbers. Result backends other than Redis are
+// left to machinery, the features built on the Benchdrill store refuse them
+func validateConfig(cnf *config.Config) error {
+	if strings.HasPrefix(cnf.ResultBackend, "redis") {
+		if err := validateRedisURL(cnf.ResultBackend); err != nil {
+			return fmt.Errorf("Invalid result_backend: %s", err.Error())
+		}
+	} else if !strings.Contains(cnf.ResultBackend, "://") {
+		return fmt.Errorf("Invalid result_backend: %s is not a URL", maskURL(cnf.ResultBackend))
+	}
+
+	switch {
+	case strings.HasPrefix(cnf.Broker, "amqp://") || strings.HasPrefix(cnf.Broker, "amqps://"):
+		if u, err := url.Parse(cnf.Broker); err != nil || u.Host == "" {
+			return fmt.Errorf("Invalid broker: no host in %s", maskURL(cnf.Broker))
+		}
+	case strings.HasPrefix(cnf.Broker, "redis"):
+		if err := validateRedisURL(cnf.Broker); err != nil {
+			return fmt.Errorf("Invalid broker: %s", err.Error())
+		}
+	default:
+		return fmt.Errorf("Invalid broker: %s is not a redis://, redis+socket:// or amqp:// URL", maskURL(cnf.Broker))
+	}
+
+	if !queueName.MatchString(cnf.DefaultQueue) {
+		return fmt.Errorf("Invalid default_queue %q: only letters, digits, '_', '.', ':' and '-' are allowed", cnf.DefaultQueue)
+	}
+
+	if cnf.ResultsExpireIn < 0 {
+		return fmt.Errorf("Invalid results_expire_in %d: it must not be negative", cnf.ResultsExpireIn)
+	}
+
+	if cnf.MaxWorkerInstances < 0 {
+		return fmt.Errorf("Invalid max_worker_instances %d: it must not be negative", cnf.MaxWorkerInstances)
+	}
+
+	return nil
+}
+
+// validateRedisURL checks a redis:// or redis+socket:// URL
+func validateRedisURL(redisURL string) error {
+	var (
+		address string
+		err     error
+	)
+
+	switch {
+	case strings.HasPrefix(redisURL, "redis://"):
+		address, _, _, err = machinery.ParseRedisURL(redisURL)
+	case strings.HasPrefix(redisURL, "redis+socket://"):
+		address, _, _, err = machinery.ParseRedisSocketURL(redisURL)
+	default:
+		return fmt.Errorf("%s is not a redis:// or redis+socket:// URL", maskURL(redisURL))
+	}
+
+	if err != nil {
+		return fmt.Errorf("%s (%s)", err.Error(), maskURL(redisURL))
+	}
+
+	if address == "" {
+		return fmt.Errorf("no host in %s", maskURL(redisURL))
+	}
+
+	return nil
+}
+
+// maskURL hides the password of a URL, given as user:password@ or, for
+// Redis, password@
+func maskURL(rawURL string) string {
+	parts := strings.SplitN(rawURL, "://", 2)
+
+	if len(parts) != 2 {
+		return rawURL
+	}
+
+	at := strings.LastIndex(parts[1], "@")
+
+	if at < 0 {
+		return rawURL
+	}
+
+	credentials := parts[1][:at]
+
+	if colon := strings.Index(credentials, ":"); colon >= 0 {
+		credentials = credentials[:colon+1] + "*****"
+	} else if strings.HasPrefix(parts[0], "redis") {
+		credentials = "*****"
+	}
+
+	return parts[0] + "://" + credentials + parts[1][at:]
+}
+
+// exporterConfigs returns the exporters of the results listed under
+// "exporters" in the config file
+func exporterConfigs() ([]*benchdrilltasks.ExporterConfig, error) {
+	if configPath == "" {
+		return nil, nil
+	}
+
+	data, err := ioutil.ReadFile(configPath)
+
+	// Already reported by loadConfig
+	if err != nil {
+		return nil, nil
+	}
+
+	var file struct {
+		Exporters []*benchdrilltasks.ExporterConfig `yaml:"exporters"`
+	}
+
+	if err := yaml.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("Could not parse config file: %s", err.Error())
+	}
+
+	return file.Exporters, nil
+}
+
+// loadExporters creates the exporters of the config file
+func loadExporters() ([]benchdrilltasks.Exporter, error) {
+	configs, err := exporterConfigs()
+
+	if err != nil {
+		return nil, err
+	}
+
+	var exporters []benchdrilltasks.Exporter
+
+	for _, cnf := range configs {
+		exporter, err := benchdrilltasks.NewExporter(cnf)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid exporter: %s", err.Error())
+		}
+
+		exporters = append(exporters, exporter)
+	}
+
+	return exporters, nil
+}
+
+// configureTracing sets the exporters of the spans, to an OTLP collector and
+// to a file
+func configureTracing(otlpEndpoint, path string) {
+	var exporters []benchdrilltasks.SpanExporter
+
+	if otlpEndpoint != "" {
+		exporters = append(exporters, benchdrilltasks.NewOTLPExporter(otlpEndpoint))
+	}
+
+	if path != "" {
+		exporters = append(exporters, benchdrilltasks.NewSpanFileExporter(path))
+	}
+
+	benchdrilltasks.Tracing.SetExporters(exporters...)
+}
+
+// showConfig prints the effective configuration and where each value comes
+// from
+func showConfig() error {
+	cnf, sources, err := loadConfig()
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "KEY\tVALUE\tSOURCE")
+
+	for _, s := range settings {
+		value := s.get(cnf)
+
+		if s.secret {
+			value = maskURL(value)
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\n", s.key, value, sources[s.key])
+	}
+
+	configs, err := exporterConfigs()
+
+	if err != nil {
+		return err
+	}
+
+	for _, cnf := range configs {
+		destination := cnf.Path
+
+		if cnf.URL != "" {
+			destination = maskURL(cnf.URL)
+		}
+
+		fmt.Fprintf(w, "exporter\t%s %s\tfile %s\n", cnf.Type, destination, configPath)
+	}
+
+	return w.Flush()
+}
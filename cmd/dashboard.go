@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// Number of the newest jobs shown by the dashboard
+const dashboardJobs = 20
+
+// dashboardState is the snapshot of the cluster pushed to the dashboard
+type dashboardState struct {
+	Time    time.Time                     `json:"time"`
+	Workers []*benchdrilltasks.WorkerInfo `json:"workers"`
+	Queues  []*queueDepth                 `json:"queues"`
+	Jobs    []*dashboardJob               `json:"jobs"`
+}
+
+// dashboardJob sums up a job: its running instances and the tail of their
+// output while it runs, the values of its headline metric once finished
+type dashboardJob struct {
+	GroupUUID string            `json:"group_uuid"`
+	Command   string            `json:"command"`
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	Cancelled bool              `json:"cancelled"`
+	Completed bool              `json:"completed"`
+	Counts    map[string]int    `json:"counts"`
+	Running   []*jobInstance    `json:"running,omitempty"`
+	Metric    string            `json:"metric,omitempty"`
+	Values    []float64         `json:"values,omitempty"`
+	Mean      float64           `json:"mean,omitempty"`
+}
+
+// dashboard serves the page of the dashboard, which needs no other asset
+func (api *apiServer) dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such resource %s", r.URL.Path))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write([]byte(dashboardPage))
+}
+
+// events pushes a snapshot of the cluster every refresh period as
+// server-sent events, until the client goes away
+func (api *apiServer) events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+
+	if !ok {
+		writeError(w, http.StatusInternalServerError, errors.New("Streaming is not supported"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	ticker := time.NewTicker(api.refresh)
+	defer ticker.Stop()
+
+	for {
+		state, err := api.dashboardState()
+
+		if err != nil {
+			message, _ := json.Marshal(err.Error())
+			fmt.Fprintf(w, "event: failure\ndata: %s\n\n", message)
+		} else {
+			data, _ := json.Marshal(state)
+			fmt.Fprintf(w, "data: %s\n\n", data)
+		}
+
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (api *apiServer) dashboardState() (*dashboardState, error) {
+	workers, err := benchdrilltasks.ListWorkers(api.store)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not list workers: %s", err.Error())
+	}
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+
+	queues, err := queueDepths(api.server, api.queues, workers)
+
+	// The dashboard shows no queue rather than wrong depths
+	if err != nil && err != benchdrilltasks.ErrQueuesUnavailable {
+		return nil, err
+	}
+
+	groups, err := benchdrilltasks.ListGroups(api.store)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not list jobs: %s", err.Error())
+	}
+
+	if len(groups) > dashboardJobs {
+		groups = groups[:dashboardJobs]
+	}
+
+	api.pruneFinished(groups)
+
+	state := &dashboardState{
+		Time:    time.Now().UTC(),
+		Workers: workers,
+		Queues:  []*queueDepth{},
+		Jobs:    []*dashboardJob{},
+	}
+
+	if queues != nil {
+		state.Queues = queues
+	}
+
+	if state.Workers == nil {
+		state.Workers = []*benchdrilltasks.WorkerInfo{}
+	}
+
+	for _, info := range groups {
+		job, err := api.dashboardJob(info)
+
+		if err != nil {
+			return nil, err
+		}
+
+		state.Jobs = append(state.Jobs, job)
+	}
+
+	return state, nil
+}
+
+// pruneFinished forgets the finished jobs which are no longer shown, expired
+// or older than the jobs shown
+func (api *apiServer) pruneFinished(groups []*benchdrilltasks.GroupInfo) {
+	shown := make(map[string]bool)
+
+	for _, info := range groups {
+		shown[info.GroupUUID] = true
+	}
+
+	api.mu.Lock()
+	defer api.mu.Unlock()
+
+	for groupUUID := range api.finished {
+		if !shown[groupUUID] {
+			delete(api.finished, groupUUID)
+		}
+	}
+}
+
+// dashboardJob sums up a job. Finished jobs do not change, they are only
+// read once
+func (api *apiServer) dashboardJob(info *benchdrilltasks.GroupInfo) (*dashboardJob, error) {
+	api.mu.Lock()
+	job := api.finished[info.GroupUUID]
+	api.mu.Unlock()
+
+	if job != nil {
+		return job, nil
+	}
+
+	status, err := api.jobStatus(info, false)
+
+	if err != nil {
+		return nil, err
+	}
+
+	job = &dashboardJob{
+		GroupUUID: info.GroupUUID,
+		Command:   info.Command,
+		Labels:    info.Labels,
+		CreatedAt: info.CreatedAt,
+		Cancelled: status.Cancelled,
+		Completed: status.Completed,
+		Counts:    status.Counts,
+	}
+
+	if !status.Completed {
+		for _, instance := range status.Instances {
+			if instance.State != tasks.StartedState {
+				continue
+			}
+
+			taskUUID := instance.TaskUUID
+
+			if instance.StepTaskUUID != "" {
+				taskUUID = instance.StepTaskUUID
+			}
+
+			if tail, err := benchdrilltasks.GetOutputTail(api.store, taskUUID); err == nil {
+				instance.Output = string(tail)
+			}
+
+			job.Running = append(job.Running, instance)
+		}
+
+		return job, nil
+	}
+
+	if status, err = api.jobStatus(info, true); err != nil {
+		return nil, err
+	}
+
+	// The summary of an aggregated job may not be available yet
+	if status.Summary == nil {
+		return job, nil
+	}
+
+	job.Metric = headlineMetric(status.Summary.Metrics)
+
+	if aggregate := status.Summary.Metrics[job.Metric]; aggregate != nil {
+		job.Mean = aggregate.Mean
+	}
+
+	for _, instance := range status.Instances {
+		if value, ok := instance.Metrics[job.Metric]; ok {
+			job.Values = append(job.Values, value)
+		}
+	}
+
+	api.mu.Lock()
+	api.finished[info.GroupUUID] = job
+	api.mu.Unlock()
+
+	return job, nil
+}
+
+// headlineMetric picks the metric charted for a job: the first of the
+// headline metrics reported, or the first metric by name
+func headlineMetric(metrics map[string]*benchdrilltasks.Aggregate) string {
+	for _, name := range headlineMetrics {
+		if metrics[name] != nil {
+			return name
+		}
+	}
+
+	var names []string
+
+	for name := range metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	if len(names) == 0 {
+		return ""
+	}
+
+	return names[0]
+}
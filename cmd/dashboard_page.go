@@ -0,0 +1,150 @@
+package main
+
+// dashboardPage is the dashboard served on /, updated by the events of
+// /events. It is self-contained so that it works without Internet access
+const dashboardPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<title>Benchdrill</title>
+<style>
+  body { margin: 0; font: 14px/1.4 sans-serif; background: #1d2026; color: #d8dee9; }
+  header { display: flex; justify-content: space-between; align-items: baseline; padding: 12px 20px; background: #262a33; }
+  header h1 { margin: 0; font-size: 20px; }
+  #updated.stale { color: #e06c75; }
+  main { display: grid; grid-template-columns: 320px 1fr; gap: 20px; padding: 20px; }
+  h2 { margin: 0 0 8px; font-size: 15px; text-transform: uppercase; color: #8f9aae; }
+  section { margin-bottom: 24px; }
+  table { width: 100%; border-collapse: collapse; }
+  td, th { padding: 3px 6px; text-align: left; border-bottom: 1px solid #323743; }
+  th { color: #8f9aae; font-weight: normal; }
+  .num { text-align: right; }
+  .job { background: #262a33; border-radius: 4px; padding: 10px 14px; margin-bottom: 12px; }
+  .job .cmd { font-family: monospace; font-size: 15px; }
+  .job .meta { color: #8f9aae; font-size: 12px; }
+  .label { display: inline-block; background: #3b4252; border-radius: 3px; padding: 0 5px; margin-right: 4px; }
+  .SUCCESS { color: #98c379; } .FAILURE { color: #e06c75; } .STARTED, .RECEIVED { color: #61afef; } .RETRY { color: #e5c07b; }
+  pre { background: #15171c; padding: 6px 8px; margin: 6px 0; max-height: 160px; overflow: auto; font-size: 12px; white-space: pre-wrap; }
+  svg { display: block; margin-top: 6px; }
+  .bar { fill: #61afef; } .mean { stroke: #e5c07b; stroke-width: 1; stroke-dasharray: 4 2; }
+  .empty { color: #5c6370; }
+</style>
+</head>
+<body>
+<header><h1>Benchdrill</h1><span id="updated">Connecting…</span></header>
+<main>
+  <div>
+    <section><h2>Workers</h2><table id="workers"></table></section>
+    <section><h2>Queues</h2><table id="queues"></table></section>
+  </div>
+  <div>
+    <section><h2>Running and queued</h2><div id="running"></div></section>
+    <section><h2>Finished</h2><div id="finished"></div></section>
+  </div>
+</main>
+<script>
+"use strict";
+
+var states = ["PENDING", "RECEIVED", "STARTED", "RETRY", "SUCCESS", "FAILURE"];
+
+function esc(s) {
+  return String(s).replace(/[&<>"']/g, function (c) {
+    return {"&": "&amp;", "<": "&lt;", ">": "&gt;", "\"": "&quot;", "'": "&#39;"}[c];
+  });
+}
+
+function ago(time) {
+  var s = Math.max(0, Math.round((Date.now() - new Date(time)) / 1000));
+  return s < 60 ? s + "s" : s < 3600 ? Math.round(s / 60) + "m" : Math.round(s / 3600) + "h";
+}
+
+function rows(headers, lines) {
+  if (!lines.length) {
+    return "<tr><td class=\"empty\">None</td></tr>";
+  }
+  return "<tr>" + headers.map(function (h) { return "<th>" + h + "</th>"; }).join("") + "</tr>" +
+    lines.map(function (cells) { return "<tr>" + cells.join("") + "</tr>"; }).join("");
+}
+
+function counts(job) {
+  return states.filter(function (s) { return job.counts[s]; }).map(function (s) {
+    return "<span class=\"" + s + "\">" + job.counts[s] + " " + s + "</span>";
+  }).join(", ");
+}
+
+function header(job) {
+  var labels = Object.keys(job.labels || {}).map(function (k) {
+    return "<span class=\"label\">" + esc(k) + "=" + esc(job.labels[k]) + "</span>";
+  }).join("");
+  return "<div class=\"cmd\">" + esc(job.command) + "</div>" +
+    "<div class=\"meta\">" + labels + esc(job.group_uuid) + " · " + ago(job.created_at) + " ago · " + counts(job) +
+    (job.cancelled ? " · <span class=\"FAILURE\">cancelled</span>" : "") + "</div>";
+}
+
+function chart(job) {
+  if (!job.values || !job.values.length) {
+    return "<div class=\"empty\">No metric</div>";
+  }
+  var width = 600, height = 80, max = Math.max.apply(null, job.values.concat([job.mean])) || 1;
+  var w = width / job.values.length;
+  var bars = job.values.map(function (v, i) {
+    var h = v / max * (height - 4);
+    return "<rect class=\"bar\" x=\"" + (i * w + 1) + "\" y=\"" + (height - h) + "\" width=\"" + Math.max(w - 2, 1) +
+      "\" height=\"" + h + "\"><title>Instance " + i + ": " + v + "</title></rect>";
+  }).join("");
+  var y = height - job.mean / max * (height - 4);
+  return "<div class=\"meta\">" + esc(job.metric) + ": mean " + job.mean.toPrecision(6) + " over " + job.values.length + " instances</div>" +
+    "<svg width=\"" + width + "\" height=\"" + height + "\">" + bars +
+    "<line class=\"mean\" x1=\"0\" x2=\"" + width + "\" y1=\"" + y + "\" y2=\"" + y + "\"></line></svg>";
+}
+
+function render(state) {
+  document.getElementById("workers").innerHTML = rows(["ID", "BUSY", "SEEN"], state.workers.map(function (w) {
+    return ["<td>" + esc(w.id) + "</td>", "<td class=\"num\">" + w.busy + "</td>", "<td class=\"num\">" + ago(w.last_seen) + "</td>"];
+  }));
+
+  document.getElementById("queues").innerHTML = rows(["QUEUE", "DEPTH"], state.queues.map(function (q) {
+    return ["<td>" + esc(q.name) + "</td>", "<td class=\"num\">" + q.depth + "</td>"];
+  }));
+
+  var running = state.jobs.filter(function (job) { return !job.completed; });
+  var finished = state.jobs.filter(function (job) { return job.completed; });
+
+  document.getElementById("running").innerHTML = running.length ? running.map(function (job) {
+    return "<div class=\"job\">" + header(job) + (job.running || []).map(function (instance) {
+      return "<div class=\"meta\">Instance " + instance.index + (instance.step ? " (" + esc(instance.step) + ")" : "") +
+        " on " + esc(instance.worker || "?") + (instance.started_at ? " for " + ago(instance.started_at) : "") + "</div>" +
+        "<pre>" + esc(instance.output || "") + "</pre>";
+    }).join("") + "</div>";
+  }).join("") : "<div class=\"empty\">Nothing running</div>";
+
+  document.getElementById("finished").innerHTML = finished.length ? finished.map(function (job) {
+    return "<div class=\"job\">" + header(job) + chart(job) + "</div>";
+  }).join("") : "<div class=\"empty\">Nothing finished yet</div>";
+
+  var updated = document.getElementById("updated");
+  updated.textContent = "Updated " + new Date(state.time).toLocaleTimeString();
+  updated.className = "";
+}
+
+var events = new EventSource("events");
+
+events.onmessage = function (e) {
+  render(JSON.parse(e.data));
+};
+
+events.addEventListener("failure", function (e) {
+  var updated = document.getElementById("updated");
+  updated.textContent = JSON.parse(e.data);
+  updated.className = "stale";
+});
+
+events.onerror = function () {
+  var updated = document.getElementById("updated");
+  updated.textContent = "Disconnected, reconnecting…";
+  updated.className = "stale";
+};
+</script>
+</body>
+</html>
+`
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+func TestExportResults(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	path := filepath.Join(h.dir, "benchdrill.yml")
+	config := fmt.Sprintf("exporters:\n  - type: openmetrics\n    path: %s\n  - type: influxdb\n    path: %s\n", filepath.Join(h.dir, "results.prom"), filepath.Join(h.dir, "points"))
+
+	if err := ioutil.WriteFile(path, []byte(config), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	configPath = path
+	exporters, err := loadExporters()
+
+	if err != nil || len(exporters) != 2 {
+		t.Fatalf("Loaded %d exporters (%v), expected 2", len(exporters), err)
+	}
+
+	h.workers[0].Exporters = exporters
+
+	if _, code := h.run("", "send_pipeline", "sysbench fileio"); code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	groups, err := benchdrilltasks.ListGroups(h.store)
+
+	if err != nil || len(groups) != 1 {
+		t.Fatalf("Expected a group: %v", err)
+	}
+
+	// Only the run step of the pipeline, which is not part of the group,
+	// reports metrics
+	sample := regexp.MustCompile(`(?m)^benchdrill_cpu_speed_events_per_second\{command="sysbench fileio run",run="` + groups[0].GroupUUID + `",task="task_\S+",tool="sysbench",worker="test_0"\} 1234\.56$`)
+	content, _ := ioutil.ReadFile(filepath.Join(h.dir, "results.prom"))
+
+	if !sample.Match(content) {
+		t.Errorf("Expected the metrics of the run step of group %s:\n%s", groups[0].GroupUUID, content)
+	}
+
+	points, _ := ioutil.ReadFile(filepath.Join(h.dir, "points"))
+
+	if lines := strings.Split(strings.TrimSpace(string(points)), "\n"); len(lines) != 1 || !strings.HasPrefix(lines[0], `benchdrill,command=sysbench\ fileio\ run,run=`+groups[0].GroupUUID) {
+		t.Errorf("Expected a point for the run step of group %s:\n%s", groups[0].GroupUUID, points)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+
+	"github.com/urfave/cli"
+)
+
+// fetch collects the results of a group which are available so far, in the
+// same way a blocking run does, and reports the instances still pending. It
+// can print the workload files the instances were sent first
+func fetch(groupUUID string, workloads bool) error {
+	if groupUUID == "" {
+		return errors.New("A group UUID is required")
+	}
+
+	server, err := startServer()
+
+	if err != nil {
+		return err
+	}
+
+	store, err := benchdrilltasks.NewStore(server.GetConfig())
+
+	if err == nil {
+		err = benchdrilltasks.RequireStore(store, "fetch")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	info, err := benchdrilltasks.GetGroupInfo(store, groupUUID)
+
+	if workloads {
+		printWorkloads(server, store, groupUUID, info)
+	}
+
+	if err == nil && info.SummaryUUID != "" {
+		return fetchSummary(server, info.SummaryUUID)
+	}
+
+	states, err := instanceStates(server, store, groupUUID)
+
+	if err != nil {
+		return err
+	}
+
+	results, err := newInstanceResults(states, "")
+
+	if err != nil {
+		return err
+	}
+
+	pickedUp := time.Now()
+	err = report(store, results)
+
+	if info != nil {
+		tracePickup(info, results, time.Time{}, pickedUp)
+	}
+
+	return err
+}
+
+// fetchSummary reads the summary of an aggregated group with a single read
+func fetchSummary(server *machinery.Server, summaryUUID string) error {
+	summary, state, err := getSummary(server, summaryUUID)
+
+	if err != nil {
+		return err
+	}
+
+	if summary == nil {
+		return cli.NewExitError(fmt.Sprintf("The summary of the group is not available yet (%s)", state), exitNoResults)
+	}
+
+	return reportSummary(summary)
+}
+
+// printWorkloads prints the workload file each instance of a group ran, as
+// recorded with its result, or as it was sent if it has not run yet
+func printWorkloads(server *machinery.Server, store benchdrilltasks.Store, groupUUID string, info *benchdrilltasks.GroupInfo) {
+	states, err := instanceStates(server, store, groupUUID)
+
+	if err != nil {
+		benchdrilltasks.Log.Warningf("Could not get the workloads: %s", err.Error())
+		return
+	}
+
+	for i, state := range states {
+		workload := ""
+
+		if meta, err := benchdrilltasks.GetTaskMeta(store, state.TaskUUID); err == nil {
+			workload = meta.Workload
+		}
+
+		if workload == "" && info != nil && i < len(info.Instances) {
+			workload = info.Instances[i].Workload
+		}
+
+		if workload == "" {
+			continue
+		}
+
+		fmt.Printf("# Workload of instance %d (%s)\n%s\n", i, state.TaskUUID, strings.TrimSuffix(workload, "\n"))
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/urfave/cli"
+)
+
+// output is where the logger writes, the workers log from their own
+// goroutines while the harness points it to the output of a run
+var output = &syncWriter{w: os.Stdout}
+
+// syncWriter writes to a writer which may be replaced while in use
+type syncWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (s *syncWriter) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.w.Write(p)
+}
+
+// set replaces the writer and returns the previous one
+func (s *syncWriter) set(w io.Writer) io.Writer {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	previous := s.w
+	s.w = w
+
+	return previous
+}
+
+func init() {
+	// The outputs of the instances are logged, capture them with the rest
+	benchdrilltasks.SetLogger(benchdrilltasks.NewLogger(output, output))
+}
+
+// harness runs an in-memory stand-in for Redis and workers in the test
+// process. The workers run the fake executables of testdata/bin, which print
+// canned outputs, so that the commands are tested through the CLI as they
+// are run on the cluster
+type harness struct {
+	t       *testing.T
+	dir     string
+	exiter  func(int)
+	redis   *benchdrilltasks.LocalRedis
+	store   benchdrilltasks.Store
+	workers []*benchdrilltasks.Worker
+	// Workers started, stopped ones included
+	started int
+}
+
+func newHarness(t *testing.T, workers int) *harness {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	redis, err := benchdrilltasks.ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	h := &harness{t: t, dir: dir, redis: redis}
+
+	bin, err := filepath.Abs(filepath.Join("testdata", "bin"))
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.HasPrefix(os.Getenv("PATH"), bin+":") {
+		os.Setenv("PATH", bin+":"+os.Getenv("PATH"))
+	}
+
+	os.Setenv("BENCHDRILL_TEST_STATE", dir)
+
+	// Exit codes are checked on the errors returned by the CLI app
+	h.exiter, cli.OsExiter = cli.OsExiter, func(int) {}
+
+	h.resetFlags()
+
+	for i := 0; i < workers; i++ {
+		h.addWorker()
+	}
+
+	return h
+}
+
+// addWorker starts a worker, named after its rank, and waits until it
+// consumes its queues
+func (h *harness) addWorker() *benchdrilltasks.Worker {
+	server, err := startServer()
+
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	store, err := benchdrilltasks.NewStore(server.GetConfig())
+
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	worker, err := benchdrilltasks.NewWorker(server, store, fmt.Sprintf("test_%d", h.started))
+
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	worker.Workdir = filepath.Join(h.dir, "work")
+	worker.Start(make(chan error, 2))
+	h.workers = append(h.workers, worker)
+	h.started++
+	h.store = store
+
+	// Workers can only be stopped once they consume their queues
+	for !h.redis.Waiting(defaultQueueName) || !h.redis.Waiting(worker.PrivateQueue()) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	return worker
+}
+
+// resetFlags points the CLI to the stand-in and clears the flags which
+// accumulate values across runs
+func (h *harness) resetFlags() {
+	configPath, broker, resultBackend, defaultQueue = "", h.redis.URL(), h.redis.URL(), ""
+	artifacts, inputFiles, inputDirs = nil, nil, nil
+	bundleHash, bundleArchive, bundleFiles = "", nil, 0
+}
+
+// stopWorker stops a worker, as if it was scaled down or crashed
+func (h *harness) stopWorker(worker *benchdrilltasks.Worker) {
+	for i, w := range h.workers {
+		if w == worker {
+			h.workers = append(h.workers[:i], h.workers[i+1:]...)
+			worker.Quit()
+			return
+		}
+	}
+}
+
+// Close stops the workers and the stand-in
+func (h *harness) Close() {
+	for _, worker := range h.workers {
+		worker.Quit()
+	}
+
+	h.redis.Close()
+	os.RemoveAll(h.dir)
+	cli.OsExiter = h.exiter
+}
+
+// run runs the CLI with the given arguments and stdin, and returns what it
+// printed on stdout and its exit code
+func (h *harness) run(stdin string, args ...string) (string, int) {
+	h.resetFlags()
+
+	// Global flags are parsed again, point them to the stand-in
+	args = append([]string{"benchdrill", "-c", "", "-b", h.redis.URL(), "-r", h.redis.URL()}, args...)
+
+	stdinFile := filepath.Join(h.dir, "stdin")
+
+	if err := ioutil.WriteFile(stdinFile, []byte(stdin), 0644); err != nil {
+		h.t.Fatal(err)
+	}
+
+	in, err := os.Open(stdinFile)
+
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	defer in.Close()
+
+	r, w, err := os.Pipe()
+
+	if err != nil {
+		h.t.Fatal(err)
+	}
+
+	stdout, stdinBefore := os.Stdout, os.Stdin
+	os.Stdout, os.Stdin = w, in
+
+	// The logs of the workers come through the same pipe, in order with
+	// what the CLI prints
+	logs := output.set(w)
+
+	captured := make(chan string)
+
+	go func() {
+		var buf bytes.Buffer
+		io.Copy(&buf, r)
+		captured <- buf.String()
+	}()
+
+	err = app.Run(args)
+
+	os.Stdout, os.Stdin = stdout, stdinBefore
+	output.set(logs)
+	w.Close()
+	out := <-captured
+	r.Close()
+
+	code := 0
+
+	if exitErr, ok := err.(cli.ExitCoder); ok {
+		code = exitErr.ExitCode()
+	} else if err != nil {
+		h.t.Logf("%s: %s", strings.Join(args[7:], " "), err.Error())
+		code = 1
+	}
+
+	return out, code
+}
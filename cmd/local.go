@@ -0,0 +1,99 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// stopLocal stops the workers and the stand-in started by startLocal, once the
+// command completed
+var stopLocal func()
+
+// startLocal runs a stand-in for Redis and workers in this process, so that
+// the command runs as it would on the cluster without any other service. The
+// config file, except its exporters, and environment are ignored, the flags
+// pointing to the local server
+func startLocal(workers int) error {
+	if detach {
+		return errors.New("--detach cannot be used with --local: the results are lost once the command exits")
+	}
+
+	if workers < 1 {
+		return fmt.Errorf("Invalid number of local workers %d", workers)
+	}
+
+	// Results are exported as on the cluster
+	exporters, err := loadExporters()
+
+	if err != nil {
+		return err
+	}
+
+	redis, err := benchdrilltasks.ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		return fmt.Errorf("Could not start local Redis: %s", err.Error())
+	}
+
+	configPath, broker, resultBackend = "", redis.URL(), redis.URL()
+
+	var started []*benchdrilltasks.Worker
+
+	errorsChan := make(chan error)
+
+	for i := 0; i < workers; i++ {
+		server, err := startServer()
+
+		if err != nil {
+			return err
+		}
+
+		store, err := benchdrilltasks.NewStore(server.GetConfig())
+
+		if err != nil {
+			return err
+		}
+
+		worker, err := benchdrilltasks.NewWorker(server, store, fmt.Sprintf("local_%d", i))
+
+		if err != nil {
+			return err
+		}
+
+		worker.Blobs = benchdrilltasks.NewBlobStore(store, artifactsDir)
+		worker.Exporters = exporters
+		worker.Start(errorsChan)
+		started = append(started, worker)
+	}
+
+	go func() {
+		for err := range errorsChan {
+			if err != nil {
+				benchdrilltasks.Log.Errorf("Local worker stopped: %v", err)
+			}
+		}
+	}()
+
+	// Workers can only be stopped once they consume their queues
+	for _, worker := range started {
+		for !redis.Waiting(worker.PrivateQueue()) {
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	stopLocal = func() {
+		for _, worker := range started {
+			worker.Quit()
+		}
+
+		redis.Close()
+		stopLocal = nil
+	}
+
+	benchdrilltasks.Log.Infof("Running locally with %d workers (%s)", workers, redis.URL())
+
+	return nil
+}
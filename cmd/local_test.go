@@ -0,0 +1,46 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLocal(t *testing.T) {
+	// No worker besides the local ones, the stand-in of the harness is not
+	// used either
+	h := newHarness(t, 0)
+	defer h.Close()
+
+	out, code := h.run("", "--local", "--local-workers", "2", "--times", "4", "send_cmd_args", "sysbench cpu run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0:\n%s", code, out)
+	}
+
+	if !strings.Contains(out, "Running locally with 2 workers") || !strings.Contains(out, "4 succeeded (0 flaky), 0 failed") {
+		t.Errorf("Expected 4 instances run by the local workers:\n%s", out)
+	}
+
+	if !strings.Contains(out, "worker=local_") {
+		t.Errorf("Expected the logs of the local workers:\n%s", out)
+	}
+
+	if stopLocal != nil {
+		t.Error("Expected the local workers to be stopped once the command completed")
+	}
+
+	// Pipelines run locally too, and failures give the same exit codes
+	if out, code = h.run("", "--local", "send_pipeline", "sysbench fileio"); code != 0 {
+		t.Errorf("Exit code %d, expected 0:\n%s", code, out)
+	}
+
+	if out, code = h.run("", "--local", "send_cmd_args", "sysbench fail"); code != exitFailed {
+		t.Errorf("Exit code %d, expected %d:\n%s", code, exitFailed, out)
+	}
+
+	for _, args := range [][]string{{"--local", "--detach"}, {"--local", "--local-workers", "0"}} {
+		if out, code = h.run("", append(args, "send_cmd_args", "sysbench cpu run")...); code == 0 {
+			t.Errorf("%s: expected an error:\n%s", strings.Join(args, " "), out)
+		}
+	}
+}
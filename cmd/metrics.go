@@ -0,0 +1,84 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// newAPIMetrics registers the metrics served by serve on /metrics: the
+// requests of the API and, read on each scrape, the workers alive and the
+// depths of the queues, so that the whole fleet can be watched from serve
+func (api *apiServer) newAPIMetrics() {
+	r := benchdrilltasks.NewRegistry()
+
+	api.metrics = r
+	api.requests = r.Counter("benchdrill_api_requests_total", "Requests answered by the API", "handler", "method", "code")
+
+	workers := r.Gauge("benchdrill_workers", "Workers alive")
+	busy := r.Gauge("benchdrill_worker_busy_tasks", "Tasks being processed by a worker, as of its last heartbeat", "worker")
+	lastSeen := r.Gauge("benchdrill_worker_last_heartbeat_timestamp_seconds", "Time of the last heartbeat of a worker", "worker")
+	depth := r.Gauge("benchdrill_queue_depth", "Tasks waiting in a queue", "queue")
+
+	r.OnScrape(func() {
+		infos, err := benchdrilltasks.ListWorkers(api.store)
+
+		if err != nil {
+			benchdrilltasks.Log.Warningf("Could not list workers: %s", err.Error())
+			return
+		}
+
+		busy.Reset()
+		lastSeen.Reset()
+		workers.Set(float64(len(infos)))
+
+		for _, info := range infos {
+			busy.Set(float64(info.Busy), info.ID)
+			lastSeen.Set(float64(info.LastSeen.UnixNano())/1e9, info.ID)
+		}
+
+		depths, err := queueDepths(api.server, api.queues, infos)
+		depth.Reset()
+
+		// Without depths rather than with wrong ones
+		if err != nil {
+			if err != benchdrilltasks.ErrQueuesUnavailable {
+				benchdrilltasks.Log.Warning(err.Error())
+			}
+
+			return
+		}
+
+		for _, queue := range depths {
+			depth.Set(float64(queue.Depth), queue.Name)
+		}
+	})
+}
+
+// instrument counts the requests answered by a handler
+func (api *apiServer) instrument(name string, handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		recorder := &statusRecorder{ResponseWriter: w, status: http.StatusOK}
+		handler(recorder, r)
+		api.requests.Inc(name, r.Method, strconv.Itoa(recorder.status))
+	}
+}
+
+// statusRecorder records the status of a response, it can be flushed for
+// the events of the dashboard
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+}
+
+func (s *statusRecorder) WriteHeader(status int) {
+	s.status = status
+	s.ResponseWriter.WriteHeader(status)
+}
+
+func (s *statusRecorder) Flush() {
+	if flusher, ok := s.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
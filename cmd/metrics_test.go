@@ -0,0 +1,83 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+func TestWorkerMetrics(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	if _, code := h.run("", "--retries", "1", "send_cmd_args", "sysbench flaky"); code != 0 {
+		t.Fatalf("Exit code %d, expected 0 once retried", code)
+	}
+
+	h.workers[0].TaskTimeout = 500 * time.Millisecond
+
+	if _, code := h.run("", "send_cmd_args", "sysbench sleep=3"); code != exitFailed {
+		t.Errorf("Exit code %d, expected %d", code, exitFailed)
+	}
+
+	var buf bytes.Buffer
+
+	if err := h.workers[0].Metrics.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`benchdrill_worker_tasks_received_total{task="task_args"} 3`,
+		`benchdrill_worker_tasks_retried_total{task="task_args"} 1`,
+		`benchdrill_worker_tasks_succeeded_total{task="task_args"} 1`,
+		`benchdrill_worker_tasks_timed_out_total{task="task_args"} 1`,
+		`benchdrill_worker_task_duration_seconds_count{task="task_args"} 3`,
+		`benchdrill_worker_queue_wait_seconds_count{task="task_args"} 3`,
+		`benchdrill_worker_busy_tasks 0`,
+	} {
+		if !strings.Contains(buf.String(), line+"\n") {
+			t.Errorf("Expected %s in the metrics of the worker:\n%s", line, buf.String())
+		}
+	}
+}
+
+func TestServeMetrics(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	ts, _ := newAPI(t)
+	defer ts.Close()
+
+	var workers []*benchdrilltasks.WorkerInfo
+	getJSON(t, ts.URL+"/workers", &workers)
+
+	resp, err := http.Get(ts.URL + "/metrics")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, line := range []string{
+		`benchdrill_api_requests_total{handler="workers",method="GET",code="200"} 1`,
+		`benchdrill_workers 2`,
+		`benchdrill_worker_busy_tasks{worker="test_1"} 0`,
+		`benchdrill_queue_depth{queue="` + defaultQueueName + `"} 0`,
+	} {
+		if !strings.Contains(string(body), line+"\n") {
+			t.Errorf("Expected %s in the metrics of serve:\n%s", line, body)
+		}
+	}
+}
@@ -0,0 +1,413 @@
+package main
+
+// openAPI describes the HTTP API of serve, answered on /openapi.yaml
+const openAPI = `openapi: 3.0.3
+info:
+  title: Benchdrill
+  description: Submit benchmarks to the Benchdrill workers and collect their results
+  version: 0.1.0
+paths:
+  /jobs:
+    get:
+      summary: List the jobs which have not expired, newest first
+      parameters:
+        - name: label
+          in: query
+          description: Only list the jobs with this label, as name=value, may be repeated
+          schema:
+            type: array
+            items:
+              type: string
+          style: form
+          explode: true
+      responses:
+        "200":
+          description: Jobs
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/Job"
+        "400":
+          $ref: "#/components/responses/Error"
+    post:
+      summary: Submit a job, a group of instances of a command
+      security:
+        - bearer: []
+      requestBody:
+        required: true
+        content:
+          application/json:
+            schema:
+              $ref: "#/components/schemas/JobRequest"
+      responses:
+        "201":
+          description: Job sent to the workers
+          headers:
+            Location:
+              description: Path of the job
+              schema:
+                type: string
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/Job"
+        "400":
+          $ref: "#/components/responses/Error"
+        "401":
+          $ref: "#/components/responses/Error"
+  /jobs/{group}:
+    parameters:
+      - $ref: "#/components/parameters/Group"
+    get:
+      summary: Get the state of each instance of a job
+      responses:
+        "200":
+          description: Job status
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/JobStatus"
+        "404":
+          $ref: "#/components/responses/Error"
+    delete:
+      summary: Cancel a job
+      security:
+        - bearer: []
+      description: >
+        Instances which have not started yet fail with "Group cancelled" once
+        a worker picks them up, running instances and started pipelines run to
+        their end
+      responses:
+        "200":
+          description: Job status
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/JobStatus"
+        "401":
+          $ref: "#/components/responses/Error"
+        "404":
+          $ref: "#/components/responses/Error"
+  /jobs/{group}/results:
+    parameters:
+      - $ref: "#/components/parameters/Group"
+    get:
+      summary: Get the outputs and metrics of the instances of a job, and its aggregate metrics
+      responses:
+        "200":
+          description: Job status with the results available so far
+          content:
+            application/json:
+              schema:
+                $ref: "#/components/schemas/JobStatus"
+        "404":
+          $ref: "#/components/responses/Error"
+  /jobs/{group}/artifacts/{task}:
+    parameters:
+      - $ref: "#/components/parameters/Group"
+      - name: task
+        in: path
+        required: true
+        description: UUID of the task of an instance
+        schema:
+          type: string
+    get:
+      summary: Download the artifacts of an instance
+      responses:
+        "200":
+          description: Compressed tar archive of the artifacts
+          content:
+            application/gzip:
+              schema:
+                type: string
+                format: binary
+        "404":
+          $ref: "#/components/responses/Error"
+        "409":
+          $ref: "#/components/responses/Error"
+  /workers:
+    get:
+      summary: List the workers whose heartbeat has not expired
+      responses:
+        "200":
+          description: Workers
+          content:
+            application/json:
+              schema:
+                type: array
+                items:
+                  $ref: "#/components/schemas/Worker"
+  /metrics:
+    get:
+      summary: Get the metrics of the API, the workers and the queues, for Prometheus
+      responses:
+        "200":
+          description: Metrics in the text format of Prometheus
+          content:
+            text/plain: {}
+  /openapi.yaml:
+    get:
+      summary: Get this description
+      responses:
+        "200":
+          description: OpenAPI description
+          content:
+            application/yaml: {}
+components:
+  securitySchemes:
+    bearer:
+      type: http
+      scheme: bearer
+      description: Token given to serve with --token, or generated and logged
+  parameters:
+    Group:
+      name: group
+      in: path
+      required: true
+      description: UUID of the group of the job
+      schema:
+        type: string
+  responses:
+    Error:
+      description: Error
+      content:
+        application/json:
+          schema:
+            type: object
+            properties:
+              error:
+                type: string
+  schemas:
+    JobRequest:
+      type: object
+      required: [command]
+      properties:
+        command:
+          type: string
+          example: sysbench cpu run
+        file:
+          type: string
+          description: Workload file appended to the command, a template rendered for each instance
+        vars:
+          type: object
+          description: Values of the placeholders of the workload file
+          additionalProperties:
+            type: string
+        no_lint:
+          type: boolean
+          description: Do not check the workloads of Filebench
+        pipeline:
+          type: boolean
+          description: Run "<command> prepare", "<command> run" then "<command> cleanup"
+        files:
+          type: object
+          description: Input files by name and content, unpacked in the working directory of each instance
+          additionalProperties:
+            type: string
+        times:
+          type: integer
+          minimum: 0
+          maximum: 10000
+          default: 1
+        retries:
+          type: integer
+          minimum: 0
+          default: 0
+        retry_delay:
+          type: integer
+          minimum: 0
+          default: 1
+          description: Seconds before the first retry
+        aggregate:
+          type: boolean
+          description: Let a worker compute the aggregate metrics of the job
+        artifacts:
+          type: array
+          description: Paths or globs of files collected after each instance ran
+          items:
+            type: string
+        labels:
+          type: object
+          additionalProperties:
+            type: string
+        limits:
+          $ref: "#/components/schemas/Limits"
+    Limits:
+      type: object
+      description: Resources each instance may use, on workers run with --cgroups
+      properties:
+        cpus:
+          type: number
+          minimum: 0
+          description: CPU quota, e.g. 1.5
+        cpuset:
+          type: string
+          description: CPUs the instance may run on, e.g. 0-3,6
+        memory:
+          type: integer
+          minimum: 0
+          description: Memory limit in bytes
+        io_weight:
+          type: integer
+          minimum: 1
+          maximum: 10000
+    Job:
+      type: object
+      properties:
+        group_uuid:
+          type: string
+        task_name:
+          type: string
+        command:
+          type: string
+        created_at:
+          type: string
+          format: date-time
+        instances:
+          type: array
+          items:
+            type: object
+            properties:
+              task_uuid:
+                type: string
+              steps:
+                type: array
+                items:
+                  type: object
+                  properties:
+                    name:
+                      type: string
+                    task_uuid:
+                      type: string
+              workload:
+                type: string
+        summary_uuid:
+          type: string
+        bundle:
+          type: string
+          description: Hash of the bundle of input files
+        labels:
+          type: object
+          additionalProperties:
+            type: string
+    JobStatus:
+      type: object
+      properties:
+        group_uuid:
+          type: string
+        task_name:
+          type: string
+        command:
+          type: string
+        created_at:
+          type: string
+          format: date-time
+        summary_uuid:
+          type: string
+        bundle:
+          type: string
+        labels:
+          type: object
+          additionalProperties:
+            type: string
+        cancelled:
+          type: boolean
+        completed:
+          type: boolean
+        counts:
+          type: object
+          description: Number of instances by state
+          additionalProperties:
+            type: integer
+        instances:
+          type: array
+          items:
+            $ref: "#/components/schemas/Instance"
+        summary:
+          $ref: "#/components/schemas/Summary"
+    Instance:
+      type: object
+      properties:
+        index:
+          type: integer
+        task_uuid:
+          type: string
+        state:
+          type: string
+          enum: [PENDING, RECEIVED, STARTED, RETRY, SUCCESS, FAILURE]
+        step:
+          type: string
+          description: Step in progress, for pipelines
+        worker:
+          type: string
+        attempts:
+          type: integer
+        started_at:
+          type: string
+          format: date-time
+        finished_at:
+          type: string
+          format: date-time
+        error:
+          type: string
+        output:
+          type: string
+          description: Only given with the results
+        metrics:
+          type: object
+          description: Only given with the results
+          additionalProperties:
+            type: number
+    Summary:
+      type: object
+      description: Only given with the results
+      properties:
+        group_uuid:
+          type: string
+        instances:
+          type: integer
+        succeeded:
+          type: integer
+        failures:
+          type: array
+          items:
+            type: object
+            properties:
+              task_uuid:
+                type: string
+              error:
+                type: string
+        metrics:
+          type: object
+          additionalProperties:
+            type: object
+            properties:
+              count:
+                type: integer
+              mean:
+                type: number
+              min:
+                type: number
+              max:
+                type: number
+              stddev:
+                type: number
+    Worker:
+      type: object
+      properties:
+        id:
+          type: string
+        hostname:
+          type: string
+        queue:
+          type: string
+        private_queue:
+          type: string
+        busy:
+          type: integer
+        last_seen:
+          type: string
+          format: date-time
+`
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+	"github.com/satori/go.uuid"
+)
+
+// Names of the steps of a pipeline, the run step is the one reported
+const (
+	prepareStep = "prepare"
+	runStep     = "run"
+	cleanupStep = "cleanup"
+)
+
+// pipelineSteps returns the commands of the prepare, run and cleanup steps,
+// by default the base command followed by the name of the step
+func pipelineSteps(base, prepare, run, cleanup string) ([]string, error) {
+	steps := []string{prepare, run, cleanup}
+
+	for i, name := range []string{prepareStep, runStep, cleanupStep} {
+		if steps[i] != "" {
+			continue
+		}
+
+		if base == "" {
+			return nil, errors.New("A command is required unless every step is given")
+		}
+
+		steps[i] = strings.TrimSpace(base) + " " + name
+	}
+
+	return steps, nil
+}
+
+// sendPipeline sends `times` pipelines. Each one is a chain whose first
+// step is pinned: the worker running it runs the following steps too
+func sendPipeline(steps []string) error {
+	groupedTasks, info, err := newPipelineGroup(steps, flagOptions())
+
+	if err != nil {
+		return err
+	}
+
+	server, store, err := startStoreClient("send_pipeline")
+
+	if err != nil {
+		return err
+	}
+
+	return dispatch(server, store, groupedTasks, nil, info)
+}
+
+// newPipelineGroup makes a group of pipelines and its description
+func newPipelineGroup(steps []string, opts *groupOptions) (*tasks.Group, *benchdrilltasks.GroupInfo, error) {
+	// The group is made of the first steps, which complete long before the pipelines
+	if opts.Aggregate {
+		return nil, nil, errors.New("Pipelines cannot be aggregated by workers")
+	}
+
+	info := &benchdrilltasks.GroupInfo{
+		TaskName:  "pipeline",
+		Command:   steps[1],
+		CreatedAt: time.Now().UTC(),
+		Labels:    opts.Labels,
+	}
+
+	var firstSteps []*tasks.Signature
+
+	for i := 0; i < opts.Times; i++ {
+		prepare, instance := newPipeline(steps, opts)
+		info.Instances = append(info.Instances, instance)
+		firstSteps = append(firstSteps, prepare)
+	}
+
+	// The group is made of the first steps, the others are sent by workers
+	groupedTasks := tasks.NewGroup(firstSteps...)
+	info.GroupUUID = groupedTasks.GroupUUID
+
+	return groupedTasks, info, nil
+}
+
+// newPipeline builds the chain of an instance: the cleanup follows the run
+// step whether it succeeded or not, and follows the prepare step if it
+// failed. Only one of these cleanups runs, so they share the same UUID
+func newPipeline(steps []string, opts *groupOptions) (*tasks.Signature, *benchdrilltasks.InstanceInfo) {
+	newStep := func(name, cmd string) *tasks.Signature {
+		return &tasks.Signature{
+			Name: name,
+			Args: []tasks.Arg{
+				{
+					Type:  "string",
+					Value: cmd,
+				},
+			},
+			// Steps do not get the output of the previous step
+			Immutable:    true,
+			Headers:      benchdrilltasks.SetLimits(nil, opts.Limits),
+			RetryCount:   opts.Retries,
+			RetryTimeout: retryTimeout(opts.RetryDelay),
+		}
+	}
+
+	prepare := newStep("task_args", steps[0])
+	prepare.UUID = fmt.Sprintf("task_%v", uuid.NewV4())
+	prepare.Headers = benchdrilltasks.SetLimits(tasks.Headers{benchdrilltasks.PinHeader: true}, opts.Limits)
+
+	run := newStep("task_args", steps[1])
+	run.UUID = fmt.Sprintf("task_%v", uuid.NewV4())
+	// Artifacts are collected after the run step
+	run.Headers = benchdrilltasks.SetLimits(artifactsHeaders(opts.Artifacts), opts.Limits)
+
+	cleanup := newStep("task_args", steps[2])
+	cleanup.UUID = fmt.Sprintf("task_%v", uuid.NewV4())
+
+	// Error callbacks get the error first, task_cleanup ignores it
+	cleanupOnError := newStep("task_cleanup", steps[2])
+	cleanupOnError.UUID = cleanup.UUID
+
+	prepare.OnSuccess = []*tasks.Signature{run}
+	prepare.OnError = []*tasks.Signature{cleanupOnError}
+	run.OnSuccess = []*tasks.Signature{cleanup}
+	run.OnError = []*tasks.Signature{cleanupOnError}
+
+	instance := &benchdrilltasks.InstanceInfo{
+		TaskUUID: run.UUID,
+		Steps: []*benchdrilltasks.StepInfo{
+			{Name: prepareStep, TaskUUID: prepare.UUID},
+			{Name: runStep, TaskUUID: run.UUID},
+			{Name: cleanupStep, TaskUUID: cleanup.UUID},
+		},
+	}
+
+	return prepare, instance
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+
+	"github.com/urfave/cli"
+)
+
+// TimedOutState marks an instance whose result did not arrive before the deadline
+const TimedOutState = "TIMEOUT"
+
+// Bits of the exit code reflecting the outcome of a run
+const (
+	exitFailed    = 2 // at least one instance failed
+	exitNoResults = 4 // at least one instance timed out or is still pending
+)
+
+// instanceResult is the outcome of one instance of a group
+type instanceResult struct {
+	Index    int
+	TaskUUID string
+	State    string
+	Results  []reflect.Value
+	Error    string
+	// Steps of a pipeline, kept for diagnostics
+	Steps []*stepState
+	// Number of times the instance has been run, 0 if unknown
+	Attempts int
+	// Worker which ran the instance and its group, if known
+	Worker    string
+	GroupUUID string
+	// Time the result was stored by the worker, if known
+	FinishedAt time.Time
+}
+
+// Flaky returns true if the instance only succeeded after being retried
+func (r *instanceResult) Flaky() bool {
+	return r.State == tasks.SuccessState && r.Attempts > 1
+}
+
+// log returns the logger of the lines about the instance
+func (r *instanceResult) log() *benchdrilltasks.Logger {
+	return benchdrilltasks.Log.Task(r.TaskUUID, r.GroupUUID).With(benchdrilltasks.Fields{benchdrilltasks.LogWorker: r.Worker})
+}
+
+// newInstanceResults converts the states of the instances of a group, the
+// instances which have not completed get the given state
+func newInstanceResults(states []*instanceState, notCompleted string) ([]*instanceResult, error) {
+	results := make([]*instanceResult, len(states))
+
+	for i, state := range states {
+		result := &instanceResult{
+			Index:    i,
+			TaskUUID: state.TaskUUID,
+			State:    state.State,
+			Error:    state.Error,
+			Steps:    state.Steps,
+		}
+
+		switch {
+		case state.IsSuccess():
+			values, err := reflectResults(state.Results)
+
+			if err != nil {
+				return nil, err
+			}
+
+			result.Results = values
+		case !state.IsFailure() && notCompleted != "":
+			result.State = notCompleted
+		}
+
+		results[i] = result
+	}
+
+	return results, nil
+}
+
+// report prints the output of the successful instances, the errors of the
+// failed ones and a summary. The returned error carries an exit code
+// reflecting the mix of outcomes
+func report(store benchdrilltasks.Store, results []*instanceResult) error {
+	var succeeded, failed, noResult, flaky int
+
+	for _, result := range results {
+		if meta, err := benchdrilltasks.GetTaskMeta(store, result.TaskUUID); err == nil {
+			result.Attempts, result.Worker, result.GroupUUID = meta.Attempts, meta.Worker, meta.GroupUUID
+			result.FinishedAt = meta.FinishedAt
+		}
+
+		switch result.State {
+		case tasks.SuccessState:
+			succeeded++
+			printResults(result)
+
+			if result.Flaky() {
+				flaky++
+			}
+		case tasks.FailureState:
+			failed++
+		default:
+			noResult++
+		}
+	}
+
+	for _, result := range results {
+		switch result.State {
+		case tasks.SuccessState:
+			if result.Flaky() {
+				result.log().Warningf("Instance %d (%s) is flaky: it only succeeded after %d attempts", result.Index, result.TaskUUID, result.Attempts)
+			}
+		case tasks.FailureState:
+			result.log().Errorf("Instance %d (%s) failed after %d attempts with error: %s", result.Index, result.TaskUUID, result.Attempts, result.Error)
+			printSteps(result)
+		case TimedOutState:
+			result.log().Warningf("Instance %d (%s) timed out", result.Index, result.TaskUUID)
+		default:
+			result.log().Warningf("Instance %d (%s) is still %s", result.Index, result.TaskUUID, result.State)
+		}
+	}
+
+	summary := fmt.Sprintf("%d succeeded (%d flaky), %d failed, %d timed out or pending", succeeded, flaky, failed, noResult)
+	benchdrilltasks.Log.Info(summary)
+
+	code := 0
+
+	if failed > 0 {
+		code |= exitFailed
+	}
+
+	if noResult > 0 {
+		code |= exitNoResults
+	}
+
+	if code != 0 {
+		return cli.NewExitError("Not all instances succeeded: "+summary, code)
+	}
+
+	return nil
+}
+
+// reflectResults converts stored task results to values, as AsyncResult.Get does
+func reflectResults(taskResults []*tasks.TaskResult) ([]reflect.Value, error) {
+	values := make([]reflect.Value, len(taskResults))
+
+	for i, result := range taskResults {
+		value, err := tasks.ReflectValue(result.Type, result.Value)
+
+		if err != nil {
+			return nil, err
+		}
+
+		values[i] = value
+	}
+
+	return values, nil
+}
+
+// printSteps prints the outcome of each step of a failed pipeline
+func printSteps(result *instanceResult) {
+	for _, step := range result.Steps {
+		switch {
+		case step.State == nil:
+			result.log().Errorf("Instance %d, %s step: not run", result.Index, step.Name)
+		case step.State.IsSuccess():
+			values, _ := reflectResults(step.State.Results)
+
+			for _, value := range values {
+				result.log().Errorf("Instance %d, %s step output: %v", result.Index, step.Name, value.Interface())
+			}
+		default:
+			result.log().Errorf("Instance %d, %s step %s: %s", result.Index, step.Name, step.State.State, step.State.Error)
+		}
+	}
+}
+
+func printResults(result *instanceResult) {
+	for _, value := range result.Results {
+		result.log().Infof("%v", value.Interface())
+	}
+}
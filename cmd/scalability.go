@@ -0,0 +1,290 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+
+	"github.com/urfave/cli"
+)
+
+// Metrics which are rates, summed over the parallel instances to get the
+// throughput of a level, in order of preference
+var throughputMetrics = []string{
+	"cpu_speed.events_per_second",
+	"file_operations.reads_s",
+	"file_operations.writes_s",
+	"throughput.read_mib_s",
+	"throughput.written_mib_s",
+	"filebench.ops_per_s",
+	"filebench.mb_per_s",
+}
+
+// scalabilityOptions are the options of a scalability study
+type scalabilityOptions struct {
+	Levels []int
+	// Groups run at each level
+	Repeat int
+	// Metric summed over the instances, "" for the main rate of the benchmark
+	Metric string
+	// Efficiency below which the throughput per instance degraded
+	MinEfficiency float64
+	// Engine scaling the service of the workers to each level, nil to run
+	// the levels as parallel instances on the workers as they are
+	Docker  benchdrilltasks.DockerAPI
+	Service string
+	Wait    time.Duration
+}
+
+// scalabilityRun is a repetition of a level of a scalability study
+type scalabilityRun struct {
+	level     int
+	groupUUID string
+	summary   *benchdrilltasks.Summary
+	// From the start of the first instance to the end of the last one
+	elapsed time.Duration
+}
+
+// scalability runs a command at several levels of concurrency, a group of
+// as many parallel instances as the level, repeated, one group after
+// another, and reports how the throughput scales with the level
+func scalability(cmd string, opts *scalabilityOptions) error {
+	if detach {
+		return fmt.Errorf("Scalability studies cannot be detached, their groups are sent one after another")
+	}
+
+	if cmd == "" {
+		return fmt.Errorf("No command given")
+	}
+
+	if opts.Repeat < 1 {
+		return fmt.Errorf("Invalid number of repetitions %d: expected at least 1", opts.Repeat)
+	}
+
+	server, store, err := startStoreClient("scalability")
+
+	if err != nil {
+		return err
+	}
+
+	var runs []*scalabilityRun
+
+	for _, level := range opts.Levels {
+		if opts.Docker != nil {
+			if err := opts.Docker.ScaleService(opts.Service, level); err != nil {
+				return fmt.Errorf("Could not scale service %s: %s", opts.Service, err.Error())
+			}
+
+			benchdrilltasks.Log.Infof("Service %s scaled to %d replicas", opts.Service, level)
+
+			if _, err := waitWorkers(store, level, opts.Wait); err != nil {
+				return err
+			}
+		}
+
+		for repetition := 1; repetition <= opts.Repeat; repetition++ {
+			groupOpts := flagOptions()
+			groupOpts.Times = level
+
+			groupedTasks, chord, info := newTaskGroup("task_args", []tasks.Arg{
+				{
+					Type:  "string",
+					Value: cmd,
+				},
+			}, groupOpts)
+
+			if err := sendGroup(server, store, groupedTasks, chord, info); err != nil {
+				return err
+			}
+
+			benchdrilltasks.Log.Group(info.GroupUUID).Infof("Level %d, repetition %d/%d (group %s)", level, repetition, opts.Repeat, info.GroupUUID)
+
+			summary, err := waitSummary(server, store, info)
+
+			if err != nil {
+				return err
+			}
+
+			runs = append(runs, &scalabilityRun{
+				level:     level,
+				groupUUID: info.GroupUUID,
+				summary:   summary,
+				elapsed:   groupElapsed(store, info),
+			})
+		}
+	}
+
+	return reportScalability(opts, runs)
+}
+
+// groupElapsed returns the time from the start of the first instance of a
+// group to the end of the last one, 0 if unknown
+func groupElapsed(store benchdrilltasks.Store, info *benchdrilltasks.GroupInfo) time.Duration {
+	var first, last time.Time
+
+	for _, instance := range info.Instances {
+		meta, err := benchdrilltasks.GetTaskMeta(store, instance.TaskUUID)
+
+		if err != nil || meta.FinishedAt.IsZero() {
+			continue
+		}
+
+		if first.IsZero() || meta.StartedAt.Before(first) {
+			first = meta.StartedAt
+		}
+
+		if meta.FinishedAt.After(last) {
+			last = meta.FinishedAt
+		}
+	}
+
+	return last.Sub(first)
+}
+
+// throughput returns the throughput of a run: the sum of the metric over its
+// instances or, without metric, the instances which succeeded per second
+func (r *scalabilityRun) throughput(metric string) (float64, bool) {
+	if metric == "" {
+		if r.elapsed <= 0 || r.summary.Succeeded == 0 {
+			return 0, false
+		}
+
+		return float64(r.summary.Succeeded) / r.elapsed.Seconds(), true
+	}
+
+	a, ok := r.summary.Metrics[metric]
+
+	if !ok || a.Count == 0 {
+		return 0, false
+	}
+
+	return a.Mean * float64(a.Count), true
+}
+
+// reportScalability prints one row per level with its throughput, speedup
+// and efficiency, and flags the level from which the throughput per
+// instance degrades. As for report, the returned error carries the exit code
+func reportScalability(opts *scalabilityOptions, runs []*scalabilityRun) error {
+	metric := opts.Metric
+
+	if metric == "" {
+		metric = scalabilityMetric(runs)
+	}
+
+	if metric != "" {
+		benchdrilltasks.Log.Infof("Throughput: sum of %s over the instances", metric)
+	} else {
+		benchdrilltasks.Log.Warning("No rate reported by the benchmark, throughput: instances which succeeded per second")
+	}
+
+	throughputs := make([][]float64, len(opts.Levels))
+	succeeded := make([]int, len(opts.Levels))
+	instances := make([]int, len(opts.Levels))
+
+	var failed, timedOut int
+
+	for _, run := range runs {
+		i := 0
+
+		for opts.Levels[i] != run.level {
+			i++
+		}
+
+		if value, ok := run.throughput(metric); ok {
+			throughputs[i] = append(throughputs[i], value)
+		}
+
+		succeeded[i] += run.summary.Succeeded
+		instances[i] += run.summary.Instances
+
+		for _, failure := range run.summary.Failures {
+			benchdrilltasks.Log.Task(failure.TaskUUID, run.groupUUID).Errorf("Instance %s (level %d) failed with error: %s", failure.TaskUUID, run.level, failure.Error)
+		}
+
+		failed += len(run.summary.Failures)
+		timedOut += run.summary.Instances - run.summary.Succeeded - len(run.summary.Failures)
+	}
+
+	points := benchdrilltasks.Scalability(opts.Levels, throughputs, opts.MinEfficiency)
+
+	level := "INSTANCES"
+
+	if opts.Docker != nil {
+		level = "REPLICAS"
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join([]string{level, "OK", "THROUGHPUT", "PER INSTANCE", "SPEEDUP", "EFFICIENCY", ""}, "\t"))
+
+	for i, p := range points {
+		cells := []string{fmt.Sprint(p.Level), fmt.Sprintf("%d/%d", succeeded[i], instances[i])}
+
+		if p.Throughput.Count == 0 {
+			cells = append(cells, "-", "-", "-", "-", "")
+		} else {
+			note := ""
+
+			if p.Degraded {
+				note = "degraded"
+			}
+
+			cells = append(cells,
+				fmt.Sprintf("%.3f ±%.3f", p.Throughput.Mean, p.Throughput.Stddev),
+				fmt.Sprintf("%.3f", p.PerInstance),
+				fmt.Sprintf("%.2fx", p.Speedup),
+				fmt.Sprintf("%.0f%%", 100*p.Efficiency),
+				note)
+		}
+
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+	}
+
+	w.Flush()
+
+	if points[0].Throughput.Mean == 0 {
+		benchdrilltasks.Log.Warningf("No throughput measured at level %d, the speedup cannot be computed", points[0].Level)
+	} else if p := benchdrilltasks.DegradationPoint(points); p != nil {
+		benchdrilltasks.Log.Warningf("Throughput per instance degrades at level %d: efficiency %.0f%%, below %.0f%%", p.Level, 100*p.Efficiency, 100*opts.MinEfficiency)
+	} else {
+		benchdrilltasks.Log.Infof("Efficiency stays above %.0f%% up to level %d", 100*opts.MinEfficiency, opts.Levels[len(opts.Levels)-1])
+	}
+
+	message := fmt.Sprintf("%d levels, %d instances failed, %d timed out or pending", len(opts.Levels), failed, timedOut)
+	benchdrilltasks.Log.Info(message)
+
+	code := 0
+
+	if failed > 0 {
+		code |= exitFailed
+	}
+
+	if timedOut > 0 {
+		code |= exitNoResults
+	}
+
+	if code != 0 {
+		return cli.NewExitError("Not all instances succeeded: "+message, code)
+	}
+
+	return nil
+}
+
+// scalabilityMetric picks the first rate reported by the runs of a study,
+// "" if there is none
+func scalabilityMetric(runs []*scalabilityRun) string {
+	for _, name := range throughputMetrics {
+		for _, run := range runs {
+			if _, ok := run.summary.Metrics[name]; ok {
+				return name
+			}
+		}
+	}
+
+	return ""
+}
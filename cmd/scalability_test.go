@@ -0,0 +1,33 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestScalability(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	out, code := h.run("", "scalability", "--levels", "1,2", "--repeat", "2", "sysbench cpu run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	// Every instance of the fake sysbench reports 1234.56 events per second
+	for _, row := range []string{`1\s+2/2\s+1234\.560 ±0\.000\s+1234\.560\s+1\.00x\s+100%`, `2\s+4/4\s+2469\.120 ±0\.000\s+1234\.560\s+2\.00x\s+100%`} {
+		if !regexp.MustCompile(row).MatchString(out) {
+			t.Errorf("Expected a row matching %s:\n%s", row, out)
+		}
+	}
+
+	if strings.Contains(out, "degraded") {
+		t.Errorf("Expected no degradation:\n%s", out)
+	}
+
+	if _, code := h.run("", "scalability", "--levels", "1", "--repeat", "1", "sysbench fail"); code != exitFailed {
+		t.Errorf("Exit code %d, expected %d", code, exitFailed)
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// Service of the workers, as deployed by stack/benchdrill-deploy
+const defaultWorkerService = "benchdrill_worker"
+
+// scale sets the replicas of the service of the workers through the Docker
+// Engine API then, unless wait is 0, waits until as many workers are
+// registered and idle, so that a run sent next gets all of them
+func scale(docker benchdrilltasks.DockerAPI, service, replicasArg string, wait time.Duration) error {
+	replicas, err := strconv.Atoi(replicasArg)
+
+	if err != nil || replicas < 0 {
+		return fmt.Errorf("Invalid number of replicas %q: expected a positive integer or 0", replicasArg)
+	}
+
+	_, store, err := startStoreClient("scale")
+
+	if err != nil {
+		return err
+	}
+
+	previous, err := docker.ServiceReplicas(service)
+
+	if err != nil {
+		return fmt.Errorf("Could not get service %s: %s", service, err.Error())
+	}
+
+	if err := docker.ScaleService(service, replicas); err != nil {
+		return fmt.Errorf("Could not scale service %s: %s", service, err.Error())
+	}
+
+	benchdrilltasks.Log.Infof("Service %s scaled from %d to %d replicas", service, previous, replicas)
+
+	if wait == 0 {
+		return nil
+	}
+
+	start := time.Now()
+	workers, err := waitWorkers(store, replicas, wait)
+
+	if err != nil {
+		return err
+	}
+
+	benchdrilltasks.Log.Infof("%d workers registered and idle after %s", replicas, time.Since(start).Round(time.Second))
+
+	sort.Slice(workers, func(i, j int) bool {
+		return workers[i].ID < workers[j].ID
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "WORKER\tHOSTNAME\tLAST SEEN")
+
+	for _, worker := range workers {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", worker.ID, worker.Hostname, worker.LastSeen.Local().Format("15:04:05"))
+	}
+
+	return w.Flush()
+}
+
+// waitWorkers waits until as many workers as given are registered and idle,
+// as of their last heartbeat, and returns them
+func waitWorkers(store benchdrilltasks.Store, count int, wait time.Duration) ([]*benchdrilltasks.WorkerInfo, error) {
+	var (
+		workers []*benchdrilltasks.WorkerInfo
+		busy    int
+		err     error
+	)
+
+	ready := poll(wait, func() bool {
+		if workers, err = benchdrilltasks.ListWorkers(store); err != nil {
+			benchdrilltasks.Log.Warningf("Could not list workers: %s", err.Error())
+			return false
+		}
+
+		busy = 0
+
+		for _, worker := range workers {
+			if worker.Busy > 0 {
+				busy++
+			}
+		}
+
+		benchdrilltasks.Log.Debugf("%d workers registered, %d busy", len(workers), busy)
+
+		return len(workers) == count && busy == 0
+	})
+
+	if !ready {
+		return nil, fmt.Errorf("Timed out after %s waiting for %d idle workers: %d registered, %d busy", wait, count, len(workers), busy)
+	}
+
+	return workers, nil
+}
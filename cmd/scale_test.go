@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeDocker is a stand-in for the Docker Engine API of a manager, serving a
+// replicated service
+type fakeDocker struct {
+	*httptest.Server
+	mu       sync.Mutex
+	replicas int
+	version  int
+	// Fields of the spec the client does not know about, sent back as is
+	labels map[string]interface{}
+}
+
+func newFakeDocker(t *testing.T, socket string, replicas int) *fakeDocker {
+	d := &fakeDocker{replicas: replicas, version: 10, labels: map[string]interface{}{"com.docker.stack.namespace": "benchdrill"}}
+
+	listener, err := net.Listen("unix", socket)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	d.Server = httptest.NewUnstartedServer(http.HandlerFunc(d.serve))
+	d.Server.Listener = listener
+	d.Server.Start()
+
+	return d
+}
+
+func (d *fakeDocker) serve(w http.ResponseWriter, r *http.Request) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/v1.25/services/benchdrill_worker":
+		fmt.Fprintf(w, `{"ID":"svc1","Version":{"Index":%d},"Spec":{"Name":"benchdrill_worker","Labels":{"com.docker.stack.namespace":"benchdrill"},"Mode":{"Replicated":{"Replicas":%d}}}}`, d.version, d.replicas)
+	case r.Method == "POST" && r.URL.Path == "/v1.25/services/svc1/update":
+		if r.URL.Query().Get("version") != fmt.Sprint(d.version) {
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, `{"message":"update out of sequence"}`)
+			return
+		}
+
+		var spec struct {
+			Labels map[string]interface{}
+			Mode   struct{ Replicated struct{ Replicas int } }
+		}
+
+		body, _ := ioutil.ReadAll(r.Body)
+
+		if err := json.Unmarshal(body, &spec); err != nil || fmt.Sprint(spec.Labels) != fmt.Sprint(d.labels) {
+			w.WriteHeader(http.StatusBadRequest)
+			fmt.Fprintf(w, `{"message":"invalid spec %s"}`, body)
+			return
+		}
+
+		d.replicas = spec.Mode.Replicated.Replicas
+		d.version++
+		fmt.Fprint(w, `{"Warnings":null}`)
+	default:
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprintf(w, `{"message":"service %s not found"}`, filepath.Base(r.URL.Path))
+	}
+}
+
+func TestScale(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	socket := filepath.Join(h.dir, "docker.sock")
+	docker := newFakeDocker(t, socket, 1)
+	defer docker.Close()
+
+	out, code := h.run("", "scale", "--docker-host", "unix://"+socket, "2")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	if docker.replicas != 2 || docker.version != 11 {
+		t.Errorf("Service has %d replicas at version %d, expected 2 at version 11", docker.replicas, docker.version)
+	}
+
+	if !strings.Contains(out, "test_0") || !strings.Contains(out, "test_1") {
+		t.Errorf("Expected the workers to be reported:\n%s", out)
+	}
+
+	// Only 2 workers ever register
+	if _, code := h.run("", "scale", "--docker-host", "unix://"+socket, "--wait", "300ms", "3"); code != 1 {
+		t.Errorf("Exit code %d, expected 1 once the wait timed out", code)
+	}
+
+	if docker.replicas != 3 {
+		t.Errorf("Service has %d replicas, expected 3", docker.replicas)
+	}
+
+	if _, code := h.run("", "scale", "--docker-host", "unix://"+socket, "--service", "other", "2"); code != 1 {
+		t.Errorf("Exit code %d, expected 1 for an unknown service", code)
+	}
+
+	if _, code := h.run("", "scale", "--docker-host", "unix://"+socket, "many"); code != 1 {
+		t.Errorf("Exit code %d, expected 1 for an invalid number of replicas", code)
+	}
+}
@@ -0,0 +1,429 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/satori/go.uuid"
+)
+
+// Period at which the schedulers look for the runs which are due
+const scheduleTick = 10 * time.Second
+
+var scheduleIDRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// addSchedule stores a schedule of a job, which is checked by building it
+// once. The input files given with --file and --dir are kept with the
+// schedule
+func addSchedule(cronSpec, timezone, id, overlap, workload string, req *jobRequest) error {
+	if req.Command == "" {
+		return errors.New("A command is required")
+	}
+
+	schedule := &benchdrilltasks.Schedule{
+		ID:        id,
+		Cron:      cronSpec,
+		Timezone:  timezone,
+		Overlap:   overlap,
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if schedule.ID == "" {
+		schedule.ID = fmt.Sprintf("schedule_%.8s", uuid.NewV4())
+	}
+
+	if !scheduleIDRegexp.MatchString(schedule.ID) {
+		return fmt.Errorf("Invalid schedule ID %q: only letters, digits, '.', '_' and '-' are allowed", schedule.ID)
+	}
+
+	switch overlap {
+	case benchdrilltasks.OverlapSkip, benchdrilltasks.OverlapQueue, benchdrilltasks.OverlapAllow:
+	default:
+		return fmt.Errorf("Invalid overlap policy %q: expected skip, queue or allow", overlap)
+	}
+
+	cron, err := benchdrilltasks.ParseCron(cronSpec)
+
+	if err != nil {
+		return err
+	}
+
+	loc, err := schedule.Location()
+
+	if err != nil {
+		return fmt.Errorf("Invalid time zone: %s", err.Error())
+	}
+
+	if workload != "" {
+		content, err := ioutil.ReadFile(workload)
+
+		if err != nil {
+			return err
+		}
+
+		req.File = string(content)
+	}
+
+	if _, _, _, err := newJobGroup(req); err != nil {
+		return err
+	}
+
+	if schedule.Job, err = json.Marshal(req); err != nil {
+		return err
+	}
+
+	if len(inputFiles) > 0 || len(inputDirs) > 0 {
+		files, err := benchdrilltasks.BundleFiles(inputFiles, inputDirs)
+
+		if err != nil {
+			return err
+		}
+
+		if schedule.Bundle, schedule.BundleHash, err = benchdrilltasks.PackBundle(files); err != nil {
+			return err
+		}
+
+		schedule.BundleFiles = len(files)
+	}
+
+	_, store, err := startStoreClient("schedule")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := benchdrilltasks.GetSchedule(store, schedule.ID); err == nil {
+		return fmt.Errorf("Schedule %s already exists", schedule.ID)
+	}
+
+	if err := benchdrilltasks.SaveSchedule(store, schedule); err != nil {
+		return fmt.Errorf("Could not save schedule: %s", err.Error())
+	}
+
+	fmt.Println(schedule.ID)
+	benchdrilltasks.Log.Infof("Schedule %s added, next run at %s", schedule.ID, cron.Next(time.Now().In(loc)).Format(time.RFC1123))
+
+	return nil
+}
+
+// listSchedules prints the schedules, when they run next and their last run
+func listSchedules() error {
+	_, store, err := startStoreClient("schedule")
+
+	if err != nil {
+		return err
+	}
+
+	schedules, err := benchdrilltasks.ListSchedules(store)
+
+	if err != nil {
+		return fmt.Errorf("Could not list schedules: %s", err.Error())
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tCRON\tOVERLAP\tNEXT RUN\tLAST RUN\tLAST GROUP\tNOTES\tCOMMAND")
+
+	for _, schedule := range schedules {
+		req := new(jobRequest)
+		json.Unmarshal(schedule.Job, req)
+
+		state, err := benchdrilltasks.GetScheduleState(store, schedule.ID)
+
+		if err != nil {
+			state = &benchdrilltasks.ScheduleState{LastDue: schedule.CreatedAt}
+		}
+
+		next, lastRun, lastGroup := "-", "-", "-"
+
+		cron, cronErr := benchdrilltasks.ParseCron(schedule.Cron)
+		loc, locErr := schedule.Location()
+
+		if cronErr == nil && locErr == nil {
+			if t := cron.Next(state.LastDue.In(loc)); !t.IsZero() {
+				next = t.Format("2006-01-02 15:04 MST")
+			}
+		}
+
+		if !state.LastRun.IsZero() {
+			lastRun = state.LastRun.In(time.Local).Format("2006-01-02 15:04 MST")
+			lastGroup = state.LastGroup
+		}
+
+		var notes []string
+
+		if state.Pending {
+			notes = append(notes, "run pending")
+		}
+
+		if state.Skipped > 0 {
+			notes = append(notes, fmt.Sprintf("%d skipped", state.Skipped))
+		}
+
+		if state.LastError != "" {
+			notes = append(notes, "error: "+firstLine(state.LastError))
+		}
+
+		if len(notes) == 0 {
+			notes = append(notes, "-")
+		}
+
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", schedule.ID, schedule.Cron, schedule.Overlap, next, lastRun, lastGroup, strings.Join(notes, ", "), req.Command)
+	}
+
+	return w.Flush()
+}
+
+// removeSchedule removes a schedule, the groups it submitted are kept
+func removeSchedule(id string) error {
+	if id == "" {
+		return errors.New("A schedule ID is required")
+	}
+
+	_, store, err := startStoreClient("schedule")
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := benchdrilltasks.GetSchedule(store, id); err != nil {
+		return fmt.Errorf("Could not get schedule %s: %s", id, err.Error())
+	}
+
+	if err := benchdrilltasks.DeleteSchedule(store, id); err != nil {
+		return fmt.Errorf("Could not remove schedule %s: %s", id, err.Error())
+	}
+
+	benchdrilltasks.Log.Infof("Schedule %s removed", id)
+
+	return nil
+}
+
+// scheduler submits the runs of the schedules which are due. Any number of
+// schedulers may run: each run is claimed by one of them
+type scheduler struct {
+	server *machinery.Server
+	store  benchdrilltasks.Store
+}
+
+// runScheduler checks the schedules every tick, forever
+func runScheduler(server *machinery.Server, store benchdrilltasks.Store, tick time.Duration) {
+	s := &scheduler{server: server, store: store}
+
+	benchdrilltasks.Log.Infof("Running the scheduler, every %s", tick)
+
+	for {
+		s.tick(time.Now())
+		time.Sleep(tick)
+	}
+}
+
+func (s *scheduler) tick(now time.Time) {
+	schedules, err := benchdrilltasks.ListSchedules(s.store)
+
+	if err != nil {
+		benchdrilltasks.Log.Warningf("Could not list schedules: %s", err.Error())
+		return
+	}
+
+	for _, schedule := range schedules {
+		if err := s.handle(schedule, now); err != nil {
+			benchdrilltasks.Log.Warningf("Schedule %s: %s", schedule.ID, err.Error())
+		}
+	}
+}
+
+// handle submits the run of a schedule which waited for the previous run to
+// complete, then the run which is due, if any, unless it overlaps. Several
+// schedulers may handle a schedule at once: the runs they claimed change the
+// state as it is when saved, rather than as it was read
+func (s *scheduler) handle(schedule *benchdrilltasks.Schedule, now time.Time) error {
+	cron, err := benchdrilltasks.ParseCron(schedule.Cron)
+
+	if err != nil {
+		return err
+	}
+
+	loc, err := schedule.Location()
+
+	if err != nil {
+		return err
+	}
+
+	state, err := benchdrilltasks.GetScheduleState(s.store, schedule.ID)
+
+	if err == benchdrilltasks.ErrNotFound {
+		state = &benchdrilltasks.ScheduleState{LastDue: schedule.CreatedAt}
+	} else if err != nil {
+		return err
+	}
+
+	running, err := s.running(state.LastGroup)
+
+	if err != nil {
+		return err
+	}
+
+	// Changes to the state, applied to the state read as well for the
+	// following decisions
+	var changes []func(state *benchdrilltasks.ScheduleState)
+
+	change := func(c func(state *benchdrilltasks.ScheduleState)) {
+		c(state)
+		changes = append(changes, c)
+	}
+
+	if state.Pending && !running {
+		claimed, err := benchdrilltasks.ClaimScheduleRun(s.store, schedule.ID, "after_"+state.LastGroup)
+
+		if err != nil {
+			return err
+		}
+
+		if claimed {
+			change(func(state *benchdrilltasks.ScheduleState) { state.Pending = false })
+			change(s.submit(schedule, now))
+			running = state.LastError == ""
+		}
+	}
+
+	due := cron.Latest(state.LastDue.In(loc), now.In(loc))
+
+	if !due.IsZero() {
+		claimed, err := benchdrilltasks.ClaimScheduleRun(s.store, schedule.ID, strconv.FormatInt(due.Unix(), 10))
+
+		if err != nil {
+			return err
+		}
+
+		if claimed {
+			change(func(state *benchdrilltasks.ScheduleState) {
+				if due.After(state.LastDue) {
+					state.LastDue = due
+				}
+			})
+			change(s.due(schedule, state, running, due, now))
+		}
+	}
+
+	if len(changes) == 0 {
+		return nil
+	}
+
+	return benchdrilltasks.UpdateScheduleState(s.store, schedule.ID, func(saved *benchdrilltasks.ScheduleState) {
+		if saved.LastDue.IsZero() {
+			saved.LastDue = schedule.CreatedAt
+		}
+
+		for _, c := range changes {
+			c(saved)
+		}
+	})
+}
+
+// due handles a run which is due according to the overlap policy, it
+// returns the change to the state of the schedule
+func (s *scheduler) due(schedule *benchdrilltasks.Schedule, state *benchdrilltasks.ScheduleState, running bool, due, now time.Time) func(*benchdrilltasks.ScheduleState) {
+	switch {
+	case !running || schedule.Overlap == benchdrilltasks.OverlapAllow:
+		return s.submit(schedule, now)
+	case schedule.Overlap == benchdrilltasks.OverlapQueue && !state.Pending:
+		benchdrilltasks.Log.Group(state.LastGroup).Infof("Schedule %s: run due at %s waits for group %s", schedule.ID, due.Format(time.RFC1123), state.LastGroup)
+
+		return func(state *benchdrilltasks.ScheduleState) { state.Pending = true }
+	default:
+		benchdrilltasks.Log.Group(state.LastGroup).Warningf("Schedule %s: run due at %s skipped, group %s is still running", schedule.ID, due.Format(time.RFC1123), state.LastGroup)
+
+		return func(state *benchdrilltasks.ScheduleState) { state.Skipped++ }
+	}
+}
+
+// submit sends a run of a schedule, it returns the change to the state of
+// the schedule recording the run or its error
+func (s *scheduler) submit(schedule *benchdrilltasks.Schedule, now time.Time) func(*benchdrilltasks.ScheduleState) {
+	groupUUID, err := submitSchedule(s.server, s.store, schedule)
+
+	if err != nil {
+		benchdrilltasks.Log.Errorf("Schedule %s: could not submit run: %s", schedule.ID, err.Error())
+
+		return func(state *benchdrilltasks.ScheduleState) { state.LastError = err.Error() }
+	}
+
+	benchdrilltasks.Log.Group(groupUUID).Infof("Schedule %s: submitted group %s", schedule.ID, groupUUID)
+
+	return func(state *benchdrilltasks.ScheduleState) {
+		state.LastRun, state.LastGroup, state.LastError = now.UTC(), groupUUID, ""
+	}
+}
+
+// running returns whether a group submitted by a schedule is still running,
+// a group which expired has completed
+func (s *scheduler) running(groupUUID string) (bool, error) {
+	if groupUUID == "" {
+		return false, nil
+	}
+
+	if _, err := benchdrilltasks.GetGroupInfo(s.store, groupUUID); err == benchdrilltasks.ErrNotFound {
+		return false, nil
+	}
+
+	states, err := instanceStates(s.server, s.store, groupUUID)
+
+	if err != nil {
+		return false, err
+	}
+
+	for _, state := range states {
+		if !state.IsCompleted() {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// submitSchedule sends the group of a run of a schedule, labelled with the
+// schedule ID
+func submitSchedule(server *machinery.Server, store benchdrilltasks.Store, schedule *benchdrilltasks.Schedule) (string, error) {
+	req := new(jobRequest)
+
+	if err := json.Unmarshal(schedule.Job, req); err != nil {
+		return "", fmt.Errorf("Invalid job: %s", err.Error())
+	}
+
+	if req.Labels == nil {
+		req.Labels = make(map[string]string)
+	}
+
+	req.Labels[benchdrilltasks.ScheduleLabel] = schedule.ID
+
+	groupedTasks, chord, info, err := newJobGroup(req)
+
+	if err != nil {
+		return "", err
+	}
+
+	if len(schedule.Bundle) > 0 {
+		if err := saveBundle(server, store, schedule.BundleHash, schedule.Bundle, schedule.BundleFiles, groupedTasks.GroupUUID); err != nil {
+			return "", err
+		}
+
+		setBundle(groupedTasks, info, schedule.BundleHash)
+	}
+
+	if err := sendGroup(server, store, groupedTasks, chord, info); err != nil {
+		return "", err
+	}
+
+	return info.GroupUUID, nil
+}
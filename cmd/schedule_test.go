@@ -0,0 +1,171 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// waitGroup waits for the group a schedule submitted last to complete
+func waitGroup(t *testing.T, s *scheduler, id string) *benchdrilltasks.ScheduleState {
+	state, err := benchdrilltasks.GetScheduleState(s.store, id)
+
+	if err != nil || state.LastGroup == "" {
+		t.Fatalf("Schedule %s did not submit a group: %v", id, err)
+	}
+
+	if _, completed, _ := waitStates(s.server, s.store, state.LastGroup, 10*time.Second); !completed {
+		t.Fatalf("Group %s did not complete", state.LastGroup)
+	}
+
+	return state
+}
+
+func TestSchedule(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	if _, code := h.run("", "--times", "2", "schedule", "add", "--cron", "* * * * *", "--id", "nightly", "--label", "team=perf", "sysbench cpu run"); code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	if _, code := h.run("", "schedule", "add", "--cron", "* * * * *", "--id", "nightly", "sysbench cpu run"); code != 1 {
+		t.Errorf("Exit code %d adding a schedule twice, expected 1", code)
+	}
+
+	if _, code := h.run("", "schedule", "add", "--cron", "0 25 * * *", "sysbench cpu run"); code != 1 {
+		t.Errorf("Exit code %d for an invalid cron expression, expected 1", code)
+	}
+
+	server, store, err := startClient()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &scheduler{server: server, store: store}
+	now := time.Now().Add(time.Minute)
+
+	// Concurrent schedulers submit a run once
+	s.tick(now)
+	s.tick(now)
+
+	state := waitGroup(t, s, "nightly")
+	info, err := benchdrilltasks.GetGroupInfo(store, state.LastGroup)
+
+	if err != nil || len(info.Instances) != 2 || info.Labels["schedule"] != "nightly" || info.Labels["team"] != "perf" {
+		t.Errorf("Expected a group of 2 instances labelled with the schedule: %+v, %v", info, err)
+	}
+
+	if groups, _ := benchdrilltasks.ListGroups(store); len(groups) != 1 {
+		t.Errorf("%d groups submitted, expected 1", len(groups))
+	}
+
+	out, _ := h.run("", "schedule", "list")
+
+	if !strings.Contains(out, "nightly") || !strings.Contains(out, state.LastGroup) {
+		t.Errorf("Expected the schedule and its last group:\n%s", out)
+	}
+
+	if _, code := h.run("", "schedule", "rm", "nightly"); code != 0 {
+		t.Errorf("Exit code %d, expected 0", code)
+	}
+
+	if schedules, _ := benchdrilltasks.ListSchedules(store); len(schedules) != 0 {
+		t.Errorf("Expected no schedule left, got %d", len(schedules))
+	}
+}
+
+func TestScheduleOverlap(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	for _, overlap := range []string{"skip", "queue"} {
+		if _, code := h.run("", "schedule", "add", "--cron", "* * * * *", "--id", overlap, "--overlap", overlap, "sysbench sleep=1"); code != 0 {
+			t.Fatalf("Exit code %d, expected 0", code)
+		}
+	}
+
+	server, store, err := startClient()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &scheduler{server: server, store: store}
+	now := time.Now().Add(time.Minute)
+
+	// The second run is due while the first one runs
+	s.tick(now)
+	s.tick(now.Add(time.Minute))
+
+	skipped := waitGroup(t, s, "skip")
+	first := waitGroup(t, s, "queue")
+
+	if skipped.Skipped != 1 || !first.Pending {
+		t.Fatalf("Expected a skipped run and a pending run: %+v, %+v", skipped, first)
+	}
+
+	// The pending run is submitted once the first one completed
+	s.tick(now.Add(time.Minute))
+
+	if second := waitGroup(t, s, "queue"); second.Pending || second.LastGroup == first.LastGroup {
+		t.Errorf("Expected the pending run to be submitted: %+v", second)
+	}
+
+	if state, _ := benchdrilltasks.GetScheduleState(store, "skip"); state.LastGroup != skipped.LastGroup {
+		t.Errorf("Expected no other run of the skip schedule: %+v", state)
+	}
+}
+
+// racingStore runs race before the first update of a key, as another
+// scheduler would
+type racingStore struct {
+	benchdrilltasks.Store
+	race func()
+}
+
+func (s *racingStore) Update(key string, update func(value []byte) ([]byte, error), expireIn int) error {
+	if s.race != nil {
+		s.race()
+		s.race = nil
+	}
+
+	return s.Store.Update(key, update, expireIn)
+}
+
+func TestScheduleConcurrentSchedulers(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	if _, code := h.run("", "schedule", "add", "--cron", "* * * * *", "--id", "skip", "--overlap", "skip", "sysbench sleep=1"); code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	server, store, err := startClient()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	now := time.Now().Add(time.Minute)
+	(&scheduler{server: server, store: store}).tick(now)
+
+	// Another scheduler records skipped runs while this one skips the run
+	// due next, both are counted
+	racing := &racingStore{Store: store, race: func() {
+		benchdrilltasks.UpdateScheduleState(store, "skip", func(state *benchdrilltasks.ScheduleState) {
+			state.Skipped += 5
+		})
+	}}
+	s := &scheduler{server: server, store: racing}
+	s.tick(now.Add(time.Minute))
+
+	state := waitGroup(t, s, "skip")
+
+	if state.Skipped != 6 || state.LastDue.Before(now.Add(time.Minute).Truncate(time.Minute)) {
+		t.Errorf("Expected the runs skipped by both schedulers and the latest run due: %+v", state)
+	}
+}
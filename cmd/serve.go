@@ -0,0 +1,577 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+const (
+	// Largest body accepted when submitting a job, input files included
+	maxJobSize = 32 << 20
+	// Most instances of a job
+	maxJobTimes = 10000
+)
+
+// jobRequest is the body of POST /jobs
+type jobRequest struct {
+	Command string `json:"command"`
+	// Workload file, a template rendered for each instance as with send_cmd_file
+	File   string            `json:"file,omitempty"`
+	Vars   map[string]string `json:"vars,omitempty"`
+	NoLint bool              `json:"no_lint,omitempty"`
+	// Runs "<command> prepare", "<command> run" then "<command> cleanup"
+	Pipeline bool `json:"pipeline,omitempty"`
+	// Input files by name, unpacked in the working directory of each instance
+	Files      map[string]string `json:"files,omitempty"`
+	Times      int               `json:"times,omitempty"`
+	Retries    int               `json:"retries,omitempty"`
+	RetryDelay *int              `json:"retry_delay,omitempty"`
+	Aggregate  bool              `json:"aggregate,omitempty"`
+	Artifacts  []string          `json:"artifacts,omitempty"`
+	Labels     map[string]string `json:"labels,omitempty"`
+	// Resources each instance may use, on workers run with --cgroups
+	Limits *benchdrilltasks.Limits `json:"limits,omitempty"`
+}
+
+// jobInstance is the state of an instance in the answers of the API
+type jobInstance struct {
+	Index    int    `json:"index"`
+	TaskUUID string `json:"task_uuid"`
+	State    string `json:"state"`
+	Step     string `json:"step,omitempty"`
+	// Task of the step in progress, for pipelines
+	StepTaskUUID string     `json:"step_task_uuid,omitempty"`
+	Worker       string     `json:"worker,omitempty"`
+	Attempts     int        `json:"attempts"`
+	StartedAt    *time.Time `json:"started_at,omitempty"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Error        string     `json:"error,omitempty"`
+	// Only given with the results
+	Output  string                  `json:"output,omitempty"`
+	Metrics benchdrilltasks.Metrics `json:"metrics,omitempty"`
+}
+
+// jobStatus is the answer of GET /jobs/{group}
+type jobStatus struct {
+	*benchdrilltasks.GroupInfo
+	Cancelled bool           `json:"cancelled"`
+	Completed bool           `json:"completed"`
+	Counts    map[string]int `json:"counts"`
+	Instances []*jobInstance `json:"instances"`
+	// Only given with the results
+	Summary *benchdrilltasks.Summary `json:"summary,omitempty"`
+}
+
+// apiServer answers the HTTP API and the dashboard, with the machinery
+// server of startServer
+type apiServer struct {
+	server *machinery.Server
+	store  benchdrilltasks.Store
+	// Depths of the queues of the broker, nil if it is not Redis
+	queues benchdrilltasks.Queues
+	// Bearer token of the requests submitting or cancelling jobs
+	token string
+	// Period of the updates pushed to the dashboard
+	refresh time.Duration
+	// Finished jobs shown by the dashboard, by group
+	finished map[string]*dashboardJob
+	mu       sync.Mutex
+	// Served on /metrics
+	metrics  *benchdrilltasks.Registry
+	requests *benchdrilltasks.Counter
+}
+
+// serve runs the HTTP API and the dashboard until it fails. Without token,
+// one is generated
+func serve(listen, token string, refresh time.Duration) error {
+	if len(inputFiles) > 0 || len(inputDirs) > 0 {
+		return errors.New("--file and --dir cannot be used with serve: input files are given with each job")
+	}
+
+	server, store, err := startStoreClient("serve")
+
+	if err != nil {
+		return err
+	}
+
+	if refresh <= 0 {
+		return fmt.Errorf("Invalid refresh period %s", refresh)
+	}
+
+	queues, err := openQueues(server)
+
+	if err != nil {
+		return err
+	}
+
+	if token == "" {
+		random := make([]byte, 16)
+
+		if _, err := rand.Read(random); err != nil {
+			return fmt.Errorf("Could not generate a token: %s", err.Error())
+		}
+
+		token = hex.EncodeToString(random)
+		benchdrilltasks.Log.Warningf("No --token given, jobs are submitted and cancelled with the token %s", token)
+	}
+
+	benchdrilltasks.Log.Infof("Serving the API and the dashboard on %s", listen)
+
+	return http.ListenAndServe(listen, newAPIHandler(server, store, queues, token, refresh))
+}
+
+// newAPIHandler routes the requests of the API and of the dashboard
+func newAPIHandler(server *machinery.Server, store benchdrilltasks.Store, queues benchdrilltasks.Queues, token string, refresh time.Duration) http.Handler {
+	api := &apiServer{
+		server:   server,
+		store:    store,
+		queues:   queues,
+		token:    token,
+		refresh:  refresh,
+		finished: make(map[string]*dashboardJob),
+	}
+	api.newAPIMetrics()
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("/", api.instrument("dashboard", api.dashboard))
+	mux.HandleFunc("/events", api.instrument("events", api.events))
+	mux.HandleFunc("/jobs", api.instrument("jobs", api.authorize(api.jobs)))
+	mux.HandleFunc("/jobs/", api.instrument("job", api.authorize(api.job)))
+	mux.HandleFunc("/workers", api.instrument("workers", api.workers))
+	mux.HandleFunc("/openapi.yaml", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/yaml")
+		w.Write([]byte(openAPI))
+	})
+	mux.Handle("/metrics", api.metrics)
+
+	return mux
+}
+
+// authorize refuses the requests which change the jobs, i.e. other than GET,
+// without the bearer token of the API
+func (api *apiServer) authorize(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodGet || r.Method == http.MethodHead {
+			handler(w, r)
+			return
+		}
+
+		authorization := r.Header.Get("Authorization")
+
+		if !strings.HasPrefix(authorization, "Bearer ") || subtle.ConstantTimeCompare([]byte(authorization[len("Bearer "):]), []byte(api.token)) != 1 {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="benchdrill"`)
+			writeError(w, http.StatusUnauthorized, errors.New("A valid bearer token is required to submit or cancel jobs"))
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// jobs submits (POST) or lists (GET) jobs
+func (api *apiServer) jobs(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		api.listJobs(w, r)
+	case http.MethodPost:
+		api.submitJob(w, r)
+	default:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	}
+}
+
+// job answers the requests about one job: /jobs/{group},
+// /jobs/{group}/results and /jobs/{group}/artifacts/{task}
+func (api *apiServer) job(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, "/jobs/"), "/")
+	groupUUID := parts[0]
+
+	info, err := benchdrilltasks.GetGroupInfo(api.store, groupUUID)
+
+	if err == benchdrilltasks.ErrNotFound {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No job %s", groupUUID))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		api.getJob(w, info, false)
+	case len(parts) == 1 && r.Method == http.MethodDelete:
+		api.cancelJob(w, info)
+	case len(parts) == 2 && parts[1] == "results" && r.Method == http.MethodGet:
+		api.getJob(w, info, true)
+	case len(parts) == 3 && parts[1] == "artifacts" && r.Method == http.MethodGet:
+		api.getArtifacts(w, info, parts[2])
+	case len(parts) <= 3:
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+	default:
+		writeError(w, http.StatusNotFound, fmt.Errorf("No such resource %s", r.URL.Path))
+	}
+}
+
+// submitJob sends the group of a job, without waiting for its results
+func (api *apiServer) submitJob(w http.ResponseWriter, r *http.Request) {
+	req := new(jobRequest)
+
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxJobSize)).Decode(req); err != nil {
+		writeError(w, http.StatusBadRequest, fmt.Errorf("Invalid job: %s", err.Error()))
+		return
+	}
+
+	groupedTasks, chord, info, err := newJobGroup(req)
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if len(req.Files) > 0 {
+		if err := api.attachFiles(groupedTasks, info, req.Files); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	if err := sendGroup(api.server, api.store, groupedTasks, chord, info); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	benchdrilltasks.Log.Group(info.GroupUUID).Infof("Job %s submitted: %d instances of %s", info.GroupUUID, len(info.Instances), info.Command)
+
+	w.Header().Set("Location", "/jobs/"+info.GroupUUID)
+	writeJSON(w, http.StatusCreated, info)
+}
+
+// newJobGroup makes the group of a job as the send commands do
+func newJobGroup(req *jobRequest) (*tasks.Group, *tasks.Chord, *benchdrilltasks.GroupInfo, error) {
+	if strings.TrimSpace(req.Command) == "" {
+		return nil, nil, nil, errors.New("A command is required")
+	}
+
+	opts := &groupOptions{
+		Times:      req.Times,
+		Retries:    req.Retries,
+		RetryDelay: 1,
+		Aggregate:  req.Aggregate,
+		Artifacts:  req.Artifacts,
+		Labels:     req.Labels,
+		Limits:     req.Limits,
+	}
+
+	if opts.Times == 0 {
+		opts.Times = 1
+	}
+
+	if req.RetryDelay != nil {
+		opts.RetryDelay = *req.RetryDelay
+	}
+
+	if opts.Times < 0 || opts.Retries < 0 || opts.RetryDelay < 0 {
+		return nil, nil, nil, errors.New("times, retries and retry_delay cannot be negative")
+	}
+
+	if opts.Times > maxJobTimes {
+		return nil, nil, nil, fmt.Errorf("times cannot exceed %d", maxJobTimes)
+	}
+
+	if req.Limits != nil {
+		if err := req.Limits.Validate(); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	args := []tasks.Arg{
+		{
+			Type:  "string",
+			Value: req.Command,
+		},
+	}
+
+	switch {
+	case req.Pipeline && req.File != "":
+		return nil, nil, nil, errors.New("A pipeline cannot be given a workload file")
+	case req.Pipeline:
+		steps, err := pipelineSteps(req.Command, "", "", "")
+
+		if err != nil {
+			return nil, nil, nil, err
+		}
+
+		groupedTasks, info, err := newPipelineGroup(steps, opts)
+
+		return groupedTasks, nil, info, err
+	case req.File != "":
+		groupedTasks, chord, info := newTaskGroup("task_file", args, opts)
+
+		return groupedTasks, chord, info, renderWorkloads(groupedTasks, info, req.File, req.Vars, !req.NoLint)
+	default:
+		groupedTasks, chord, info := newTaskGroup("task_args", args, opts)
+
+		return groupedTasks, chord, info, nil
+	}
+}
+
+// attachFiles uploads the input files of a job and has its tasks unpack them
+func (api *apiServer) attachFiles(groupedTasks *tasks.Group, info *benchdrilltasks.GroupInfo, files map[string]string) error {
+	contents := make(map[string][]byte)
+
+	for name, content := range files {
+		contents[name] = []byte(content)
+	}
+
+	archive, hash, err := benchdrilltasks.PackBundleContents(contents)
+
+	if err != nil {
+		return err
+	}
+
+	if err := saveBundle(api.server, api.store, hash, archive, len(files), groupedTasks.GroupUUID); err != nil {
+		return err
+	}
+
+	setBundle(groupedTasks, info, hash)
+
+	return nil
+}
+
+// listJobs lists the jobs which have not expired, newest first, filtered by
+// the labels given as label=name=value
+func (api *apiServer) listJobs(w http.ResponseWriter, r *http.Request) {
+	selector, err := benchdrilltasks.ParseVars(r.URL.Query()["label"])
+
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	groups, err := benchdrilltasks.ListGroups(api.store)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	jobs := []*benchdrilltasks.GroupInfo{}
+
+	for _, info := range groups {
+		matches := true
+
+		for name, value := range selector {
+			matches = matches && info.Labels[name] == value
+		}
+
+		if matches {
+			jobs = append(jobs, info)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+// getJob answers the state of each instance of a job and, with the results,
+// their outputs and the aggregate metrics of the job
+func (api *apiServer) getJob(w http.ResponseWriter, info *benchdrilltasks.GroupInfo, results bool) {
+	status, err := api.jobStatus(info, results)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, status)
+}
+
+func (api *apiServer) jobStatus(info *benchdrilltasks.GroupInfo, results bool) (*jobStatus, error) {
+	states, err := instanceStates(api.server, api.store, info.GroupUUID)
+
+	if err != nil {
+		return nil, err
+	}
+
+	status := &jobStatus{
+		GroupInfo: info,
+		Cancelled: benchdrilltasks.IsCancelled(api.store, info.GroupUUID),
+		Completed: true,
+		Counts:    make(map[string]int),
+	}
+
+	var taskStates []*tasks.TaskState
+
+	for i, state := range states {
+		instance := &jobInstance{
+			Index:    i,
+			TaskUUID: state.TaskUUID,
+			State:    state.State,
+			Step:     state.Step,
+			Error:    state.Error,
+		}
+
+		// For pipelines, the step in progress tells where the instance runs
+		taskUUID := state.TaskUUID
+
+		for _, step := range state.Steps {
+			if step.Name == state.Step {
+				taskUUID = step.TaskUUID
+			}
+		}
+
+		if taskUUID != state.TaskUUID {
+			instance.StepTaskUUID = taskUUID
+		}
+
+		if meta, err := benchdrilltasks.GetTaskMeta(api.store, taskUUID); err == nil {
+			instance.Worker = meta.Worker
+			instance.Attempts = meta.Attempts
+			instance.StartedAt = &meta.StartedAt
+
+			if !meta.FinishedAt.IsZero() {
+				instance.FinishedAt = &meta.FinishedAt
+			}
+		}
+
+		if results && state.IsSuccess() {
+			var outputs []string
+
+			for _, result := range state.Results {
+				outputs = append(outputs, fmt.Sprint(result.Value))
+			}
+
+			instance.Output = strings.Join(outputs, "\n")
+			instance.Metrics = benchdrilltasks.ParseMetrics(instance.Output)
+		}
+
+		status.Counts[state.State]++
+		status.Completed = status.Completed && state.IsCompleted()
+		status.Instances = append(status.Instances, instance)
+		taskStates = append(taskStates, state.TaskState)
+	}
+
+	if !results {
+		return status, nil
+	}
+
+	if info.SummaryUUID != "" {
+		summary, _, err := getSummary(api.server, info.SummaryUUID)
+
+		if err != nil {
+			return nil, err
+		}
+
+		status.Summary = summary
+	} else {
+		status.Summary = benchdrilltasks.Summarize(info.GroupUUID, taskStates)
+	}
+
+	return status, nil
+}
+
+// cancelJob cancels the instances of a job which have not started yet
+func (api *apiServer) cancelJob(w http.ResponseWriter, info *benchdrilltasks.GroupInfo) {
+	if err := benchdrilltasks.CancelGroup(api.store, info.GroupUUID, benchdrilltasks.ExpireIn(api.server.GetConfig())); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	benchdrilltasks.Log.Group(info.GroupUUID).Infof("Job %s cancelled", info.GroupUUID)
+
+	api.getJob(w, info, false)
+}
+
+// getArtifacts answers the archive of the artifacts of a task of a job
+func (api *apiServer) getArtifacts(w http.ResponseWriter, info *benchdrilltasks.GroupInfo, taskUUID string) {
+	found := false
+
+	for _, instance := range info.Instances {
+		found = found || instance.TaskUUID == taskUUID
+	}
+
+	if !found {
+		writeError(w, http.StatusNotFound, fmt.Errorf("No task %s in job %s", taskUUID, info.GroupUUID))
+		return
+	}
+
+	blobs := benchdrilltasks.NewBlobStore(api.store, artifactsDir)
+	_, archive, err := benchdrilltasks.GetArtifacts(api.store, blobs, taskUUID)
+
+	if err == benchdrilltasks.ErrNotFound {
+		state, stateErr := api.server.GetBackend().GetState(taskUUID)
+
+		if stateErr == nil && !state.IsCompleted() {
+			writeError(w, http.StatusConflict, fmt.Errorf("Task %s has not completed yet (%s)", taskUUID, state.State))
+			return
+		}
+
+		writeError(w, http.StatusNotFound, fmt.Errorf("No artifacts for task %s", taskUUID))
+		return
+	}
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", taskUUID+".tar.gz"))
+	w.Write(archive)
+}
+
+// workers lists the workers whose heartbeat has not expired
+func (api *apiServer) workers(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, fmt.Errorf("Method %s not allowed", r.Method))
+		return
+	}
+
+	workers, err := benchdrilltasks.ListWorkers(api.store)
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if workers == nil {
+		workers = []*benchdrilltasks.WorkerInfo{}
+	}
+
+	writeJSON(w, http.StatusOK, workers)
+}
+
+func writeJSON(w http.ResponseWriter, status int, value interface{}) {
+	body, err := json.MarshalIndent(value, "", "  ")
+
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{"error": err.Error()})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(body, '\n'))
+}
@@ -0,0 +1,328 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+// testToken is the bearer token of the API served by newAPI
+const testToken = "test-token"
+
+// newAPI serves the API, pointed to the stand-in by the harness
+func newAPI(t *testing.T) (*httptest.Server, *apiServer) {
+	server, store, err := startClient()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queues, err := openQueues(server)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(newAPIHandler(server, store, queues, testToken, 100*time.Millisecond)), &apiServer{server: server, store: store, queues: queues}
+}
+
+// request sends a request to the API with the bearer token, if any
+func request(t *testing.T, method, url, token string, body io.Reader) *http.Response {
+	req, err := http.NewRequest(method, url, body)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return resp
+}
+
+func getJSON(t *testing.T, url string, value interface{}) int {
+	resp, err := http.Get(url)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	if err := json.NewDecoder(resp.Body).Decode(value); err != nil {
+		t.Fatal(err)
+	}
+
+	return resp.StatusCode
+}
+
+func TestServe(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	ts, _ := newAPI(t)
+	defer ts.Close()
+
+	body := `{"command": "cat input.txt", "times": 2, "files": {"input.txt": "events per second:  42\n"}, "labels": {"team": "perf"}}`
+	resp := request(t, http.MethodPost, ts.URL+"/jobs", testToken, strings.NewReader(body))
+	job := new(benchdrilltasks.GroupInfo)
+	json.NewDecoder(resp.Body).Decode(job)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated || len(job.Instances) != 2 || job.Bundle == "" {
+		t.Fatalf("Status %d, expected a job of 2 instances with input files: %+v", resp.StatusCode, job)
+	}
+
+	status := new(jobStatus)
+
+	for start := time.Now(); !status.Completed; time.Sleep(50 * time.Millisecond) {
+		if time.Since(start) > 10*time.Second {
+			t.Fatalf("Job did not complete: %+v", status.Counts)
+		}
+
+		if code := getJSON(t, ts.URL+"/jobs/"+job.GroupUUID, status); code != http.StatusOK {
+			t.Fatalf("Status %d getting the job", code)
+		}
+	}
+
+	results := new(jobStatus)
+	getJSON(t, ts.URL+"/jobs/"+job.GroupUUID+"/results", results)
+
+	for _, instance := range results.Instances {
+		if instance.State != "SUCCESS" || instance.Metrics["events_per_second"] != 42 || instance.Worker == "" {
+			t.Errorf("Expected a successful instance with its metrics: %+v", instance)
+		}
+	}
+
+	if results.Summary == nil || results.Summary.Metrics["events_per_second"] == nil || results.Summary.Metrics["events_per_second"].Count != 2 {
+		t.Errorf("Expected the aggregate metrics of 2 instances: %+v", results.Summary)
+	}
+
+	var jobs []*benchdrilltasks.GroupInfo
+
+	if getJSON(t, ts.URL+"/jobs?label=team=perf", &jobs); len(jobs) != 1 || jobs[0].GroupUUID != job.GroupUUID {
+		t.Errorf("Expected the job labelled team=perf, got %d jobs", len(jobs))
+	}
+
+	if getJSON(t, ts.URL+"/jobs?label=team=other", &jobs); len(jobs) != 0 {
+		t.Errorf("Expected no job labelled team=other, got %d jobs", len(jobs))
+	}
+
+	var workers []*benchdrilltasks.WorkerInfo
+
+	if getJSON(t, ts.URL+"/workers", &workers); len(workers) != 2 {
+		t.Errorf("Expected 2 workers, got %d", len(workers))
+	}
+
+	if code := getJSON(t, ts.URL+"/jobs/group_missing", new(jobStatus)); code != http.StatusNotFound {
+		t.Errorf("Status %d for a missing job, expected 404", code)
+	}
+}
+
+func TestServeInvalidJob(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	ts, _ := newAPI(t)
+	defer ts.Close()
+
+	for _, body := range []string{
+		`{"times": 2}`,
+		`{"command": "sysbench", "times": -1}`,
+		`{"command": "sysbench", "times": 10001}`,
+		`{"command": "filebench -f", "file": "define fileset name=x\nrun 5\n"}`,
+		`{"command": "cat", "files": {"../escape": ""}}`,
+	} {
+		resp := request(t, http.MethodPost, ts.URL+"/jobs", testToken, bytes.NewBufferString(body))
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Status %d for %s, expected 400", resp.StatusCode, body)
+		}
+	}
+}
+
+func TestServeToken(t *testing.T) {
+	h := newHarness(t, 0)
+	defer h.Close()
+
+	ts, api := newAPI(t)
+	defer ts.Close()
+
+	// Left pending, without workers
+	groupedTasks, chord, info, err := newJobGroup(&jobRequest{Command: "sysbench cpu run"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendGroup(api.server, api.store, groupedTasks, chord, info); err != nil {
+		t.Fatal(err)
+	}
+
+	// Jobs are submitted and cancelled with the token only
+	for _, token := range []string{"", "wrong-token", testToken + "x"} {
+		for _, req := range []struct{ method, path string }{{http.MethodPost, "/jobs"}, {http.MethodDelete, "/jobs/" + info.GroupUUID}} {
+			resp := request(t, req.method, ts.URL+req.path, token, strings.NewReader(`{"command": "sysbench cpu run"}`))
+			resp.Body.Close()
+
+			if resp.StatusCode != http.StatusUnauthorized || resp.Header.Get("WWW-Authenticate") == "" {
+				t.Errorf("Status %d for %s %s with token %q, expected 401", resp.StatusCode, req.method, req.path, token)
+			}
+		}
+	}
+
+	if cancelled := benchdrilltasks.IsCancelled(api.store, info.GroupUUID); cancelled {
+		t.Error("Expected the job not to be cancelled without the token")
+	}
+
+	// Reading does not need it
+	if code := getJSON(t, ts.URL+"/jobs/"+info.GroupUUID, new(jobStatus)); code != http.StatusOK {
+		t.Errorf("Status %d getting the job without token, expected 200", code)
+	}
+
+	resp := request(t, http.MethodDelete, ts.URL+"/jobs/"+info.GroupUUID, testToken, nil)
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !benchdrilltasks.IsCancelled(api.store, info.GroupUUID) {
+		t.Errorf("Status %d cancelling with the token, expected the job cancelled", resp.StatusCode)
+	}
+}
+
+func TestCancel(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	ts, api := newAPI(t)
+	defer ts.Close()
+
+	// Cancelled before the workers pick it up
+	groupedTasks, chord, info, err := newJobGroup(&jobRequest{Command: "sysbench cpu run", Times: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := benchdrilltasks.CancelGroup(api.store, info.GroupUUID, 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendGroup(api.server, api.store, groupedTasks, chord, info); err != nil {
+		t.Fatal(err)
+	}
+
+	states, completed, err := waitStates(api.server, api.store, info.GroupUUID, 10*time.Second)
+
+	if err != nil || !completed {
+		t.Fatalf("Group did not complete: %v", err)
+	}
+
+	for _, state := range states {
+		if !state.IsFailure() || state.Error != benchdrilltasks.ErrCancelled.Error() {
+			t.Errorf("Instance %s is %s (%s), expected cancelled", state.TaskUUID, state.State, state.Error)
+		}
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	ts, api := newAPI(t)
+	defer ts.Close()
+
+	groupedTasks, chord, info, err := newJobGroup(&jobRequest{Command: "sysbench cpu run", Times: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendGroup(api.server, api.store, groupedTasks, chord, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, completed, _ := waitStates(api.server, api.store, info.GroupUUID, 10*time.Second); !completed {
+		t.Fatal("Group did not complete")
+	}
+
+	resp, err := http.Get(ts.URL + "/events")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer resp.Body.Close()
+
+	// The first event is pushed right away
+	line, err := bufio.NewReader(resp.Body).ReadString('\n')
+
+	if err != nil || !strings.HasPrefix(line, "data: ") {
+		t.Fatalf("Expected an event, got %q, %v", line, err)
+	}
+
+	state := new(dashboardState)
+
+	if err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), state); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(state.Workers) != 1 || len(state.Jobs) != 1 {
+		t.Fatalf("Expected 1 worker and 1 job: %+v", state)
+	}
+
+	if job := state.Jobs[0]; !job.Completed || job.Metric != "cpu_speed.events_per_second" || len(job.Values) != 2 || job.Mean != 1234.56 {
+		t.Errorf("Expected the events per second of 2 instances: %+v", job)
+	}
+}
+
+func TestDashboardForgetsExpiredJobs(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+
+	ts, client := newAPI(t)
+	defer ts.Close()
+
+	api := &apiServer{server: client.server, store: client.store, finished: make(map[string]*dashboardJob)}
+
+	groupedTasks, chord, info, err := newJobGroup(&jobRequest{Command: "sysbench cpu run"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := sendGroup(api.server, api.store, groupedTasks, chord, info); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, completed, _ := waitStates(api.server, api.store, info.GroupUUID, 10*time.Second); !completed {
+		t.Fatal("Group did not complete")
+	}
+
+	if _, err := api.dashboardState(); err != nil || len(api.finished) != 1 {
+		t.Fatalf("Expected the finished job to be kept (%v): %v", err, api.finished)
+	}
+
+	// As if the job expired
+	if err := api.store.Del("benchdrill_group_" + info.GroupUUID); err != nil {
+		t.Fatal(err)
+	}
+
+	if state, err := api.dashboardState(); err != nil || len(state.Jobs) != 0 || len(api.finished) != 0 {
+		t.Errorf("Expected the expired job to be forgotten (%v): %v", err, api.finished)
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// instanceState is the state of one instance of a group. For a pipeline, it
+// sums up the states of its steps
+type instanceState struct {
+	*tasks.TaskState
+	// Step in progress, for pipelines
+	Step  string
+	Steps []*stepState
+}
+
+// stepState is the state of a step of a pipeline, nil until it is sent
+type stepState struct {
+	Name     string
+	TaskUUID string
+	State    *tasks.TaskState
+}
+
+// instanceStates returns the state of each instance of a group
+func instanceStates(server *machinery.Server, store benchdrilltasks.Store, groupUUID string) ([]*instanceState, error) {
+	info, err := benchdrilltasks.GetGroupInfo(store, groupUUID)
+
+	if err != nil && err != benchdrilltasks.ErrNotFound {
+		return nil, fmt.Errorf("Could not get group %s: %s", groupUUID, err.Error())
+	}
+
+	if err == nil && info.IsPipeline() {
+		return pipelineStates(server, info), nil
+	}
+
+	states, err := server.GetBackend().GroupTaskStates(groupUUID, 0)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not get states of group %s: %s", groupUUID, err.Error())
+	}
+
+	instances := make([]*instanceState, len(states))
+
+	for i, state := range states {
+		instances[i] = &instanceState{TaskState: state}
+	}
+
+	return instances, nil
+}
+
+func pipelineStates(server *machinery.Server, info *benchdrilltasks.GroupInfo) []*instanceState {
+	instances := make([]*instanceState, len(info.Instances))
+
+	for i, instance := range info.Instances {
+		var steps []*stepState
+
+		for _, step := range instance.Steps {
+			// A step has no state until the previous one has completed
+			state, err := server.GetBackend().GetState(step.TaskUUID)
+
+			if err != nil {
+				state = nil
+			}
+
+			steps = append(steps, &stepState{
+				Name:     step.Name,
+				TaskUUID: step.TaskUUID,
+				State:    state,
+			})
+		}
+
+		instances[i] = sumUpPipeline(instance.TaskUUID, steps)
+	}
+
+	return instances
+}
+
+// sumUpPipeline returns the state of a pipeline, which completes with its
+// last step (the cleanup, which always runs): it failed if any previous step
+// failed, otherwise it gets the results of the step it is reported by
+func sumUpPipeline(taskUUID string, steps []*stepState) *instanceState {
+	instance := &instanceState{
+		TaskState: &tasks.TaskState{
+			TaskUUID: taskUUID,
+			State:    tasks.PendingState,
+		},
+		Steps: steps,
+	}
+
+	last := steps[len(steps)-1]
+
+	if last.State == nil || !last.State.IsCompleted() {
+		for _, step := range steps {
+			if step.State != nil && !step.State.IsCompleted() {
+				instance.State = step.State.State
+				instance.Step = step.Name
+				break
+			}
+		}
+
+		return instance
+	}
+
+	for _, step := range steps[:len(steps)-1] {
+		if step.State != nil && step.State.IsFailure() {
+			instance.State = tasks.FailureState
+			instance.Error = fmt.Sprintf("%s step failed: %s", step.Name, step.State.Error)
+			return instance
+		}
+	}
+
+	for _, step := range steps {
+		if step.TaskUUID == taskUUID && step.State != nil && step.State.IsSuccess() {
+			instance.State = tasks.SuccessState
+			instance.Results = step.State.Results
+			return instance
+		}
+	}
+
+	instance.State = tasks.FailureState
+	instance.Error = "Pipeline completed without running its main step"
+
+	return instance
+}
@@ -0,0 +1,263 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// Sorted set where the Redis broker keeps tasks with an ETA, e.g. retries
+const delayedTasksQueue = "delayed_tasks"
+
+// Order in which task states are summed up
+var taskStates = []string{
+	tasks.PendingState,
+	tasks.ReceivedState,
+	tasks.StartedState,
+	tasks.RetryState,
+	tasks.SuccessState,
+	tasks.FailureState,
+}
+
+func status(groupUUID, queue string, watch bool, interval time.Duration) error {
+	server, err := startServer()
+
+	if err != nil {
+		return err
+	}
+
+	store, err := benchdrilltasks.NewStore(server.GetConfig())
+
+	if err == nil {
+		err = benchdrilltasks.RequireStore(store, "status")
+	}
+
+	if err != nil {
+		return err
+	}
+
+	queues, err := openQueues(server)
+
+	if err != nil {
+		return err
+	}
+
+	for {
+		var buf bytes.Buffer
+
+		completed, err := printStatus(&buf, server, store, queues, groupUUID, queue)
+
+		if err != nil {
+			return err
+		}
+
+		if watch {
+			// Clear the terminal before redrawing
+			fmt.Print("\033[H\033[2J")
+		}
+
+		os.Stdout.Write(buf.Bytes())
+
+		if !watch || completed {
+			return nil
+		}
+
+		time.Sleep(interval)
+	}
+}
+
+// printStatus writes the state of every instance of a group (if any) and the
+// depth of the known queues, or of the given one, it returns whether the group
+// has completed
+func printStatus(out io.Writer, server *machinery.Server, store benchdrilltasks.Store, queues benchdrilltasks.Queues, groupUUID, queue string) (bool, error) {
+	completed := false
+
+	if groupUUID != "" {
+		states, err := instanceStates(server, store, groupUUID)
+
+		if err != nil {
+			return false, err
+		}
+
+		completed = printGroupStatus(out, store, groupUUID, states)
+		fmt.Fprintln(out)
+	}
+
+	return completed, printQueues(out, server, store, queues, queue)
+}
+
+func printGroupStatus(out io.Writer, store benchdrilltasks.Store, groupUUID string, states []*instanceState) bool {
+	counts := make(map[string]int)
+	completed := true
+
+	fmt.Fprintf(out, "Group %s\n\n", groupUUID)
+
+	w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "#\tTASK\tSTATE\tWORKER\tRUNNING\tATTEMPTS\tERROR")
+
+	for i, state := range states {
+		counts[state.State]++
+		completed = completed && state.IsCompleted()
+
+		worker, running, attempts := "-", "-", "0"
+		taskUUID, taskState := state.TaskUUID, state.State
+
+		// For pipelines, show the step in progress
+		for _, step := range state.Steps {
+			if step.Name == state.Step {
+				taskUUID = step.TaskUUID
+				taskState = fmt.Sprintf("%s (%s)", state.State, step.Name)
+			}
+		}
+
+		if meta, err := benchdrilltasks.GetTaskMeta(store, taskUUID); err == nil {
+			worker = meta.Worker
+			running = meta.Elapsed().Round(time.Second).String()
+			attempts = fmt.Sprint(meta.Attempts)
+		}
+
+		fmt.Fprintf(w, "%d\t%s\t%s\t%s\t%s\t%s\t%s\n", i, state.TaskUUID, taskState, worker, running, attempts, firstLine(state.Error))
+	}
+
+	w.Flush()
+
+	var summary []string
+
+	for _, state := range taskStates {
+		if counts[state] > 0 {
+			summary = append(summary, fmt.Sprintf("%d %s", counts[state], state))
+		}
+	}
+
+	fmt.Fprintf(out, "\n%d instances: %s\n", len(states), strings.Join(summary, ", "))
+
+	return completed
+}
+
+func printQueues(out io.Writer, server *machinery.Server, store benchdrilltasks.Store, queues benchdrilltasks.Queues, queue string) error {
+	workers, err := benchdrilltasks.ListWorkers(store)
+
+	if err != nil {
+		return fmt.Errorf("Could not list workers: %s", err.Error())
+	}
+
+	depths, err := queueDepths(server, queues, workers)
+
+	if err == nil && queue != "" {
+		depths, err = filterQueue(depths, queues, queue)
+	}
+
+	switch {
+	case err == benchdrilltasks.ErrQueuesUnavailable:
+		fmt.Fprintln(out, err.Error())
+	case err != nil:
+		return err
+	default:
+		w := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "QUEUE\tDEPTH")
+
+		for _, queue := range depths {
+			fmt.Fprintf(w, "%s\t%d\n", queue.Name, queue.Depth)
+		}
+
+		w.Flush()
+	}
+
+	fmt.Fprintf(out, "\n%d workers alive\n", len(workers))
+
+	return nil
+}
+
+// filterQueue keeps the depth of a queue, read even if no worker consumes it
+func filterQueue(depths []*queueDepth, queues benchdrilltasks.Queues, name string) ([]*queueDepth, error) {
+	for _, depth := range depths {
+		if depth.Name == name {
+			return []*queueDepth{depth}, nil
+		}
+	}
+
+	depth, err := queues.QueueLen(name)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not get depth of queue %s: %s", name, err.Error())
+	}
+
+	return []*queueDepth{{Name: name, Depth: depth}}, nil
+}
+
+// queueDepth is the number of tasks waiting in a queue
+type queueDepth struct {
+	Name  string `json:"name"`
+	Depth int    `json:"depth"`
+}
+
+// openQueues connects to the broker to read the depths of its queues, nil if
+// the broker is not Redis
+func openQueues(server *machinery.Server) (benchdrilltasks.Queues, error) {
+	queues, err := benchdrilltasks.NewQueues(server.GetConfig())
+
+	if err == benchdrilltasks.ErrQueuesUnavailable {
+		return nil, nil
+	}
+
+	return queues, err
+}
+
+// queueDepths returns the depth of the default queue, of the delayed tasks
+// and of the queues of the workers, sorted by name. Without queues, when the
+// broker is not Redis, it returns ErrQueuesUnavailable
+func queueDepths(server *machinery.Server, queues benchdrilltasks.Queues, workers []*benchdrilltasks.WorkerInfo) ([]*queueDepth, error) {
+	if queues == nil {
+		return nil, benchdrilltasks.ErrQueuesUnavailable
+	}
+
+	names := map[string]bool{
+		server.GetConfig().DefaultQueue: true,
+		delayedTasksQueue:               true,
+	}
+
+	for _, worker := range workers {
+		names[worker.Queue] = true
+		names[worker.PrivateQueue] = true
+	}
+
+	var sorted []string
+
+	for queue := range names {
+		sorted = append(sorted, queue)
+	}
+
+	sort.Strings(sorted)
+
+	var depths []*queueDepth
+
+	for _, queue := range sorted {
+		depth, err := queues.QueueLen(queue)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not get depth of queue %s: %s", queue, err.Error())
+		}
+
+		depths = append(depths, &queueDepth{Name: queue, Depth: depth})
+	}
+
+	return depths, nil
+}
+
+func firstLine(s string) string {
+	if i := strings.Index(s, "\n"); i >= 0 {
+		return s[:i] + "…"
+	}
+
+	return s
+}
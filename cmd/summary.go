@@ -0,0 +1,115 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+
+	"github.com/urfave/cli"
+)
+
+// newSummaryChord makes a chord of a group, whose callback summarizes the
+// results of the group on a worker
+func newSummaryChord(groupedTasks *tasks.Group) *tasks.Chord {
+	return tasks.NewChord(groupedTasks, &tasks.Signature{
+		Name: "task_summary",
+		Args: []tasks.Arg{
+			{
+				Type:  "string",
+				Value: groupedTasks.GroupUUID,
+			},
+		},
+		// The callback reads the results itself rather than getting them all as arguments
+		Immutable: true,
+	})
+}
+
+// getSummary reads the summary of an aggregated group, it returns the state
+// of the chord callback as long as the summary is not available
+func getSummary(server *machinery.Server, summaryUUID string) (*benchdrilltasks.Summary, string, error) {
+	state, err := server.GetBackend().GetState(summaryUUID)
+
+	if err != nil {
+		// The callback has no state until the group completes
+		return nil, tasks.PendingState, nil
+	}
+
+	if state.IsFailure() {
+		return nil, state.State, fmt.Errorf("Summarizing the group failed with error: %s", state.Error)
+	}
+
+	if !state.IsSuccess() || len(state.Results) == 0 {
+		return nil, state.State, nil
+	}
+
+	summary := new(benchdrilltasks.Summary)
+
+	if err := json.Unmarshal([]byte(fmt.Sprint(state.Results[0].Value)), summary); err != nil {
+		return nil, state.State, fmt.Errorf("Could not decode summary: %s", err.Error())
+	}
+
+	return summary, state.State, nil
+}
+
+// collectSummary polls the summary of an aggregated group until it is
+// available or the timeout expired
+func collectSummary(server *machinery.Server, summaryUUID string, timeout time.Duration) (*benchdrilltasks.Summary, error) {
+	var (
+		summary *benchdrilltasks.Summary
+		err     error
+	)
+
+	poll(timeout, func() bool {
+		summary, _, err = getSummary(server, summaryUUID)
+
+		return summary != nil || err != nil
+	})
+
+	if err != nil {
+		return nil, err
+	}
+
+	if summary == nil {
+		return nil, cli.NewExitError("Timed out waiting for the summary of the group", exitNoResults)
+	}
+
+	return summary, nil
+}
+
+// reportSummary prints the aggregate metrics and the failed instances of a
+// group. As for report, the returned error carries the exit code
+func reportSummary(summary *benchdrilltasks.Summary) error {
+	printSummary(summary)
+
+	for _, failure := range summary.Failures {
+		benchdrilltasks.Log.Task(failure.TaskUUID, summary.GroupUUID).Errorf("Instance %s failed with error: %s", failure.TaskUUID, failure.Error)
+	}
+
+	message := fmt.Sprintf("%d succeeded, %d failed", summary.Succeeded, len(summary.Failures))
+	benchdrilltasks.Log.Info(message)
+
+	if len(summary.Failures) > 0 {
+		return cli.NewExitError("Not all instances succeeded: "+message, exitFailed)
+	}
+
+	return nil
+}
+
+func printSummary(summary *benchdrilltasks.Summary) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "METRIC\tN\tMEAN\tMIN\tMAX\tSTDDEV")
+
+	for _, name := range summary.MetricNames() {
+		a := summary.Metrics[name]
+		fmt.Fprintf(w, "%s\t%d\t%.3f\t%.3f\t%.3f\t%.3f\n", name, a.Count, a.Mean, a.Min, a.Max, a.Stddev)
+	}
+
+	w.Flush()
+}
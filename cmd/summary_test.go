@@ -0,0 +1,92 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregate(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	out, code := h.run("", "--times", "3", "--aggregate", "send_cmd_args", "sysbench cpu run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0:\n%s", code, out)
+	}
+
+	// The summary is computed by task_summary, the chord callback of the group
+	if !strings.Contains(out, "Task task_summary started") {
+		t.Errorf("Expected a worker to run the chord callback:\n%s", out)
+	}
+
+	for _, row := range []string{`cpu_speed\.events_per_second\s+3\s+1234\.560\s+1234\.560\s+1234\.560\s+0\.000`, `latency_ms\.avg\s+3\s+1\.620`} {
+		if !regexp.MustCompile(row).MatchString(out) {
+			t.Errorf("Expected a row matching %s:\n%s", row, out)
+		}
+	}
+
+	if !strings.Contains(out, "3 succeeded, 0 failed") {
+		t.Errorf("Expected 3 instances summarized:\n%s", out)
+	}
+}
+
+func TestAggregateFailure(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	// One instance fails, the metrics of the others are still summarized
+	out, code := h.run("", "--times", "3", "--aggregate", "send_cmd_args", "sysbench flaky")
+
+	if code != exitFailed {
+		t.Fatalf("Exit code %d, expected %d:\n%s", code, exitFailed, out)
+	}
+
+	if !regexp.MustCompile(`cpu_speed\.events_per_second\s+2\s+1234\.560`).MatchString(out) {
+		t.Errorf("Expected the metrics of the 2 instances which succeeded:\n%s", out)
+	}
+
+	if !regexp.MustCompile(`Instance task_\S+ failed with error: exit status 1`).MatchString(out) || !strings.Contains(out, "2 succeeded, 1 failed") {
+		t.Errorf("Expected the failed instance to be reported:\n%s", out)
+	}
+
+	out, code = h.run("", "--times", "2", "--aggregate", "send_cmd_args", "sysbench fail")
+
+	if code != exitFailed {
+		t.Fatalf("Exit code %d, expected %d:\n%s", code, exitFailed, out)
+	}
+
+	if !strings.Contains(out, "0 succeeded, 2 failed") || strings.Contains(out, "cpu_speed") {
+		t.Errorf("Expected no metrics and 2 failures:\n%s", out)
+	}
+}
+
+func TestAggregateDetachFetch(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	out, code := h.run("", "--times", "2", "--aggregate", "--detach", "send_cmd_args", "sysbench cpu run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	group := regexp.MustCompile(`(?m)^group_\S+$`).FindString(out)
+
+	for deadline := time.Now().Add(10 * time.Second); ; time.Sleep(100 * time.Millisecond) {
+		if out, code = h.run("", "fetch", group); code == 0 {
+			break
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("Exit code %d, expected the summary of the group:\n%s", code, out)
+		}
+	}
+
+	// Only the summary is read, not the outputs of the instances
+	if !regexp.MustCompile(`cpu_speed\.events_per_second\s+2\s+1234\.560`).MatchString(out) || !strings.Contains(out, "2 succeeded, 0 failed") {
+		t.Errorf("Expected the summary of the 2 instances:\n%s", out)
+	}
+}
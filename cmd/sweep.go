@@ -0,0 +1,317 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/tasks"
+
+	"github.com/urfave/cli"
+)
+
+// Metrics shown by default in the table of a sweep, when reported
+var headlineMetrics = []string{
+	"cpu_speed.events_per_second",
+	"file_operations.reads_s",
+	"file_operations.writes_s",
+	"throughput.read_mib_s",
+	"throughput.written_mib_s",
+	"sql_statistics.transactions",
+	"filebench.ops_per_s",
+	"filebench.mb_per_s",
+	"latency_ms.avg",
+	"latency_ms.95th_percentile",
+	"filebench.ms_per_op",
+}
+
+// sweepRow is a combination of parameters of a sweep and the summary of the
+// group run for it
+type sweepRow struct {
+	combination benchdrilltasks.Combination
+	groupUUID   string
+	summary     *benchdrilltasks.Summary
+}
+
+// timedOut returns the number of instances of the group without result
+func (r *sweepRow) timedOut() int {
+	return r.summary.Instances - r.summary.Succeeded - len(r.summary.Failures)
+}
+
+// sweep runs a command template once per combination of the values of its
+// parameters, each combination being a group of `times` instances, one
+// group after another so that they do not compete for the workers
+func sweep(template string, specs []string, zip, shuffle bool, seed int64, metrics []string) error {
+	if detach {
+		return fmt.Errorf("Sweeps cannot be detached, their groups are sent one after another")
+	}
+
+	params, err := sweepParams(template, specs)
+
+	if err != nil {
+		return err
+	}
+
+	combinations, err := benchdrilltasks.Expand(params, zip)
+
+	if err != nil {
+		return err
+	}
+
+	server, store, err := startClient()
+
+	if err != nil {
+		return err
+	}
+
+	rows := make([]*sweepRow, len(combinations))
+	order := make([]int, len(combinations))
+
+	for i := range order {
+		order[i] = i
+	}
+
+	if shuffle {
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+		}
+
+		// The seed is logged so that the same order can be replayed
+		benchdrilltasks.Log.Infof("Shuffling the combinations with seed %d", seed)
+		order = rand.New(rand.NewSource(seed)).Perm(len(combinations))
+	}
+
+	for n, i := range order {
+		cmd, err := benchdrilltasks.Render(template, combinations[i])
+
+		if err != nil {
+			return err
+		}
+
+		groupedTasks, chord, info := newTaskGroup("task_args", []tasks.Arg{
+			{
+				Type:  "string",
+				Value: cmd,
+			},
+		}, flagOptions())
+
+		if err := sendGroup(server, store, groupedTasks, chord, info); err != nil {
+			return err
+		}
+
+		benchdrilltasks.Log.Group(info.GroupUUID).Infof("Combination %d/%d: %s (group %s)", n+1, len(combinations), combinations[i].Label(params), info.GroupUUID)
+
+		summary, err := waitSummary(server, store, info)
+
+		if err != nil {
+			return err
+		}
+
+		rows[i] = &sweepRow{
+			combination: combinations[i],
+			groupUUID:   info.GroupUUID,
+			summary:     summary,
+		}
+	}
+
+	return reportSweep(params, rows, metrics)
+}
+
+// sweepParams parses the parameters of a sweep, which must match the
+// placeholders of the command template
+func sweepParams(template string, specs []string) ([]*benchdrilltasks.Param, error) {
+	if template == "" {
+		return nil, fmt.Errorf("No command template given")
+	}
+
+	var params []*benchdrilltasks.Param
+
+	defined := make(map[string]bool)
+
+	for _, spec := range specs {
+		param, err := benchdrilltasks.ParseParam(spec)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if defined[param.Name] {
+			return nil, fmt.Errorf("Parameter %s is defined more than once", param.Name)
+		}
+
+		defined[param.Name] = true
+		params = append(params, param)
+	}
+
+	used := make(map[string]bool)
+
+	for _, name := range benchdrilltasks.Placeholders(template) {
+		if !defined[name] {
+			return nil, fmt.Errorf("No values given for placeholder {{%s}}, use --param %s=…", name, name)
+		}
+
+		used[name] = true
+	}
+
+	for _, param := range params {
+		if !used[param.Name] {
+			return nil, fmt.Errorf("Parameter %s is not used by the command template", param.Name)
+		}
+	}
+
+	return params, nil
+}
+
+// waitSummary waits for the instances of a group until they all completed
+// or the timeout expired and summarizes their results, on a worker if the
+// group is aggregated
+func waitSummary(server *machinery.Server, store benchdrilltasks.Store, info *benchdrilltasks.GroupInfo) (*benchdrilltasks.Summary, error) {
+	if info.SummaryUUID != "" {
+		var (
+			summary *benchdrilltasks.Summary
+			err     error
+		)
+
+		poll(timeout, func() bool {
+			summary, _, err = getSummary(server, info.SummaryUUID)
+
+			return summary != nil || err != nil
+		})
+
+		if summary == nil && err == nil {
+			// Nothing is known about the instances until the summary is available
+			summary = &benchdrilltasks.Summary{
+				GroupUUID: info.GroupUUID,
+				Instances: len(info.Instances),
+			}
+		}
+
+		return summary, err
+	}
+
+	states, _, err := waitStates(server, store, info.GroupUUID, timeout)
+
+	if err != nil {
+		return nil, fmt.Errorf("Getting task results failed with error: %s", err.Error())
+	}
+
+	var results []*tasks.TaskState
+
+	for _, state := range states {
+		results = append(results, state.TaskState)
+	}
+
+	return benchdrilltasks.Summarize(info.GroupUUID, results), nil
+}
+
+// reportSweep prints one row per combination with the aggregate metrics of
+// its group, in the order of the combinations. As for report, the returned
+// error carries the exit code
+func reportSweep(params []*benchdrilltasks.Param, rows []*sweepRow, metrics []string) error {
+	if len(metrics) == 0 {
+		metrics = sweepMetrics(rows)
+	}
+
+	var header []string
+
+	for _, param := range params {
+		header = append(header, strings.ToUpper(param.Name))
+	}
+
+	header = append(header, "OK")
+	header = append(header, metrics...)
+	header = append(header, "GROUP")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, strings.Join(header, "\t"))
+
+	var failed, timedOut int
+
+	for _, row := range rows {
+		var cells []string
+
+		for _, param := range params {
+			cells = append(cells, row.combination[param.Name])
+		}
+
+		cells = append(cells, fmt.Sprintf("%d/%d", row.summary.Succeeded, row.summary.Instances))
+
+		for _, name := range metrics {
+			if a, ok := row.summary.Metrics[name]; ok {
+				cells = append(cells, fmt.Sprintf("%.3f ±%.3f", a.Mean, a.Stddev))
+			} else {
+				cells = append(cells, "-")
+			}
+		}
+
+		cells = append(cells, row.groupUUID)
+		fmt.Fprintln(w, strings.Join(cells, "\t"))
+
+		for _, failure := range row.summary.Failures {
+			benchdrilltasks.Log.Task(failure.TaskUUID, row.groupUUID).Errorf("Instance %s (%s) failed with error: %s", failure.TaskUUID, row.combination.Label(params), failure.Error)
+		}
+
+		failed += len(row.summary.Failures)
+		timedOut += row.timedOut()
+	}
+
+	w.Flush()
+
+	message := fmt.Sprintf("%d combinations, %d instances failed, %d timed out or pending", len(rows), failed, timedOut)
+	benchdrilltasks.Log.Info(message)
+
+	code := 0
+
+	if failed > 0 {
+		code |= exitFailed
+	}
+
+	if timedOut > 0 {
+		code |= exitNoResults
+	}
+
+	if code != 0 {
+		return cli.NewExitError("Not all instances succeeded: "+message, code)
+	}
+
+	return nil
+}
+
+// sweepMetrics picks the headline metrics reported by the groups of a sweep,
+// or all of their metrics if there is none
+func sweepMetrics(rows []*sweepRow) []string {
+	reported := make(map[string]bool)
+
+	for _, row := range rows {
+		for name := range row.summary.Metrics {
+			reported[name] = true
+		}
+	}
+
+	var metrics []string
+
+	for _, name := range headlineMetrics {
+		if reported[name] {
+			metrics = append(metrics, name)
+		}
+	}
+
+	if len(metrics) > 0 {
+		return metrics
+	}
+
+	for name := range reported {
+		metrics = append(metrics, name)
+	}
+
+	sort.Strings(metrics)
+
+	return metrics
+}
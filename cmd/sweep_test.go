@@ -0,0 +1,81 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestSweepParams(t *testing.T) {
+	template := "sysbench --threads={{threads}} --time={{time}} cpu run"
+
+	for _, test := range []struct {
+		template string
+		specs    []string
+		err      string
+	}{
+		{template, []string{"threads=1,2", "time=10"}, ""},
+		{"", []string{"threads=1"}, "No command template given"},
+		{template, []string{"threads=1,2"}, "No values given for placeholder {{time}}"},
+		{template, []string{"threads=1,2", "time=10", "size=1G"}, "Parameter size is not used by the command template"},
+		{template, []string{"threads=1,2", "time=10", "threads=4"}, "Parameter threads is defined more than once"},
+		{template, []string{"threads=8..1", "time=10"}, "Invalid values of parameter threads: empty range"},
+	} {
+		params, err := sweepParams(test.template, test.specs)
+
+		if test.err == "" {
+			if err != nil || len(params) != len(test.specs) {
+				t.Errorf("%v: got %d parameters (%v)", test.specs, len(params), err)
+			}
+		} else if err == nil || !strings.HasPrefix(err.Error(), test.err) {
+			t.Errorf("%v: error %v, expected %q", test.specs, err, test.err)
+		}
+	}
+}
+
+func TestSweep(t *testing.T) {
+	h := newHarness(t, 2)
+	defer h.Close()
+
+	out, code := h.run("", "--times", "2", "sweep", "--param", "threads=1..4..2", "--param", "mode=cpu,memory", "sysbench --threads={{threads}} {{mode}} run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0:\n%s", code, out)
+	}
+
+	// One row per combination of the cartesian product, in order
+	rows := regexp.MustCompile(`(?m)^(\d)\s+(\w+)\s+2/2\s+1234\.560 ±0\.000`).FindAllStringSubmatch(out, -1)
+	var combinations []string
+
+	for _, row := range rows {
+		combinations = append(combinations, row[1]+" "+row[2])
+	}
+
+	if strings.Join(combinations, ", ") != "1 cpu, 1 memory, 3 cpu, 3 memory" {
+		t.Errorf("Expected 4 combinations, got %v:\n%s", combinations, out)
+	}
+
+	out, code = h.run("", "sweep", "--zip", "--param", "threads=1,2", "--param", "mode=cpu,memory", "sysbench --threads={{threads}} {{mode}} run")
+
+	if code != 0 {
+		t.Fatalf("Exit code %d, expected 0:\n%s", code, out)
+	}
+
+	if !strings.Contains(out, "2 combinations") || !regexp.MustCompile(`(?m)^1\s+cpu\s`).MatchString(out) || !regexp.MustCompile(`(?m)^2\s+memory\s`).MatchString(out) {
+		t.Errorf("Expected the values zipped:\n%s", out)
+	}
+
+	// Nothing is sent when the combinations cannot be made
+	for _, args := range [][]string{
+		{"sweep", "--zip", "--param", "threads=1,2,4", "--param", "mode=cpu,memory", "sysbench --threads={{threads}} {{mode}} run"},
+		{"sweep", "--param", "threads=1,2", "sysbench --threads={{threads}} {{mode}} run"},
+	} {
+		if out, code = h.run("", args...); code != 1 || strings.Contains(out, "Combination 1/") {
+			t.Errorf("%s: exit code %d, expected 1 before sending:\n%s", strings.Join(args, " "), code, out)
+		}
+	}
+
+	if out, code = h.run("", "sweep", "--param", "mode=cpu,fail", "sysbench {{mode}} run"); code != exitFailed {
+		t.Errorf("Exit code %d, expected %d:\n%s", code, exitFailed, out)
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+)
+
+func TestTraceTask(t *testing.T) {
+	h := newHarness(t, 1)
+	defer h.Close()
+	defer configureTracing("", "")
+
+	path := filepath.Join(h.dir, "spans.json")
+
+	if _, code := h.run("", "--trace-file", path, "send_pipeline", "sysbench fileio"); code != 0 {
+		t.Fatalf("Exit code %d, expected 0", code)
+	}
+
+	type span struct {
+		TraceID      string `json:"traceId"`
+		SpanID       string `json:"spanId"`
+		ParentSpanID string `json:"parentSpanId"`
+		Name         string `json:"name"`
+	}
+
+	var spans []span
+
+	// The worker may end the span of the last step after the client picked
+	// up its result
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(50 * time.Millisecond) {
+		benchdrilltasks.Tracing.Flush()
+		content, _ := ioutil.ReadFile(path)
+		spans = nil
+
+		for _, line := range strings.Split(strings.TrimSpace(string(content)), "\n") {
+			var request struct {
+				ResourceSpans []struct {
+					ScopeSpans []struct {
+						Spans []span `json:"spans"`
+					} `json:"scopeSpans"`
+				} `json:"resourceSpans"`
+			}
+
+			json.Unmarshal([]byte(line), &request)
+
+			for _, resourceSpans := range request.ResourceSpans {
+				for _, scopeSpans := range resourceSpans.ScopeSpans {
+					spans = append(spans, scopeSpans.Spans...)
+				}
+			}
+		}
+
+		// submit, publish, wait results and client pickup, and for each of
+		// the 3 steps (prepare, run, cleanup) queue wait, process, receive,
+		// workspace setup, exec and result store
+		if len(spans) >= 22 || time.Now().After(deadline) {
+			break
+		}
+	}
+
+	names := make(map[string]int)
+	byID := make(map[string]span)
+
+	for _, s := range spans {
+		names[s.Name]++
+		byID[s.SpanID] = s
+
+		if s.TraceID != spans[0].TraceID {
+			t.Errorf("Span %s is in trace %s, expected %s", s.Name, s.TraceID, spans[0].TraceID)
+		}
+	}
+
+	for name, count := range map[string]int{"submit pipeline": 1, "publish": 1, "wait results": 1, "client pickup": 1, "queue wait": 3, "process task_args": 3, "receive": 3, "workspace setup": 3, "exec": 3, "result store": 3} {
+		if names[name] != count {
+			t.Errorf("Got %d spans %q, expected %d: %v", names[name], name, count, names)
+		}
+	}
+
+	// Each following step of the pipeline is processed below the previous one
+	chained := 0
+
+	for _, s := range spans {
+		if s.Name == "process task_args" && byID[s.ParentSpanID].Name == "process task_args" {
+			chained++
+		}
+	}
+
+	if chained != 2 {
+		t.Errorf("Got %d steps below the previous step, expected 2", chained)
+	}
+}
@@ -0,0 +1,225 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/Wolphin-project/benchdrill/pkg"
+
+	"github.com/urfave/cli"
+)
+
+func listWorkloads() error {
+	library, err := benchdrilltasks.LoadLibrary(libraryDir)
+
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTOOL\tSOURCE\tDESCRIPTION")
+
+	for _, workload := range library {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", workload.Name, workload.Tool(), workload.Source, workload.Description)
+	}
+
+	return w.Flush()
+}
+
+// showWorkload prints the description, the command, the variables and the
+// workload file of a workload of the library
+func showWorkload(name string) error {
+	library, err := benchdrilltasks.LoadLibrary(libraryDir)
+
+	if err != nil {
+		return err
+	}
+
+	workload, err := benchdrilltasks.FindWorkload(library, name)
+
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s (%s)\n\n%s\n\nCommand: %s\n", workload.Name, workload.Source, workload.Description, workload.Command)
+
+	if workload.Pipeline {
+		fmt.Println("Run as a prepare/run/cleanup pipeline")
+	}
+
+	if len(workload.Vars) > 0 {
+		fmt.Println()
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 8, 2, ' ', 0)
+		fmt.Fprintln(w, "VARIABLE\tDEFAULT\tDESCRIPTION")
+
+		for _, v := range workload.Vars {
+			def := v.Default
+
+			if def == "" {
+				def = "(required)"
+			}
+
+			fmt.Fprintf(w, "%s\t%s\t%s\n", v.Name, def, v.Description)
+		}
+
+		w.Flush()
+	}
+
+	if workload.File != "" {
+		fmt.Printf("\n%s\n", strings.TrimSuffix(workload.File, "\n"))
+	}
+
+	return nil
+}
+
+// runWorkload sends a workload of the library, as send_cmd_args,
+// send_cmd_file or send_pipeline would
+func runWorkload(name string, specs []string) error {
+	library, err := benchdrilltasks.LoadLibrary(libraryDir)
+
+	if err != nil {
+		return err
+	}
+
+	workload, err := benchdrilltasks.FindWorkload(library, name)
+
+	if err != nil {
+		return err
+	}
+
+	set, err := benchdrilltasks.ParseVars(specs)
+
+	if err != nil {
+		return err
+	}
+
+	vars, err := workload.Values(set)
+
+	if err != nil {
+		return err
+	}
+
+	cmd, err := benchdrilltasks.Render(workload.Command, vars)
+
+	if err != nil {
+		return fmt.Errorf("Could not render command: %s", err.Error())
+	}
+
+	switch {
+	case workload.File != "":
+		return sendCmdFile(cmd, workload.File, vars, true)
+	case workload.Pipeline:
+		steps, err := pipelineSteps(cmd, "", "", "")
+
+		if err != nil {
+			return err
+		}
+
+		return sendPipeline(steps)
+	default:
+		return sendCmdArgs(cmd)
+	}
+}
+
+// isFilebench returns true if a command runs Filebench
+func isFilebench(cmd string) bool {
+	fields := strings.Fields(cmd)
+
+	return len(fields) > 0 && filepath.Base(fields[0]) == "filebench"
+}
+
+// lintWorkload checks a workload of Filebench before it is sent, logging its
+// errors and, if warn is set, its warnings
+func lintWorkload(workload string, warn bool) error {
+	issues := benchdrilltasks.LintWorkload(workload)
+
+	for _, issue := range issues {
+		if issue.Error {
+			benchdrilltasks.Log.Error(issue.String())
+		} else if warn {
+			benchdrilltasks.Log.Warning(issue.String())
+		}
+	}
+
+	if errors := benchdrilltasks.LintErrors(issues); errors > 0 {
+		return fmt.Errorf("%d errors in the workload, see workload lint", errors)
+	}
+
+	return nil
+}
+
+// lintWorkloadFile checks a workload file, or a workload of the library if
+// no such file exists, read from stdin if no name is given
+func lintWorkloadFile(name string, specs []string) error {
+	vars, err := benchdrilltasks.ParseVars(specs)
+
+	if err != nil {
+		return err
+	}
+
+	var content []byte
+
+	if name == "" {
+		content, err = ioutil.ReadAll(os.Stdin)
+	} else if _, err = os.Stat(name); err == nil {
+		content, err = ioutil.ReadFile(name)
+	} else {
+		content, vars, err = libraryWorkloadFile(name, vars)
+	}
+
+	if err != nil {
+		return err
+	}
+
+	workload, err := benchdrilltasks.RenderWorkload(string(content), vars, "group", "task", 0, 1)
+
+	if err != nil {
+		return err
+	}
+
+	issues := benchdrilltasks.LintWorkload(workload)
+
+	for _, issue := range issues {
+		fmt.Println(issue)
+	}
+
+	errors := benchdrilltasks.LintErrors(issues)
+	message := fmt.Sprintf("%d errors, %d warnings", errors, len(issues)-errors)
+
+	if errors > 0 {
+		return cli.NewExitError(message, 1)
+	}
+
+	benchdrilltasks.Log.Info(message)
+
+	return nil
+}
+
+// libraryWorkloadFile returns the workload file of a workload of the library
+// and the values of its variables
+func libraryWorkloadFile(name string, set map[string]string) ([]byte, map[string]string, error) {
+	library, err := benchdrilltasks.LoadLibrary(libraryDir)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	workload, err := benchdrilltasks.FindWorkload(library, name)
+
+	if err != nil {
+		return nil, nil, fmt.Errorf("No file %s and %s", name, err.Error())
+	}
+
+	if workload.File == "" {
+		return nil, nil, fmt.Errorf("Workload %s has no workload file", name)
+	}
+
+	vars, err := workload.Values(set)
+
+	return []byte(workload.File), vars, err
+}
@@ -0,0 +1,92 @@
+package benchdrilltasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+func addToArchive(tw *tar.Writer, path, name string, info os.FileInfo) error {
+	header, err := tar.FileInfoHeader(info, "")
+
+	if err != nil {
+		return err
+	}
+
+	header.Name = name
+
+	if err := tw.WriteHeader(header); err != nil {
+		return err
+	}
+
+	f, err := os.Open(path)
+
+	if err != nil {
+		return err
+	}
+
+	defer f.Close()
+
+	_, err = io.Copy(tw, f)
+
+	return err
+}
+
+// UnpackArchive extracts the files of a compressed archive, of artifacts or
+// of input files, to a directory, it returns the paths of the files extracted
+func UnpackArchive(data []byte, dest string) ([]string, error) {
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+
+	if err != nil {
+		return nil, err
+	}
+
+	tr := tar.NewReader(gz)
+
+	var files []string
+
+	for {
+		header, err := tr.Next()
+
+		if err == io.EOF {
+			return files, nil
+		}
+
+		if err != nil {
+			return files, err
+		}
+
+		path := filepath.Join(dest, filepath.FromSlash(header.Name))
+
+		// Do not let an archive write outside of the destination
+		if rel, err := filepath.Rel(dest, path); err != nil || strings.HasPrefix(rel, "..") {
+			return files, fmt.Errorf("Invalid path in archive: %s", header.Name)
+		}
+
+		if header.Typeflag != tar.TypeReg {
+			continue
+		}
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return files, err
+		}
+
+		content, err := ioutil.ReadAll(tr)
+
+		if err != nil {
+			return files, err
+		}
+
+		if err := ioutil.WriteFile(path, content, os.FileMode(header.Mode)&0777|0600); err != nil {
+			return files, err
+		}
+
+		files = append(files, path)
+	}
+}
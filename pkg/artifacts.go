@@ -0,0 +1,150 @@
+package benchdrilltasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ArtifactsHeader holds the paths or globs, separated by new lines, of the
+// files a task leaves which are collected after it ran. Relative paths are
+// relative to the working directory of the task
+const ArtifactsHeader = "benchdrill_artifacts"
+
+const artifactsPrefix = "benchdrill_artifacts_"
+
+// internalFiles are written by the workers in the working directory of the
+// tasks, they are not artifacts whatever the patterns
+var internalFiles = map[string]bool{
+	OutputFile:   true,
+	WorkloadFile: true,
+}
+
+// ArtifactsInfo describes the archive of the artifacts of a task
+type ArtifactsInfo struct {
+	TaskUUID  string    `json:"task_uuid"`
+	Worker    string    `json:"worker"`
+	Files     []string  `json:"files"`
+	Size      int       `json:"size"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// ArtifactPatterns returns the artifacts a task declares
+func ArtifactPatterns(headers map[string]interface{}) []string {
+	value, _ := headers[ArtifactsHeader].(string)
+
+	var patterns []string
+
+	for _, pattern := range strings.Split(value, "\n") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+
+	return patterns
+}
+
+// PackArtifacts makes a compressed archive of the files matching patterns,
+// directories being archived with their content. Files of dir are archived
+// under their path relative to dir, other files under their absolute path.
+// The internal files of the workers in dir are left out
+func PackArtifacts(dir string, patterns []string) ([]byte, []string, error) {
+	var (
+		buf   bytes.Buffer
+		files []string
+	)
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	archived := make(map[string]bool)
+
+	for _, pattern := range patterns {
+		if !filepath.IsAbs(pattern) {
+			pattern = filepath.Join(dir, pattern)
+		}
+
+		matches, err := filepath.Glob(pattern)
+
+		if err != nil {
+			return nil, nil, fmt.Errorf("Invalid artifact pattern %q: %s", pattern, err.Error())
+		}
+
+		for _, match := range matches {
+			err := filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil || !info.Mode().IsRegular() || archived[path] {
+					return err
+				}
+
+				name := artifactName(dir, path)
+
+				if internalFiles[name] {
+					return nil
+				}
+
+				archived[path] = true
+				files = append(files, name)
+
+				return addToArchive(tw, path, name, info)
+			})
+
+			if err != nil {
+				return nil, nil, fmt.Errorf("Could not archive %s: %s", match, err.Error())
+			}
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, nil, err
+	}
+
+	sort.Strings(files)
+
+	return buf.Bytes(), files, nil
+}
+
+// artifactName returns the name in the archive of a file
+func artifactName(dir, path string) string {
+	if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+		return filepath.ToSlash(rel)
+	}
+
+	return strings.TrimPrefix(filepath.ToSlash(path), "/")
+}
+
+// SaveArtifacts stores the archive of the artifacts of a task in blobs and
+// its description in the Store
+func SaveArtifacts(store Store, blobs BlobStore, info *ArtifactsInfo, archive []byte, expireIn int) error {
+	if err := blobs.Put(artifactsPrefix+info.TaskUUID, archive, expireIn); err != nil {
+		return err
+	}
+
+	return saveJSON(store, artifactsPrefix+"info_"+info.TaskUUID, info, expireIn)
+}
+
+// GetArtifacts returns the description and the archive of the artifacts of
+// a task
+func GetArtifacts(store Store, blobs BlobStore, taskUUID string) (*ArtifactsInfo, []byte, error) {
+	info := new(ArtifactsInfo)
+
+	if err := loadJSON(store, artifactsPrefix+"info_"+taskUUID, info); err != nil {
+		return nil, nil, err
+	}
+
+	archive, err := blobs.Get(artifactsPrefix + taskUUID)
+
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return info, archive, nil
+}
@@ -0,0 +1,53 @@
+package benchdrilltasks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestPackArtifactsInternalFiles(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	for _, name := range []string{OutputFile, WorkloadFile, "result.csv", filepath.Join("logs", "run.log")} {
+		path := filepath.Join(dir, name)
+
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+
+		if err := ioutil.WriteFile(path, []byte(name), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Globs match the files of the worker too, they are left out
+	archive, files, err := PackArtifacts(dir, []string{"*", ".*"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if expected := []string{"logs/run.log", "result.csv"}; !reflect.DeepEqual(files, expected) {
+		t.Errorf("Files %v, expected %v", files, expected)
+	}
+
+	dest := filepath.Join(dir, "unpacked")
+	unpacked, err := UnpackArchive(archive, dest)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(unpacked) != 2 {
+		t.Errorf("Unpacked %v, expected the 2 artifacts", unpacked)
+	}
+}
@@ -0,0 +1,163 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// Size of the chunks large values are split in when stored in Redis
+const blobChunkSize = 512 * 1024
+
+// BlobStore keeps large values, such as archives of artifacts
+type BlobStore interface {
+	Put(key string, data []byte, expireIn int) error
+	Get(key string) ([]byte, error)
+	// Expire makes a value expire after expireIn seconds, or never if 0
+	Expire(key string, expireIn int) error
+}
+
+// NewBlobStore creates a BlobStore which writes to a directory shared by the
+// workers and the clients (e.g. a network file system) if dir is not empty,
+// or splits the values in chunks kept in the Store otherwise
+func NewBlobStore(store Store, dir string) BlobStore {
+	if dir != "" {
+		return &DirBlobStore{dir: dir}
+	}
+
+	return &ChunkedBlobStore{store: store}
+}
+
+// ChunkedBlobStore is a BlobStore keeping values in chunks in a Store, so
+// that no single Redis value gets too large
+type ChunkedBlobStore struct {
+	store Store
+}
+
+// Put stores the chunks of the value, then their number at key
+func (s *ChunkedBlobStore) Put(key string, data []byte, expireIn int) error {
+	chunks := 0
+
+	for offset := 0; offset < len(data) || chunks == 0; offset += blobChunkSize {
+		end := offset + blobChunkSize
+
+		if end > len(data) {
+			end = len(data)
+		}
+
+		if err := s.store.Set(fmt.Sprintf("%s_%d", key, chunks), data[offset:end], expireIn); err != nil {
+			return err
+		}
+
+		chunks++
+	}
+
+	return s.store.Set(key, []byte(strconv.Itoa(chunks)), expireIn)
+}
+
+// Get reads the chunks of the value stored at key
+func (s *ChunkedBlobStore) Get(key string) ([]byte, error) {
+	value, err := s.store.Get(key)
+
+	if err != nil {
+		return nil, err
+	}
+
+	chunks, err := strconv.Atoi(string(value))
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid number of chunks of %s: %s", key, err.Error())
+	}
+
+	var data bytes.Buffer
+
+	for i := 0; i < chunks; i++ {
+		chunk, err := s.store.Get(fmt.Sprintf("%s_%d", key, i))
+
+		if err != nil {
+			return nil, err
+		}
+
+		data.Write(chunk)
+	}
+
+	return data.Bytes(), nil
+}
+
+// Expire makes the chunks of the value stored at key expire, then their number
+func (s *ChunkedBlobStore) Expire(key string, expireIn int) error {
+	value, err := s.store.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	chunks, err := strconv.Atoi(string(value))
+
+	if err != nil {
+		return fmt.Errorf("Invalid number of chunks of %s: %s", key, err.Error())
+	}
+
+	for i := 0; i < chunks; i++ {
+		if err := s.store.Expire(fmt.Sprintf("%s_%d", key, i), expireIn); err != nil {
+			return err
+		}
+	}
+
+	return s.store.Expire(key, expireIn)
+}
+
+// DirBlobStore is a BlobStore keeping values in files of a directory, they
+// do not expire
+type DirBlobStore struct {
+	dir string
+}
+
+// Put writes the value to a file named after key, renamed once complete
+func (s *DirBlobStore) Put(key string, data []byte, expireIn int) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return err
+	}
+
+	tmp, err := ioutil.TempFile(s.dir, "."+key)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+
+	return os.Rename(tmp.Name(), filepath.Join(s.dir, key))
+}
+
+// Get reads the file named after key
+func (s *DirBlobStore) Get(key string) ([]byte, error) {
+	data, err := ioutil.ReadFile(filepath.Join(s.dir, key))
+
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+
+	return data, err
+}
+
+// Expire only checks the file named after key exists, files do not expire
+func (s *DirBlobStore) Expire(key string, expireIn int) error {
+	if _, err := os.Stat(filepath.Join(s.dir, key)); os.IsNotExist(err) {
+		return ErrNotFound
+	}
+
+	return nil
+}
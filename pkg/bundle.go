@@ -0,0 +1,271 @@
+package benchdrilltasks
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// BundleHeader holds the hash of the bundle of input files unpacked in the
+// working directory of a task before it runs
+const BundleHeader = "benchdrill_bundle"
+
+const bundlePrefix = "benchdrill_bundle_"
+
+// BundleFiles lists the input files of a bundle, by name in the bundle:
+// files given as "name=path" (or just "path", named after the file), and
+// the files of directories, named after their path in the directory
+func BundleFiles(specs, dirs []string) (map[string]string, error) {
+	files := make(map[string]string)
+
+	add := func(name, path string) error {
+		name, err := bundleName(name)
+
+		if err != nil {
+			return err
+		}
+
+		if previous, ok := files[name]; ok {
+			return fmt.Errorf("Input files %s and %s have the same name %s", previous, path, name)
+		}
+
+		files[name] = path
+
+		return nil
+	}
+
+	for _, spec := range specs {
+		name, path := filepath.Base(spec), spec
+
+		if i := strings.Index(spec, "="); i >= 0 {
+			name, path = spec[:i], spec[i+1:]
+		}
+
+		if err := add(name, path); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, dir := range dirs {
+		err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+			if err != nil || !info.Mode().IsRegular() {
+				return err
+			}
+
+			rel, err := filepath.Rel(dir, path)
+
+			if err != nil {
+				return err
+			}
+
+			return add(rel, path)
+		})
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not read input directory: %s", err.Error())
+		}
+	}
+
+	return files, nil
+}
+
+// bundleName cleans the name of an input file, which must be relative
+func bundleName(name string) (string, error) {
+	name = filepath.ToSlash(filepath.Clean(name))
+
+	if filepath.IsAbs(name) || name == "." || name == ".." || strings.HasPrefix(name, "../") {
+		return "", fmt.Errorf("Invalid name of input file %q, it must be relative", name)
+	}
+
+	return name, nil
+}
+
+// bundleEntry is an input file as packed in a bundle
+type bundleEntry struct {
+	content []byte
+	mode    os.FileMode
+}
+
+// PackBundle makes the compressed archive of input files and returns it
+// with its hash. The archive only depends on the names, contents and
+// permissions of the files, so that identical inputs get the same hash
+func PackBundle(files map[string]string) ([]byte, string, error) {
+	entries := make(map[string]*bundleEntry)
+
+	for name, path := range files {
+		info, err := os.Stat(path)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not read input file: %s", err.Error())
+		}
+
+		content, err := ioutil.ReadFile(path)
+
+		if err != nil {
+			return nil, "", fmt.Errorf("Could not read input file: %s", err.Error())
+		}
+
+		entries[name] = &bundleEntry{content: content, mode: info.Mode().Perm()}
+	}
+
+	return packEntries(entries)
+}
+
+// PackBundleContents makes the archive of input files given by name and
+// content rather than read from disk, as PackBundle does
+func PackBundleContents(contents map[string][]byte) ([]byte, string, error) {
+	entries := make(map[string]*bundleEntry)
+
+	for name, content := range contents {
+		name, err := bundleName(name)
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if _, ok := entries[name]; ok {
+			return nil, "", fmt.Errorf("Input file %s is given twice", name)
+		}
+
+		entries[name] = &bundleEntry{content: content, mode: 0644}
+	}
+
+	return packEntries(entries)
+}
+
+func packEntries(entries map[string]*bundleEntry) ([]byte, string, error) {
+	var (
+		buf   bytes.Buffer
+		names []string
+	)
+
+	for name := range entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+
+	for _, name := range names {
+		entry := entries[name]
+
+		header := &tar.Header{
+			Name:     name,
+			Mode:     int64(entry.mode),
+			Size:     int64(len(entry.content)),
+			Typeflag: tar.TypeReg,
+		}
+
+		if err := tw.WriteHeader(header); err != nil {
+			return nil, "", err
+		}
+
+		if _, err := tw.Write(entry.content); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	if err := gz.Close(); err != nil {
+		return nil, "", err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+
+	return buf.Bytes(), hex.EncodeToString(sum[:]), nil
+}
+
+// SaveBundle stores a bundle in blobs unless a bundle with the same hash is
+// already stored, it returns whether the bundle was stored. The bundle is
+// referenced by the group and kept, without expiry, until each group
+// referencing it is released by ReleaseBundle
+func SaveBundle(store Store, blobs BlobStore, hash string, archive []byte, groupUUID string) (bool, error) {
+	// Referenced first, so that it is not released meanwhile
+	if err := store.Set(bundleRefPrefix(hash)+groupUUID, []byte("1"), 0); err != nil {
+		return false, err
+	}
+
+	// A bundle whose groups completed expires, it is kept again
+	if _, err := store.Get(bundlePrefix + "info_" + hash); err == nil {
+		if blobs.Expire(bundlePrefix+hash, 0) == nil && store.Expire(bundlePrefix+"info_"+hash, 0) == nil {
+			return false, nil
+		}
+	}
+
+	if err := blobs.Put(bundlePrefix+hash, archive, 0); err != nil {
+		return false, err
+	}
+
+	return true, store.Set(bundlePrefix+"info_"+hash, []byte(fmt.Sprint(len(archive))), 0)
+}
+
+// ReleaseBundle drops the reference of a completed group to a bundle. Once no
+// group references it, the bundle expires after expireIn seconds (never if 0),
+// groups sent meanwhile reuse it
+func ReleaseBundle(store Store, blobs BlobStore, hash, groupUUID string, expireIn int) error {
+	if err := store.Del(bundleRefPrefix(hash) + groupUUID); err != nil {
+		return err
+	}
+
+	refs, err := store.Keys(bundleRefPrefix(hash) + "*")
+
+	if err != nil || len(refs) > 0 {
+		return err
+	}
+
+	if err := expireBundle(store, blobs, hash, expireIn); err != nil {
+		return err
+	}
+
+	// A group may have referenced the bundle meanwhile, it is kept then
+	if refs, err = store.Keys(bundleRefPrefix(hash) + "*"); err != nil || len(refs) == 0 {
+		return err
+	}
+
+	return expireBundle(store, blobs, hash, 0)
+}
+
+// expireBundle makes a bundle expire after expireIn seconds, or never if 0
+func expireBundle(store Store, blobs BlobStore, hash string, expireIn int) error {
+	if err := blobs.Expire(bundlePrefix+hash, expireIn); err != nil && err != ErrNotFound {
+		return err
+	}
+
+	if err := store.Expire(bundlePrefix+"info_"+hash, expireIn); err != nil && err != ErrNotFound {
+		return err
+	}
+
+	return nil
+}
+
+func bundleRefPrefix(hash string) string {
+	return bundlePrefix + "ref_" + hash + "_"
+}
+
+// GetBundle reads a bundle from blobs and checks its hash
+func GetBundle(blobs BlobStore, hash string) ([]byte, error) {
+	archive, err := blobs.Get(bundlePrefix + hash)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if sum := sha256.Sum256(archive); hex.EncodeToString(sum[:]) != hash {
+		return nil, fmt.Errorf("Bundle %s is corrupted", hash)
+	}
+
+	return archive, nil
+}
@@ -0,0 +1,81 @@
+package benchdrilltasks
+
+import (
+	"testing"
+)
+
+func TestBundleReferences(t *testing.T) {
+	r, err := ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	store := NewRedisStore("tcp", r.listener.Addr().String(), "", 0)
+	blobs := NewBlobStore(store, "")
+	archive, hash, err := PackBundleContents(map[string][]byte{"input": []byte("data")})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// expiring returns whether the keys of the bundle expire, or fails if
+	// they are gone
+	expiring := func() bool {
+		r.mu.Lock()
+		defer r.mu.Unlock()
+
+		expires := false
+
+		for _, key := range []string{bundlePrefix + hash, bundlePrefix + hash + "_0", bundlePrefix + "info_" + hash} {
+			value := r.lookup(key)
+
+			if value == nil {
+				t.Fatalf("Key %s of the bundle is gone", key)
+			}
+
+			expires = expires || !value.expires.IsZero()
+		}
+
+		return expires
+	}
+
+	if stored, err := SaveBundle(store, blobs, hash, archive, "group_a"); err != nil || !stored {
+		t.Fatalf("SaveBundle returned %v, %v, expected the bundle to be stored", stored, err)
+	}
+
+	if stored, err := SaveBundle(store, blobs, hash, archive, "group_b"); err != nil || stored {
+		t.Fatalf("SaveBundle returned %v, %v, expected the bundle to be reused", stored, err)
+	}
+
+	if err := ReleaseBundle(store, blobs, hash, "group_a", 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if expiring() {
+		t.Errorf("Bundle expires while group_b references it")
+	}
+
+	if err := ReleaseBundle(store, blobs, hash, "group_b", 60); err != nil {
+		t.Fatal(err)
+	}
+
+	if !expiring() {
+		t.Errorf("Bundle does not expire once released")
+	}
+
+	// Reused by a later group, it is kept again
+	if stored, err := SaveBundle(store, blobs, hash, archive, "group_c"); err != nil || stored {
+		t.Fatalf("SaveBundle returned %v, %v, expected the bundle to be reused", stored, err)
+	}
+
+	if expiring() {
+		t.Errorf("Bundle expires while group_c references it")
+	}
+
+	if got, err := GetBundle(blobs, hash); err != nil || string(got) != string(archive) {
+		t.Errorf("GetBundle returned %d bytes, %v", len(got), err)
+	}
+}
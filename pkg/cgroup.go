@@ -0,0 +1,410 @@
+package benchdrilltasks
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// LimitsHeader holds the resource limits of a task, as JSON
+const LimitsHeader = "benchdrill_limits"
+
+// Mount point of the cgroup v2 hierarchy
+const cgroupMount = "/sys/fs/cgroup"
+
+// Period of the CPU quota of the cgroups, the default of the kernel
+const cpuPeriod = 100000
+
+// Controllers enabled for the cgroups of the tasks
+var cgroupControllers = []string{"cpu", "cpuset", "memory", "io"}
+
+var (
+	cpusetRegexp = regexp.MustCompile(`^[0-9]+(-[0-9]+)?(,[0-9]+(-[0-9]+)?)*$`)
+	memoryRegexp = regexp.MustCompile(`^([0-9]+)([KMGT]?)I?B?$`)
+)
+
+// Limits are the resources the process tree of a task may use
+type Limits struct {
+	// CPU quota, in CPUs (e.g. 1.5), unlimited if 0
+	CPUs float64 `json:"cpus,omitempty"`
+	// CPUs the task may run on, e.g. "0-3,6"
+	Cpuset string `json:"cpuset,omitempty"`
+	// Memory limit in bytes, unlimited if 0
+	Memory int64 `json:"memory,omitempty"`
+	// Weight of the task in the IO scheduling, 1 to 10000 (100 by default)
+	IOWeight int `json:"io_weight,omitempty"`
+}
+
+// IsZero returns whether no limit is set
+func (l *Limits) IsZero() bool {
+	return l == nil || *l == Limits{}
+}
+
+// Validate checks the limits are in the ranges the kernel accepts
+func (l *Limits) Validate() error {
+	if l.CPUs < 0 {
+		return fmt.Errorf("Invalid CPU quota %g: expected a positive number", l.CPUs)
+	}
+
+	if l.Cpuset != "" && !cpusetRegexp.MatchString(l.Cpuset) {
+		return fmt.Errorf("Invalid cpuset %q: expected a list of CPUs or ranges, e.g. 0-3,6", l.Cpuset)
+	}
+
+	if l.Memory < 0 {
+		return fmt.Errorf("Invalid memory limit %d: expected a positive number of bytes", l.Memory)
+	}
+
+	if l.IOWeight != 0 && (l.IOWeight < 1 || l.IOWeight > 10000) {
+		return fmt.Errorf("Invalid IO weight %d: expected 1 to 10000", l.IOWeight)
+	}
+
+	return nil
+}
+
+// ParseMemory parses an amount of memory in bytes, with an optional binary
+// unit, e.g. "512M" or "2GiB"
+func ParseMemory(value string) (int64, error) {
+	match := memoryRegexp.FindStringSubmatch(strings.ToUpper(strings.TrimSpace(value)))
+
+	if match == nil {
+		return 0, fmt.Errorf("Invalid amount of memory %q: expected bytes with an optional unit, e.g. 512M", value)
+	}
+
+	n, err := strconv.ParseInt(match[1], 10, 64)
+
+	if err != nil {
+		return 0, fmt.Errorf("Invalid amount of memory %q: %s", value, err.Error())
+	}
+
+	if match[2] != "" {
+		n <<= 10 * uint(strings.Index("KMGT", match[2])+1)
+	}
+
+	return n, nil
+}
+
+// TaskLimits returns the limits a task declares, nil if none
+func TaskLimits(headers map[string]interface{}) (*Limits, error) {
+	value, _ := headers[LimitsHeader].(string)
+
+	if value == "" {
+		return nil, nil
+	}
+
+	limits := new(Limits)
+
+	if err := json.Unmarshal([]byte(value), limits); err != nil {
+		return nil, fmt.Errorf("Invalid limits %q: %s", value, err.Error())
+	}
+
+	if err := limits.Validate(); err != nil {
+		return nil, err
+	}
+
+	return limits, nil
+}
+
+// SetLimits declares the limits of a task in its headers, created if nil
+func SetLimits(headers map[string]interface{}, limits *Limits) map[string]interface{} {
+	if limits.IsZero() {
+		return headers
+	}
+
+	if headers == nil {
+		headers = make(map[string]interface{})
+	}
+
+	value, _ := json.Marshal(limits)
+	headers[LimitsHeader] = string(value)
+
+	return headers
+}
+
+// Cgroups creates the cgroups of the tasks of a worker, below the cgroup
+// delegated to the worker
+type Cgroups struct {
+	root string
+	// Controllers enabled for the cgroups of the tasks
+	controllers map[string]bool
+	// Shell moving the commands into their cgroup before they execute
+	sh string
+}
+
+// NewCgroups sets up a cgroup v2 to hold the cgroups of the tasks, by default
+// the cgroup of the worker. Its processes, the worker included, are moved to
+// a "worker" leaf, since only the leaves of a cgroup v2 tree hold processes.
+// It fails if the cgroup is not writable, e.g. when cgroups are v1 or the
+// container does not own its cgroup
+func NewCgroups(root string) (*Cgroups, error) {
+	if root == "" {
+		var err error
+
+		if root, err = ownCgroup(); err != nil {
+			return nil, err
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err != nil {
+		return nil, fmt.Errorf("%s is not a cgroup v2", root)
+	}
+
+	sh, err := exec.LookPath("sh")
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not find a shell to move the commands into their cgroup: %s", err.Error())
+	}
+
+	leaf := filepath.Join(root, "worker")
+
+	if err := os.Mkdir(leaf, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("Could not create cgroup %s: %s", leaf, err.Error())
+	}
+
+	procs, err := ioutil.ReadFile(filepath.Join(root, "cgroup.procs"))
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read the processes of cgroup %s: %s", root, err.Error())
+	}
+
+	for _, pid := range strings.Fields(string(procs)) {
+		if err := writeCgroupFile(leaf, "cgroup.procs", pid); err != nil {
+			return nil, fmt.Errorf("Could not move process %s to cgroup %s: %s", pid, leaf, err.Error())
+		}
+	}
+
+	c := &Cgroups{root: root, controllers: make(map[string]bool), sh: sh}
+
+	// Controllers not delegated to the cgroup stay disabled, limits needing
+	// them cannot be applied
+	for _, controller := range cgroupControllers {
+		if writeCgroupFile(root, "cgroup.subtree_control", "+"+controller) == nil {
+			c.controllers[controller] = true
+		}
+	}
+
+	return c, nil
+}
+
+// ownCgroup returns the cgroup v2 of the current process
+func ownCgroup() (string, error) {
+	f, err := os.Open("/proc/self/cgroup")
+
+	if err != nil {
+		return "", err
+	}
+
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		// The unified hierarchy is the one with ID 0 and no controller list
+		if path := strings.TrimPrefix(scanner.Text(), "0::"); path != scanner.Text() {
+			return filepath.Join(cgroupMount, path), nil
+		}
+	}
+
+	return "", errors.New("Cgroups v2 are not mounted")
+}
+
+// Root returns the cgroup holding the cgroups of the tasks
+func (c *Cgroups) Root() string {
+	return c.root
+}
+
+// Create creates the cgroup of a task with its limits. It fails if a limit
+// needs a controller which is not enabled
+func (c *Cgroups) Create(name string, limits *Limits) (*Cgroup, error) {
+	if limits == nil {
+		limits = new(Limits)
+	}
+
+	var files [][2]string
+
+	if limits.CPUs > 0 {
+		files = append(files, [2]string{"cpu.max", fmt.Sprintf("%d %d", int64(limits.CPUs*cpuPeriod), cpuPeriod)})
+	}
+
+	if limits.Cpuset != "" {
+		files = append(files, [2]string{"cpuset.cpus", limits.Cpuset})
+	}
+
+	if limits.Memory > 0 {
+		files = append(files, [2]string{"memory.max", strconv.FormatInt(limits.Memory, 10)})
+	}
+
+	if limits.IOWeight > 0 {
+		files = append(files, [2]string{"io.weight", fmt.Sprintf("default %d", limits.IOWeight)})
+	}
+
+	for _, file := range files {
+		controller := strings.SplitN(file[0], ".", 2)[0]
+
+		if !c.controllers[controller] {
+			return nil, fmt.Errorf("Controller %s is not enabled in cgroup %s", controller, c.root)
+		}
+	}
+
+	g := &Cgroup{dir: filepath.Join(c.root, "task_"+name), sh: c.sh}
+
+	// The cgroup of a previous attempt is reused if it could not be removed
+	if err := os.Mkdir(g.dir, 0755); err != nil && !os.IsExist(err) {
+		return nil, fmt.Errorf("Could not create cgroup %s: %s", g.dir, err.Error())
+	}
+
+	for _, file := range files {
+		if err := writeCgroupFile(g.dir, file[0], file[1]); err != nil {
+			g.Remove()
+			return nil, fmt.Errorf("Could not set %s of cgroup %s to %q: %s", file[0], g.dir, file[1], err.Error())
+		}
+	}
+
+	return g, nil
+}
+
+// Cgroup is the cgroup of a task
+type Cgroup struct {
+	dir string
+	sh  string
+}
+
+// Dir returns the directory of the cgroup
+func (g *Cgroup) Dir() string {
+	return g.dir
+}
+
+// wrap makes a command move into the cgroup before it executes, so that all
+// the processes it starts are accounted and limited, without a window where
+// they run outside of it
+func (g *Cgroup) wrap(command *exec.Cmd) {
+	command.Args = append([]string{"sh", "-c", `echo 0 > "$0" && exec "$@"`, filepath.Join(g.dir, "cgroup.procs"), command.Path}, command.Args[1:]...)
+
+	// Commands which cannot be found fail as they would unwrapped
+	if command.Err == nil {
+		command.Path = g.sh
+	}
+}
+
+// Usage reads the accounting of the cgroup as a "Cgroup" section of
+// "name: value" lines, parsed by ParseMetrics as cgroup.* metrics. Files of
+// controllers which are not enabled are skipped
+func (g *Cgroup) Usage() string {
+	var b bytes.Buffer
+
+	b.WriteString("\nCgroup:\n")
+
+	if stat, err := readCgroupStat(g.dir, "cpu.stat"); err == nil {
+		for _, key := range []string{"usage_usec", "user_usec", "system_usec", "nr_periods", "nr_throttled", "throttled_usec"} {
+			if value, ok := stat[key]; ok {
+				fmt.Fprintf(&b, "    cpu.%s: %d\n", key, value)
+			}
+		}
+	}
+
+	if peak, err := ioutil.ReadFile(filepath.Join(g.dir, "memory.peak")); err == nil {
+		fmt.Fprintf(&b, "    memory.peak: %s\n", strings.TrimSpace(string(peak)))
+	}
+
+	if events, err := readCgroupStat(g.dir, "memory.events"); err == nil {
+		fmt.Fprintf(&b, "    memory.oom_kill: %d\n", events["oom_kill"])
+	}
+
+	if io, err := ioutil.ReadFile(filepath.Join(g.dir, "io.stat")); err == nil {
+		totals := make(map[string]int64)
+
+		// One line per device, e.g. "8:0 rbytes=1 wbytes=2 rios=3 wios=4 …"
+		for _, line := range strings.Split(string(io), "\n") {
+			for _, field := range strings.Fields(line) {
+				if kv := strings.SplitN(field, "=", 2); len(kv) == 2 {
+					n, _ := strconv.ParseInt(kv[1], 10, 64)
+					totals[kv[0]] += n
+				}
+			}
+		}
+
+		for _, key := range []string{"rbytes", "wbytes", "rios", "wios"} {
+			fmt.Fprintf(&b, "    io.%s: %d\n", key, totals[key])
+		}
+	}
+
+	return b.String()
+}
+
+// Remove kills the processes left in the cgroup and removes it
+func (g *Cgroup) Remove() error {
+	// cgroup.kill exists since Linux 5.14
+	writeCgroupFile(g.dir, "cgroup.kill", "1")
+
+	return os.Remove(g.dir)
+}
+
+// readCgroupStat reads a flat keyed file of a cgroup, e.g. cpu.stat
+func readCgroupStat(dir, name string) (map[string]int64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, name))
+
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]int64)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		if fields := strings.Fields(line); len(fields) == 2 {
+			stat[fields[0]], _ = strconv.ParseInt(fields[1], 10, 64)
+		}
+	}
+
+	return stat, nil
+}
+
+func writeCgroupFile(dir, name, value string) error {
+	f, err := os.OpenFile(filepath.Join(dir, name), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(value); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// Cgroups of the tasks being processed, by working directory, looked up by
+// the tasks to run their command in it
+var (
+	taskCgroups   = make(map[string]*Cgroup)
+	taskCgroupsMu sync.Mutex
+)
+
+func registerCgroup(dir string, g *Cgroup) func() {
+	taskCgroupsMu.Lock()
+	taskCgroups[dir] = g
+	taskCgroupsMu.Unlock()
+
+	return func() {
+		taskCgroupsMu.Lock()
+		delete(taskCgroups, dir)
+		taskCgroupsMu.Unlock()
+	}
+}
+
+// cgroupOf returns the cgroup of the task running in a directory, nil if none
+func cgroupOf(dir string) *Cgroup {
+	taskCgroupsMu.Lock()
+	defer taskCgroupsMu.Unlock()
+
+	return taskCgroups[dir]
+}
@@ -0,0 +1,203 @@
+package benchdrilltasks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseMemory(t *testing.T) {
+	for value, expected := range map[string]int64{
+		"1048576": 1 << 20,
+		"512M":    512 << 20,
+		"2GiB":    2 << 30,
+		"64k":     64 << 10,
+		"1T":      1 << 40,
+	} {
+		if n, err := ParseMemory(value); err != nil || n != expected {
+			t.Errorf("Parsed %q as %d (%v), expected %d", value, n, err, expected)
+		}
+	}
+
+	for _, invalid := range []string{"", "-1M", "1.5G", "12X"} {
+		if _, err := ParseMemory(invalid); err == nil {
+			t.Errorf("Expected an error for %q", invalid)
+		}
+	}
+}
+
+func TestTaskLimits(t *testing.T) {
+	limits := &Limits{CPUs: 1.5, Cpuset: "0-3,6", Memory: 512 << 20, IOWeight: 200}
+	headers := SetLimits(nil, limits)
+
+	parsed, err := TaskLimits(headers)
+
+	if err != nil || !reflect.DeepEqual(parsed, limits) {
+		t.Errorf("Got limits %+v (%v), expected %+v", parsed, err, limits)
+	}
+
+	if headers := SetLimits(nil, &Limits{}); headers != nil {
+		t.Errorf("Headers %v set without limits", headers)
+	}
+
+	if parsed, err := TaskLimits(nil); parsed != nil || err != nil {
+		t.Errorf("Got limits %+v (%v) without header", parsed, err)
+	}
+
+	for _, invalid := range []string{`{"cpus":-1}`, `{"cpuset":"0-"}`, `{"io_weight":20000}`, `nope`} {
+		if _, err := TaskLimits(map[string]interface{}{LimitsHeader: invalid}); err == nil {
+			t.Errorf("Expected an error for limits %s", invalid)
+		}
+	}
+}
+
+// fakeCgroup creates a directory standing for a cgroup v2 delegated to the
+// worker, holding two processes
+func fakeCgroup(t *testing.T) string {
+	root, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string]string{
+		"cgroup.controllers":     "cpuset cpu io memory pids",
+		"cgroup.procs":           "1\n42\n",
+		"cgroup.subtree_control": "",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(root, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return root
+}
+
+func TestCgroups(t *testing.T) {
+	root := fakeCgroup(t)
+	defer os.RemoveAll(root)
+
+	cgroups, err := NewCgroups(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Files of the fake hierarchy keep the last value written
+	if procs, _ := ioutil.ReadFile(filepath.Join(root, "worker", "cgroup.procs")); string(procs) != "42" {
+		t.Errorf("Last process moved to the leaf %q, expected 42", procs)
+	}
+
+	cgroup, err := cgroups.Create("task_1", &Limits{CPUs: 1.5, Cpuset: "0-1", Memory: 1 << 30, IOWeight: 50})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, expected := range map[string]string{
+		"cpu.max":     "150000 100000",
+		"cpuset.cpus": "0-1",
+		"memory.max":  "1073741824",
+		"io.weight":   "default 50",
+	} {
+		if value, _ := ioutil.ReadFile(filepath.Join(cgroup.Dir(), name)); string(value) != expected {
+			t.Errorf("%s is %q, expected %q", name, value, expected)
+		}
+	}
+
+	for name, content := range map[string]string{
+		"cpu.stat":      "usage_usec 2000000\nuser_usec 1500000\nsystem_usec 500000\nnr_periods 20\nnr_throttled 5\nthrottled_usec 300000\n",
+		"memory.peak":   "52428800\n",
+		"memory.events": "low 0\nhigh 0\nmax 3\noom 1\noom_kill 1\n",
+		"io.stat":       "8:0 rbytes=4096 wbytes=8192 rios=1 wios=2 dbytes=0 dios=0\n8:16 rbytes=4096 wbytes=0 rios=1 wios=0 dbytes=0 dios=0\n",
+	} {
+		if err := ioutil.WriteFile(filepath.Join(cgroup.Dir(), name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	unregister := registerCgroup(dir, cgroup)
+	out, err := TaskArgs("echo events per second: 1234.56", dir)
+	unregister()
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The shell wrapping the command moved itself into the cgroup
+	if procs, _ := ioutil.ReadFile(filepath.Join(cgroup.Dir(), "cgroup.procs")); strings.TrimSpace(string(procs)) != "0" {
+		t.Errorf("Wrote %q to cgroup.procs, expected 0", procs)
+	}
+
+	metrics := ParseMetrics(out)
+
+	for name, expected := range map[string]float64{
+		"events_per_second":       1234.56,
+		"cgroup.cpu_usage_usec":   2000000,
+		"cgroup.cpu_nr_throttled": 5,
+		"cgroup.memory_peak":      52428800,
+		"cgroup.memory_oom_kill":  1,
+		"cgroup.io_rbytes":        8192,
+		"cgroup.io_wios":          2,
+	} {
+		if metrics[name] != expected {
+			t.Errorf("%s is %v, expected %v in %q", name, metrics[name], expected, out)
+		}
+	}
+}
+
+func TestCgroupsFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if _, err := NewCgroups(dir); err == nil {
+		t.Error("Expected an error for a directory which is not a cgroup")
+	}
+
+	root := fakeCgroup(t)
+	defer os.RemoveAll(root)
+
+	// Controllers cannot be enabled when subtree_control is not writable
+	os.Remove(filepath.Join(root, "cgroup.subtree_control"))
+	os.Mkdir(filepath.Join(root, "cgroup.subtree_control"), 0755)
+
+	cgroups, err := NewCgroups(root)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cgroups.Create("task_1", &Limits{Memory: 1 << 20}); err == nil {
+		t.Error("Expected an error for a memory limit without the memory controller")
+	}
+
+	// Cgroups without limits only account
+	if _, err := cgroups.Create("task_2", nil); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestParseMetricsFilebenchCgroup(t *testing.T) {
+	metrics := ParseMetrics("IO Summary: 5000 ops 500.000 ops/s 250/250 rd/wr 10.0mb/s 2.000ms/op\n" +
+		"    12.345: Run took 10 seconds\n\nCgroup:\n    cpu.usage_usec: 100\n")
+
+	if metrics["filebench.ops_per_s"] != 500 || metrics["cgroup.cpu_usage_usec"] != 100 || len(metrics) != 7 {
+		t.Errorf("Parsed %v", metrics)
+	}
+}
@@ -0,0 +1,207 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Cron is a parsed cron expression: minute, hour, day of month, month and
+// day of week, as in crontab(5)
+type Cron struct {
+	minute, hour, dom, month, dow cronField
+	// Whether the day of month and the day of week are restricted, in which
+	// case a day matching either of them matches
+	domStar, dowStar bool
+}
+
+// cronField is the set of values of a field, as bits
+type cronField uint64
+
+func (f cronField) has(value int) bool {
+	return f&(1<<uint(value)) != 0
+}
+
+var cronMacros = map[string]string{
+	"@yearly":   "0 0 1 1 *",
+	"@annually": "0 0 1 1 *",
+	"@monthly":  "0 0 1 * *",
+	"@weekly":   "0 0 * * 0",
+	"@daily":    "0 0 * * *",
+	"@midnight": "0 0 * * *",
+	"@hourly":   "0 * * * *",
+}
+
+var (
+	monthNames = []string{"jan", "feb", "mar", "apr", "may", "jun", "jul", "aug", "sep", "oct", "nov", "dec"}
+	dayNames   = []string{"sun", "mon", "tue", "wed", "thu", "fri", "sat"}
+)
+
+// ParseCron parses a cron expression of 5 fields, e.g. "0 2 * * 1-5", or a
+// macro such as @daily. Fields are lists of values, ranges ("1-5") and steps
+// ("*/15", "0-30/10"), months and days of week can be given by name
+func ParseCron(spec string) (*Cron, error) {
+	if macro, ok := cronMacros[strings.TrimSpace(spec)]; ok {
+		spec = macro
+	}
+
+	fields := strings.Fields(spec)
+
+	if len(fields) != 5 {
+		return nil, fmt.Errorf("Invalid cron expression %q: expected 5 fields", spec)
+	}
+
+	// "*/2" restricts the days as little as "*" does
+	c := &Cron{
+		domStar: strings.HasPrefix(fields[2], "*"),
+		dowStar: strings.HasPrefix(fields[4], "*"),
+	}
+
+	var err error
+
+	for i, field := range []struct {
+		value    *cronField
+		min, max int
+		names    []string
+		first    int
+	}{
+		{&c.minute, 0, 59, nil, 0},
+		{&c.hour, 0, 23, nil, 0},
+		{&c.dom, 1, 31, nil, 0},
+		{&c.month, 1, 12, monthNames, 1},
+		{&c.dow, 0, 7, dayNames, 0},
+	} {
+		if *field.value, err = parseCronField(fields[i], field.min, field.max, field.names, field.first); err != nil {
+			return nil, fmt.Errorf("Invalid cron expression %q: %s", spec, err.Error())
+		}
+	}
+
+	// Sunday is 0 or 7
+	if c.dow.has(7) {
+		c.dow |= 1
+	}
+
+	return c, nil
+}
+
+func parseCronField(field string, min, max int, names []string, first int) (cronField, error) {
+	var set cronField
+
+	value := func(s string) (int, error) {
+		for i, name := range names {
+			if strings.ToLower(s) == name {
+				return first + i, nil
+			}
+		}
+
+		n, err := strconv.Atoi(s)
+
+		if err != nil || n < min || n > max {
+			return 0, fmt.Errorf("%q is not a value between %d and %d", s, min, max)
+		}
+
+		return n, nil
+	}
+
+	for _, item := range strings.Split(field, ",") {
+		rangeSpec, step := item, 1
+
+		if i := strings.Index(item, "/"); i >= 0 {
+			n, err := strconv.Atoi(item[i+1:])
+
+			if err != nil || n < 1 {
+				return 0, fmt.Errorf("Invalid step in %q", item)
+			}
+
+			rangeSpec, step = item[:i], n
+		}
+
+		low, high := min, max
+
+		switch i := strings.Index(rangeSpec, "-"); {
+		case rangeSpec == "*":
+		case i >= 0:
+			var err error
+
+			if low, err = value(rangeSpec[:i]); err != nil {
+				return 0, err
+			}
+
+			if high, err = value(rangeSpec[i+1:]); err != nil {
+				return 0, err
+			}
+
+			if low > high {
+				return 0, fmt.Errorf("Invalid range %q", rangeSpec)
+			}
+		default:
+			var err error
+
+			if low, err = value(rangeSpec); err != nil {
+				return 0, err
+			}
+
+			// "5/10" is "5-max/10"
+			if step == 1 {
+				high = low
+			}
+		}
+
+		for n := low; n <= high; n += step {
+			set |= 1 << uint(n)
+		}
+	}
+
+	return set, nil
+}
+
+// matchDay returns true if the day of t matches the day of month and the
+// day of week
+func (c *Cron) matchDay(t time.Time) bool {
+	dom, dow := c.dom.has(t.Day()), c.dow.has(int(t.Weekday()))
+
+	if !c.domStar && !c.dowStar {
+		return dom || dow
+	}
+
+	return dom && dow
+}
+
+// Next returns the first activation strictly after t, in the location of
+// t, or the zero time if there is none within 5 years (e.g. "0 0 30 2 *")
+func (c *Cron) Next(t time.Time) time.Time {
+	loc := t.Location()
+	t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+	limit := t.AddDate(5, 0, 0)
+
+	for t.Before(limit) {
+		switch {
+		case !c.month.has(int(t.Month())):
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, loc)
+		case !c.matchDay(t):
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, loc)
+		case !c.hour.has(t.Hour()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, loc)
+		case !c.minute.has(t.Minute()):
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute()+1, 0, 0, loc)
+		default:
+			return t
+		}
+	}
+
+	return time.Time{}
+}
+
+// Latest returns the last activation after `after` up to now, or the zero
+// time if there is none: runs missed while no scheduler was running are
+// only run once
+func (c *Cron) Latest(after, now time.Time) time.Time {
+	var latest time.Time
+
+	for t := c.Next(after); !t.IsZero() && !t.After(now); t = c.Next(t) {
+		latest = t
+	}
+
+	return latest
+}
@@ -0,0 +1,68 @@
+package benchdrilltasks
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCronNext(t *testing.T) {
+	// A Wednesday
+	from := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+
+	for spec, want := range map[string]string{
+		"* * * * *":          "2024-05-15 10:31",
+		"0 2 * * *":          "2024-05-16 02:00",
+		"*/20 * * * *":       "2024-05-15 10:40",
+		"15,45 9-17/4 * * *": "2024-05-15 13:15",
+		"0 0 1 * *":          "2024-06-01 00:00",
+		"0 8 * * mon-fri":    "2024-05-16 08:00",
+		"0 8 * * 0":          "2024-05-19 08:00",
+		"0 8 * * 7":          "2024-05-19 08:00",
+		"0 0 29 feb *":       "2028-02-29 00:00",
+		"@hourly":            "2024-05-15 11:00",
+		"@weekly":            "2024-05-19 00:00",
+		"0 0 20 * fri":       "2024-05-17 00:00", // day of month or day of week
+		"0 0 */2 * mon":      "2024-05-27 00:00", // both, as "*/2" starts with a star
+		"0 0 13 * */3":       "2024-07-13 00:00", // a Saturday
+		"30 10 15 5 *":       "2025-05-15 10:30",
+	} {
+		c, err := ParseCron(spec)
+
+		if err != nil {
+			t.Errorf("%s: %s", spec, err.Error())
+			continue
+		}
+
+		if next := c.Next(from).Format("2006-01-02 15:04"); next != want {
+			t.Errorf("%s: next run at %s, expected %s", spec, next, want)
+		}
+	}
+
+	c, _ := ParseCron("0 0 30 2 *")
+
+	if next := c.Next(from); !next.IsZero() {
+		t.Errorf("Expected no run on February 30, got %s", next)
+	}
+}
+
+func TestCronInvalid(t *testing.T) {
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* 24 * * *", "* * 0 * *", "5-1 * * * *", "*/0 * * * *", "* * * foo *"} {
+		if _, err := ParseCron(spec); err == nil {
+			t.Errorf("Expected %q to be refused", spec)
+		}
+	}
+}
+
+func TestCronLatest(t *testing.T) {
+	c, _ := ParseCron("0 * * * *")
+	from := time.Date(2024, 5, 15, 10, 30, 0, 0, time.UTC)
+
+	// Runs missed while no scheduler was running are only run once
+	if latest := c.Latest(from, from.Add(3*time.Hour)); !latest.Equal(time.Date(2024, 5, 15, 13, 0, 0, 0, time.UTC)) {
+		t.Errorf("Latest run at %s, expected 13:00", latest)
+	}
+
+	if latest := c.Latest(from, from.Add(20*time.Minute)); !latest.IsZero() {
+		t.Errorf("Expected no run due, got %s", latest)
+	}
+}
@@ -0,0 +1,204 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// DefaultDockerHost is the socket of the Docker engine, used unless
+// DOCKER_HOST is set
+const DefaultDockerHost = "unix:///var/run/docker.sock"
+
+// Version of the Docker Engine API requested, the first one scaling the
+// services of a Swarm (Docker 1.13)
+const dockerAPIVersion = "v1.25"
+
+// Timeout of the requests to the Docker engine
+const dockerTimeout = 30 * time.Second
+
+// DockerAPI is the part of the Docker Engine API which scales the services
+// of a Swarm, run on a manager node
+type DockerAPI interface {
+	// ServiceReplicas returns the replicas of a replicated service
+	ServiceReplicas(service string) (int, error)
+	// ScaleService sets the replicas of a replicated service
+	ScaleService(service string, replicas int) error
+}
+
+// dockerClient calls the Docker Engine API over its unix socket or TCP
+type dockerClient struct {
+	client *http.Client
+	// URL of the API, version included
+	base string
+}
+
+// NewDockerAPI creates the client of a Docker engine, given as in DOCKER_HOST
+// (unix:///var/run/docker.sock or tcp://host:2375); it defaults to
+// DOCKER_HOST, then to the local socket
+func NewDockerAPI(host string) (DockerAPI, error) {
+	if host == "" {
+		host = os.Getenv("DOCKER_HOST")
+	}
+
+	if host == "" {
+		host = DefaultDockerHost
+	}
+
+	u, err := url.Parse(host)
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid Docker host %q: %s", host, err.Error())
+	}
+
+	transport := &http.Transport{}
+	c := &dockerClient{client: &http.Client{Transport: transport, Timeout: dockerTimeout}}
+
+	switch u.Scheme {
+	case "unix":
+		socket := u.Path
+
+		transport.Dial = func(_, _ string) (net.Conn, error) {
+			return net.DialTimeout("unix", socket, dockerTimeout)
+		}
+
+		// The host is ignored, the socket is dialed instead
+		c.base = "http://docker/" + dockerAPIVersion
+	case "tcp", "http":
+		c.base = "http://" + u.Host + "/" + dockerAPIVersion
+	case "https":
+		c.base = "https://" + u.Host + "/" + dockerAPIVersion
+	default:
+		return nil, fmt.Errorf("Invalid Docker host %q: expected unix://, tcp://, http:// or https://", host)
+	}
+
+	return c, nil
+}
+
+// dockerService is a service as inspected, whose spec is kept whole to be
+// sent back when it is updated
+type dockerService struct {
+	ID      string `json:"ID"`
+	Version struct {
+		Index uint64 `json:"Index"`
+	} `json:"Version"`
+	Spec map[string]interface{} `json:"Spec"`
+}
+
+// replicated returns the replicated mode of the spec of the service, nil if
+// it is global
+func (s *dockerService) replicated() map[string]interface{} {
+	mode, _ := s.Spec["Mode"].(map[string]interface{})
+	replicated, _ := mode["Replicated"].(map[string]interface{})
+
+	return replicated
+}
+
+func (c *dockerClient) ServiceReplicas(service string) (int, error) {
+	s, err := c.inspect(service)
+
+	if err != nil {
+		return 0, err
+	}
+
+	replicated := s.replicated()
+
+	if replicated == nil {
+		return 0, fmt.Errorf("Service %s is not replicated", service)
+	}
+
+	replicas, _ := replicated["Replicas"].(float64)
+
+	return int(replicas), nil
+}
+
+func (c *dockerClient) ScaleService(service string, replicas int) error {
+	s, err := c.inspect(service)
+
+	if err != nil {
+		return err
+	}
+
+	replicated := s.replicated()
+
+	if replicated == nil {
+		return fmt.Errorf("Service %s is not replicated", service)
+	}
+
+	replicated["Replicas"] = replicas
+
+	body, err := json.Marshal(s.Spec)
+
+	if err != nil {
+		return err
+	}
+
+	// The version makes the update fail if the service was updated since it
+	// was inspected
+	target := fmt.Sprintf("/services/%s/update?version=%d", url.PathEscape(s.ID), s.Version.Index)
+
+	return c.do(http.MethodPost, target, body, nil)
+}
+
+func (c *dockerClient) inspect(service string) (*dockerService, error) {
+	s := new(dockerService)
+
+	if err := c.do(http.MethodGet, "/services/"+url.PathEscape(service), nil, s); err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// do sends a request to the API and decodes its response into v, if not nil.
+// Errors carry the message of the engine
+func (c *dockerClient) do(method, path string, body []byte, v interface{}) error {
+	req, err := http.NewRequest(method, c.base+path, bytes.NewReader(body))
+
+	if err != nil {
+		return err
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+
+	if err != nil {
+		return fmt.Errorf("Could not reach the Docker engine: %s", err.Error())
+	}
+
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode/100 != 2 {
+		var message struct {
+			Message string `json:"message"`
+		}
+
+		if json.Unmarshal(data, &message) != nil || message.Message == "" {
+			message.Message = strings.TrimSpace(string(data))
+		}
+
+		return fmt.Errorf("%s %s: %s %s", method, path, resp.Status, message.Message)
+	}
+
+	if v == nil {
+		return nil
+	}
+
+	return json.Unmarshal(data, v)
+}
@@ -0,0 +1,477 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Labels of the results exported, besides the labels of the group
+const (
+	ResultCommandLabel = "command"
+	ResultToolLabel    = "tool"
+	ResultWorkerLabel  = "worker"
+	ResultRunLabel     = "run"
+	ResultTaskLabel    = "task"
+)
+
+// Prefix of the names of the metrics exported to Prometheus
+const resultMetricPrefix = "benchdrill_"
+
+// Runs kept by the Pushgateway and in the OpenMetrics file, by default
+const defaultKeepRuns = 10
+
+// Timeout of the requests to the Pushgateway and InfluxDB
+const exportTimeout = 10 * time.Second
+
+var promNameRegexp = regexp.MustCompile(`[^a-zA-Z0-9_]`)
+
+// Result is the metrics parsed from the output of a task which succeeded,
+// with its labels
+type Result struct {
+	Metrics Metrics
+	Labels  map[string]string
+	Time    time.Time
+}
+
+// Exporter publishes the results of the tasks, e.g. to be graphed by Grafana
+type Exporter interface {
+	Export(result *Result) error
+}
+
+// ExporterConfig is an exporter of the list under "exporters" in the config
+// file
+type ExporterConfig struct {
+	// pushgateway, openmetrics or influxdb
+	Type string `yaml:"type"`
+	// Pushgateway, or write endpoint of InfluxDB, query included
+	URL string `yaml:"url"`
+	// File written instead of a URL for InfluxDB
+	Path string `yaml:"path"`
+	// Job of the metrics pushed to the Pushgateway (default: benchdrill)
+	Job string `yaml:"job"`
+	// Measurement of the InfluxDB points (default: benchdrill)
+	Measurement string `yaml:"measurement"`
+	// Token of InfluxDB 2, sent as the Authorization header
+	Token string `yaml:"token"`
+	// Runs kept by the Pushgateway and in the OpenMetrics file (default: 10)
+	KeepRuns int `yaml:"keep_runs"`
+}
+
+// NewExporter creates the exporter of a config
+func NewExporter(cnf *ExporterConfig) (Exporter, error) {
+	keep := cnf.KeepRuns
+
+	if keep < 0 {
+		return nil, fmt.Errorf("Exporter %s: invalid keep_runs %d", cnf.Type, keep)
+	} else if keep == 0 {
+		keep = defaultKeepRuns
+	}
+
+	switch cnf.Type {
+	case "pushgateway":
+		if cnf.URL == "" {
+			return nil, fmt.Errorf("Exporter %s: a url is required", cnf.Type)
+		}
+
+		job := cnf.Job
+
+		if job == "" {
+			job = "benchdrill"
+		}
+
+		return &pushgatewayExporter{url: strings.TrimSuffix(cnf.URL, "/"), job: job, runs: newRunSamples(keep)}, nil
+	case "openmetrics":
+		if cnf.Path == "" {
+			return nil, fmt.Errorf("Exporter %s: a path is required", cnf.Type)
+		}
+
+		return &openMetricsExporter{path: cnf.Path, runs: newRunSamples(keep)}, nil
+	case "influxdb":
+		if (cnf.URL == "") == (cnf.Path == "") {
+			return nil, fmt.Errorf("Exporter %s: either a url or a path is required", cnf.Type)
+		}
+
+		measurement := cnf.Measurement
+
+		if measurement == "" {
+			measurement = "benchdrill"
+		}
+
+		return &influxExporter{url: cnf.URL, path: cnf.Path, measurement: measurement, token: cnf.Token}, nil
+	}
+
+	return nil, fmt.Errorf("Unknown exporter type %q: expected pushgateway, openmetrics or influxdb", cnf.Type)
+}
+
+// NewResult builds the result of a command run by a task, nil if its output
+// has no metrics
+func NewResult(command, output string, labels map[string]string, at time.Time) *Result {
+	metrics := ParseMetrics(output)
+
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	result := &Result{
+		Metrics: metrics,
+		Labels:  make(map[string]string),
+		Time:    at,
+	}
+
+	for name, value := range labels {
+		result.Labels[name] = value
+	}
+
+	result.Labels[ResultCommandLabel] = strings.TrimSpace(command)
+
+	if fields := strings.Fields(command); len(fields) > 0 {
+		result.Labels[ResultToolLabel] = filepath.Base(fields[0])
+	}
+
+	return result
+}
+
+// promName turns a name into a valid name of a Prometheus metric or label
+func promName(name string) string {
+	name = promNameRegexp.ReplaceAllString(name, "_")
+
+	if name == "" || name[0] >= '0' && name[0] <= '9' {
+		name = "_" + name
+	}
+
+	return name
+}
+
+// promLabels formats the labels of a sample, sorted, except the excluded
+func promLabels(labels map[string]string, exclude ...string) string {
+	var pairs []string
+
+	for name, value := range labels {
+		if value == "" || contains(exclude, name) {
+			continue
+		}
+
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, promName(name), escapeLabel(value)))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	sort.Strings(pairs)
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// sortedMetrics returns the names of the metrics, sorted
+func sortedMetrics(metrics Metrics) []string {
+	var names []string
+
+	for name := range metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// runSamples keeps the samples of the latest runs exported, so that the
+// Pushgateway and the OpenMetrics file do not grow with each run
+type runSamples struct {
+	keep int
+	// Keys of the runs, least recently exported first
+	keys []string
+	// Samples, by run, then by metric, then by labels
+	samples map[string]map[string]map[string]string
+}
+
+func newRunSamples(keep int) *runSamples {
+	return &runSamples{keep: keep, samples: make(map[string]map[string]map[string]string)}
+}
+
+// add records the samples of a result under the key of its run, and returns
+// the keys of the runs forgotten to keep only the latest ones
+func (s *runSamples) add(key string, result *Result, labels string) []string {
+	for i, k := range s.keys {
+		if k == key {
+			s.keys = append(s.keys[:i], s.keys[i+1:]...)
+			break
+		}
+	}
+
+	s.keys = append(s.keys, key)
+
+	if s.samples[key] == nil {
+		s.samples[key] = make(map[string]map[string]string)
+	}
+
+	for _, name := range sortedMetrics(result.Metrics) {
+		metric := promName(resultMetricPrefix + name)
+
+		if s.samples[key][metric] == nil {
+			s.samples[key][metric] = make(map[string]string)
+		}
+
+		s.samples[key][metric][labels] = fmt.Sprintf("%s%s %s", metric, labels, formatValue(result.Metrics[name]))
+	}
+
+	var forgotten []string
+
+	for len(s.keys) > s.keep {
+		forgotten = append(forgotten, s.keys[0])
+		delete(s.samples, s.keys[0])
+		s.keys = s.keys[1:]
+	}
+
+	return forgotten
+}
+
+// write writes the samples of runs in the text format, a family per metric
+func (s *runSamples) write(w io.Writer, keys ...string) {
+	families := make(map[string]map[string]string)
+
+	for _, key := range keys {
+		for metric, samples := range s.samples[key] {
+			if families[metric] == nil {
+				families[metric] = make(map[string]string)
+			}
+
+			for labels, sample := range samples {
+				families[metric][labels] = sample
+			}
+		}
+	}
+
+	var metrics []string
+
+	for metric := range families {
+		metrics = append(metrics, metric)
+	}
+
+	sort.Strings(metrics)
+
+	for _, metric := range metrics {
+		fmt.Fprintf(w, "# TYPE %s gauge\n", metric)
+
+		for _, labels := range sortedKeys(families[metric]) {
+			fmt.Fprintln(w, families[metric][labels])
+		}
+	}
+}
+
+// pushgatewayExporter pushes the metrics of the tasks to a Prometheus
+// Pushgateway, in a group per run and worker whose samples are labelled by
+// task. A push replaces the whole group, so the samples of the run are
+// pushed again with each task, and the groups of the older runs are deleted
+type pushgatewayExporter struct {
+	url, job string
+	runs     *runSamples
+	mu       sync.Mutex
+}
+
+// group returns the URL of the group of a run and worker
+func (e *pushgatewayExporter) group(labels map[string]string) string {
+	target := fmt.Sprintf("%s/metrics/job/%s", e.url, url.PathEscape(e.job))
+
+	for _, name := range []string{ResultRunLabel, ResultWorkerLabel} {
+		if value := labels[name]; value != "" {
+			target += fmt.Sprintf("/%s/%s", name, url.PathEscape(value))
+		}
+	}
+
+	return target
+}
+
+func (e *pushgatewayExporter) Export(result *Result) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// The labels of the grouping key are added by the Pushgateway
+	target := e.group(result.Labels)
+	forgotten := e.runs.add(target, result, promLabels(result.Labels, ResultRunLabel, ResultWorkerLabel))
+
+	var body bytes.Buffer
+
+	e.runs.write(&body, target)
+
+	if err := send(http.MethodPut, target, "text/plain; version=0.0.4", "", &body); err != nil {
+		return err
+	}
+
+	for _, group := range forgotten {
+		if err := send(http.MethodDelete, group, "", "", nil); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// openMetricsExporter writes the metrics of the latest runs exported by the
+// worker to an OpenMetrics file, e.g. for the textfile collector of the node
+// exporter, without timestamps which the collector refuses
+type openMetricsExporter struct {
+	path string
+	runs *runSamples
+	mu   sync.Mutex
+}
+
+func (e *openMetricsExporter) Export(result *Result) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.runs.add(result.Labels[ResultRunLabel], result, promLabels(result.Labels))
+
+	var body bytes.Buffer
+
+	e.runs.write(&body, e.runs.keys...)
+	body.WriteString("# EOF\n")
+
+	// Renamed so that readers never see a partial file
+	tmp := e.path + ".tmp"
+
+	if err := ioutil.WriteFile(tmp, body.Bytes(), 0644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, e.path)
+}
+
+func sortedKeys(m map[string]string) []string {
+	var keys []string
+
+	for key := range m {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	return keys
+}
+
+// influxExporter writes a point of InfluxDB line protocol per task, whose
+// tags are the labels and fields the metrics, to a write endpoint or
+// appended to a file
+type influxExporter struct {
+	url, path, measurement, token string
+	mu                            sync.Mutex
+}
+
+var (
+	influxMeasurementEscaper = strings.NewReplacer(",", `\,`, " ", `\ `)
+	influxKeyEscaper         = strings.NewReplacer(",", `\,`, "=", `\=`, " ", `\ `)
+)
+
+func (e *influxExporter) Export(result *Result) error {
+	line := influxMeasurementEscaper.Replace(e.measurement)
+
+	for _, name := range sortedKeys(result.Labels) {
+		// Tags cannot be empty, nor end with a backslash
+		value := strings.TrimRight(result.Labels[name], `\`)
+
+		if value != "" {
+			line += "," + influxKeyEscaper.Replace(name) + "=" + influxKeyEscaper.Replace(value)
+		}
+	}
+
+	var fields []string
+
+	for _, name := range sortedMetrics(result.Metrics) {
+		fields = append(fields, influxKeyEscaper.Replace(name)+"="+formatValue(result.Metrics[name]))
+	}
+
+	line += " " + strings.Join(fields, ",") + fmt.Sprintf(" %d\n", result.Time.UnixNano())
+
+	if e.url != "" {
+		authorization := ""
+
+		if e.token != "" {
+			authorization = "Token " + e.token
+		}
+
+		return send(http.MethodPost, e.url, "text/plain; charset=utf-8", authorization, strings.NewReader(line))
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.WriteString(line); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
+
+// send sends a request to a Pushgateway or InfluxDB, an error is returned
+// unless its status is 2xx
+func send(method, target, contentType, authorization string, body io.Reader) error {
+	req, err := http.NewRequest(method, target, body)
+
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", contentType)
+
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	client := &http.Client{Timeout: exportTimeout}
+	resp, err := client.Do(req)
+
+	if err != nil {
+		return err
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		message, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("%s %s: %s %s", method, maskUserinfo(target), resp.Status, strings.TrimSpace(string(message)))
+	}
+
+	return nil
+}
+
+// maskUserinfo hides the credentials of a URL in errors
+func maskUserinfo(target string) string {
+	u, err := url.Parse(target)
+
+	if err != nil || u.User == nil {
+		return target
+	}
+
+	u.User = url.User("*****")
+
+	return u.String()
+}
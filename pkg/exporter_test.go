@@ -0,0 +1,210 @@
+package benchdrilltasks
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSysbenchOutput = `CPU speed:
+    events per second:  1234.56
+
+General statistics:
+    total time:                          10.0008s
+`
+
+func testResult() *Result {
+	return NewResult("sysbench cpu run", testSysbenchOutput, map[string]string{
+		ResultWorkerLabel: "w1",
+		ResultRunLabel:    "group_1",
+		ResultTaskLabel:   "task_1",
+		"team":            `st"orage`,
+	}, time.Unix(1500000000, 0))
+}
+
+// request records the requests of a stand-in for the Pushgateway or InfluxDB
+type request struct {
+	method, path, query, authorization, body string
+}
+
+func standIn(t *testing.T) (*httptest.Server, <-chan request) {
+	requests := make(chan request, 10)
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		requests <- request{r.Method, r.URL.Path, r.URL.RawQuery, r.Header.Get("Authorization"), string(body)}
+		w.WriteHeader(http.StatusNoContent)
+	})), requests
+}
+
+func TestNewResult(t *testing.T) {
+	result := testResult()
+
+	if result.Labels[ResultToolLabel] != "sysbench" || result.Labels[ResultCommandLabel] != "sysbench cpu run" {
+		t.Errorf("Labels %v, expected the tool and the command", result.Labels)
+	}
+
+	if result.Metrics["cpu_speed.events_per_second"] != 1234.56 {
+		t.Errorf("Metrics %v, expected the events per second", result.Metrics)
+	}
+
+	if NewResult("true", "nothing to see", nil, time.Now()) != nil {
+		t.Error("Expected no result for an output without metrics")
+	}
+}
+
+func TestPushgatewayExporter(t *testing.T) {
+	ts, requests := standIn(t)
+	defer ts.Close()
+
+	exporter, err := NewExporter(&ExporterConfig{Type: "pushgateway", URL: ts.URL + "/", KeepRuns: 1})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other := testResult()
+	other.Labels[ResultTaskLabel] = "task_2"
+	other.Metrics["cpu_speed.events_per_second"] = 1000
+
+	for _, result := range []*Result{testResult(), other} {
+		if err := exporter.Export(result); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	<-requests
+	r := <-requests
+
+	if r.method != http.MethodPut || r.path != "/metrics/job/benchdrill/run/group_1/worker/w1" {
+		t.Errorf("Pushed with %s %s, expected to the group of the run and the worker", r.method, r.path)
+	}
+
+	// The group is replaced with the samples of both tasks of the run
+	want := `# TYPE benchdrill_cpu_speed_events_per_second gauge
+benchdrill_cpu_speed_events_per_second{command="sysbench cpu run",task="task_1",team="st\"orage",tool="sysbench",worker="w1"} 1234.56
+benchdrill_cpu_speed_events_per_second{command="sysbench cpu run",task="task_2",team="st\"orage",tool="sysbench",worker="w1"} 1000
+`
+
+	if !strings.HasPrefix(r.body, strings.Replace(want, `,worker="w1"`, "", -1)) {
+		t.Errorf("Pushed:\n%s\nexpected:\n%s", r.body, want)
+	}
+
+	// The group of the previous run is deleted beyond keep_runs
+	next := testResult()
+	next.Labels[ResultRunLabel] = "group_2"
+
+	if err := exporter.Export(next); err != nil {
+		t.Fatal(err)
+	}
+
+	if r = <-requests; r.method != http.MethodPut || r.path != "/metrics/job/benchdrill/run/group_2/worker/w1" || strings.Contains(r.body, "task_2") {
+		t.Errorf("Pushed with %s %s, expected the group of the new run alone:\n%s", r.method, r.path, r.body)
+	}
+
+	if r = <-requests; r.method != http.MethodDelete || r.path != "/metrics/job/benchdrill/run/group_1/worker/w1" {
+		t.Errorf("Sent %s %s, expected to delete the group of the previous run", r.method, r.path)
+	}
+}
+
+func TestOpenMetricsExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "results.prom")
+	exporter, err := NewExporter(&ExporterConfig{Type: "openmetrics", Path: path, KeepRuns: 2})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := []*Result{testResult(), testResult(), testResult(), testResult()}
+	results[1].Labels[ResultTaskLabel] = "task_2"
+	results[1].Metrics["cpu_speed.events_per_second"] = 1000
+
+	for i, run := range []string{"group_1", "group_1", "group_2", "group_3"} {
+		results[i].Labels[ResultRunLabel] = run
+
+		if err := exporter.Export(results[i]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the 2 latest runs are kept
+	want := `# TYPE benchdrill_cpu_speed_events_per_second gauge
+benchdrill_cpu_speed_events_per_second{command="sysbench cpu run",run="group_2",task="task_1",team="st\"orage",tool="sysbench",worker="w1"} 1234.56
+benchdrill_cpu_speed_events_per_second{command="sysbench cpu run",run="group_3",task="task_1",team="st\"orage",tool="sysbench",worker="w1"} 1234.56
+# TYPE benchdrill_general_statistics_total_time gauge
+`
+
+	if !strings.HasPrefix(string(content), want) || !strings.HasSuffix(string(content), "# EOF\n") {
+		t.Errorf("Wrote:\n%s\nexpected:\n%s…\n# EOF", content, want)
+	}
+}
+
+func TestInfluxExporter(t *testing.T) {
+	ts, requests := standIn(t)
+	defer ts.Close()
+
+	exporter, err := NewExporter(&ExporterConfig{Type: "influxdb", URL: ts.URL + "/api/v2/write?bucket=bench", Token: "secret"})
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := exporter.Export(testResult()); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+	want := `benchdrill,command=sysbench\ cpu\ run,run=group_1,task=task_1,team=st"orage,tool=sysbench,worker=w1 cpu_speed.events_per_second=1234.56,general_statistics.total_time=10.0008 1500000000000000000` + "\n"
+
+	if r.method != http.MethodPost || r.query != "bucket=bench" || r.authorization != "Token secret" {
+		t.Errorf("Sent with %s ?%s (%s), expected the query and the token", r.method, r.query, r.authorization)
+	}
+
+	if r.body != want {
+		t.Errorf("Sent:\n%s\nexpected:\n%s", r.body, want)
+	}
+}
+
+func TestExporterErrors(t *testing.T) {
+	for _, cnf := range []*ExporterConfig{
+		{Type: "graphite", URL: "http://localhost"},
+		{Type: "pushgateway"},
+		{Type: "openmetrics"},
+		{Type: "openmetrics", Path: "/tmp/results.prom", KeepRuns: -1},
+		{Type: "influxdb", URL: "http://localhost", Path: "/tmp/points"},
+	} {
+		if _, err := NewExporter(cnf); err == nil {
+			t.Errorf("Expected an error for %+v", cnf)
+		}
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad metrics", http.StatusBadRequest)
+	}))
+	defer ts.Close()
+
+	exporter, _ := NewExporter(&ExporterConfig{Type: "pushgateway", URL: ts.URL})
+
+	if err := exporter.Export(testResult()); err == nil || !strings.Contains(err.Error(), "bad metrics") {
+		t.Errorf("Error %v, expected the error of the Pushgateway", err)
+	}
+}
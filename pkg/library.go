@@ -0,0 +1,165 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// Source of the workloads shipped with Benchdrill
+const BuiltinSource = "built-in"
+
+// LibraryWorkload is a named workload of the library. Its command and its
+// workload file, if any, are templates whose variables are documented
+type LibraryWorkload struct {
+	Name        string `yaml:"name"`
+	Description string `yaml:"description"`
+	// Command run by the workers, on the workload file if any
+	Command string `yaml:"command"`
+	File    string `yaml:"file,omitempty"`
+	// Whether the command is run as a prepare/run/cleanup pipeline
+	Pipeline bool           `yaml:"pipeline,omitempty"`
+	Vars     []*WorkloadVar `yaml:"vars"`
+	// Built-in, or path of the file it was loaded from
+	Source string `yaml:"-"`
+}
+
+// WorkloadVar documents a variable of a library workload, a variable without
+// default must be set
+type WorkloadVar struct {
+	Name        string `yaml:"name"`
+	Default     string `yaml:"default,omitempty"`
+	Description string `yaml:"description"`
+}
+
+// Tool returns the benchmark tool run by the workload
+func (w *LibraryWorkload) Tool() string {
+	fields := strings.Fields(w.Command)
+
+	if len(fields) == 0 {
+		return ""
+	}
+
+	return fields[0]
+}
+
+// Values returns the values of the variables of the workload: the values set,
+// or the defaults. Setting a variable the workload does not document is an
+// error
+func (w *LibraryWorkload) Values(set map[string]string) (map[string]string, error) {
+	values := make(map[string]string)
+	documented := make(map[string]bool)
+
+	for _, v := range w.Vars {
+		documented[v.Name] = true
+
+		if v.Default != "" {
+			values[v.Name] = v.Default
+		}
+	}
+
+	for name, value := range set {
+		if !documented[name] {
+			return nil, fmt.Errorf("Workload %s has no variable %s", w.Name, name)
+		}
+
+		values[name] = value
+	}
+
+	return values, nil
+}
+
+// LoadLibrary returns the built-in workloads and the workloads defined in a
+// directory (if not empty) by YAML files, sorted by name. A workload of the
+// directory replaces the built-in workload of the same name
+func LoadLibrary(dir string) ([]*LibraryWorkload, error) {
+	workloads := make(map[string]*LibraryWorkload)
+
+	for _, w := range builtinWorkloads {
+		w.Source = BuiltinSource
+		workloads[w.Name] = w
+	}
+
+	if dir != "" {
+		files, err := ioutil.ReadDir(dir)
+
+		if err != nil {
+			return nil, fmt.Errorf("Could not read workload library: %s", err.Error())
+		}
+
+		for _, file := range files {
+			ext := filepath.Ext(file.Name())
+
+			if file.IsDir() || (ext != ".yml" && ext != ".yaml") {
+				continue
+			}
+
+			w, err := loadWorkload(filepath.Join(dir, file.Name()))
+
+			if err != nil {
+				return nil, err
+			}
+
+			workloads[w.Name] = w
+		}
+	}
+
+	var names []string
+
+	for name := range workloads {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	library := make([]*LibraryWorkload, len(names))
+
+	for i, name := range names {
+		library[i] = workloads[name]
+	}
+
+	return library, nil
+}
+
+// loadWorkload reads a workload from a YAML file, named after the file unless
+// it has a name
+func loadWorkload(path string) (*LibraryWorkload, error) {
+	data, err := ioutil.ReadFile(path)
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read workload: %s", err.Error())
+	}
+
+	w := new(LibraryWorkload)
+
+	if err := yaml.Unmarshal(data, w); err != nil {
+		return nil, fmt.Errorf("Could not parse workload %s: %s", path, err.Error())
+	}
+
+	if w.Name == "" {
+		w.Name = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+
+	if w.Command == "" {
+		return nil, fmt.Errorf("Workload %s has no command", path)
+	}
+
+	w.Source = path
+
+	return w, nil
+}
+
+// FindWorkload returns the workload of a library with the given name
+func FindWorkload(library []*LibraryWorkload, name string) (*LibraryWorkload, error) {
+	for _, w := range library {
+		if w.Name == name {
+			return w, nil
+		}
+	}
+
+	return nil, fmt.Errorf("No workload named %s in the library, see workload list", name)
+}
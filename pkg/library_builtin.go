@@ -0,0 +1,352 @@
+package benchdrilltasks
+
+// Workloads shipped with Benchdrill: the standard Filebench personalities,
+// whose settings are variables of the library, and common Sysbench tests
+var builtinWorkloads = []*LibraryWorkload{
+	{
+		Name:        "fileserver",
+		Description: "Filebench file server: creates, writes, appends, reads, deletes and stats whole files",
+		Command:     "filebench -f",
+		File:        fileserverWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the fileset"},
+			{Name: "nfiles", Default: "10000", Description: "Number of files"},
+			{Name: "meandirwidth", Default: "20", Description: "Mean number of files per directory"},
+			{Name: "meanfilesize", Default: "131072", Description: "Mean file size, in bytes"},
+			{Name: "nthreads", Default: "50", Description: "Number of threads"},
+			{Name: "iosize", Default: "1m", Description: "Size of the reads and writes of whole files"},
+			{Name: "meanappendsize", Default: "16k", Description: "Mean size of the appends"},
+			{Name: "runtime", Default: "60", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "webserver",
+		Description: "Filebench web server: reads whole files and appends to a shared log",
+		Command:     "filebench -f",
+		File:        webserverWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the filesets"},
+			{Name: "nfiles", Default: "1000", Description: "Number of files"},
+			{Name: "meandirwidth", Default: "20", Description: "Mean number of files per directory"},
+			{Name: "meanfilesize", Default: "16384", Description: "Mean file size, in bytes"},
+			{Name: "nthreads", Default: "100", Description: "Number of threads"},
+			{Name: "iosize", Default: "1m", Description: "Size of the reads of whole files"},
+			{Name: "meanappendsize", Default: "16k", Description: "Mean size of the appends to the log"},
+			{Name: "runtime", Default: "60", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "varmail",
+		Description: "Filebench mail server: creates, appends with fsync, reads and deletes small files in a flat directory",
+		Command:     "filebench -f",
+		File:        varmailWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the fileset"},
+			{Name: "nfiles", Default: "1000", Description: "Number of files"},
+			{Name: "meandirwidth", Default: "1000000", Description: "Mean number of files per directory"},
+			{Name: "meanfilesize", Default: "16384", Description: "Mean file size, in bytes"},
+			{Name: "nthreads", Default: "16", Description: "Number of threads"},
+			{Name: "iosize", Default: "1m", Description: "Size of the reads of whole files"},
+			{Name: "meanappendsize", Default: "16k", Description: "Mean size of the appends"},
+			{Name: "runtime", Default: "60", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "oltp",
+		Description: "Filebench database: random reads by shadow processes, asynchronous writes by database writers and a log writer",
+		Command:     "filebench -f",
+		File:        oltpWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the data and log files"},
+			{Name: "nfiles", Default: "10", Description: "Number of data files"},
+			{Name: "filesize", Default: "10m", Description: "Size of each data file"},
+			{Name: "logfilesize", Default: "10m", Description: "Size of the log file"},
+			{Name: "iosize", Default: "2k", Description: "Size of the reads and writes of the data files"},
+			{Name: "nshadows", Default: "200", Description: "Number of shadow (reader) processes"},
+			{Name: "ndbwriters", Default: "10", Description: "Number of database writer processes"},
+			{Name: "directio", Default: "0", Description: "Whether to bypass the page cache (0 or 1)"},
+			{Name: "runtime", Default: "60", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "videoserver",
+		Description: "Filebench video server: streams active videos at a limited rate while new videos replace passive ones",
+		Command:     "filebench -f",
+		File:        videoserverWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the filesets"},
+			{Name: "filesize", Default: "10g", Description: "Size of each video"},
+			{Name: "numactivevids", Default: "32", Description: "Number of videos being streamed"},
+			{Name: "numpassivevids", Default: "194", Description: "Number of videos being replaced"},
+			{Name: "nthreads", Default: "48", Description: "Number of reader threads"},
+			{Name: "eventrate", Default: "96", Description: "Reads per second, over all the reader threads"},
+			{Name: "runtime", Default: "60", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "readfiles",
+		Description: "Filebench reads of whole small files, as readfiles.f",
+		Command:     "filebench -f",
+		File:        readfilesWorkload,
+		Vars: []*WorkloadVar{
+			{Name: "dir", Default: "/tmp", Description: "Directory of the fileset"},
+			{Name: "nfiles", Default: "10000", Description: "Number of files"},
+			{Name: "filesize", Default: "16k", Description: "Size of each file"},
+			{Name: "nprocesses", Default: "2", Description: "Number of processes"},
+			{Name: "nthreads", Default: "3", Description: "Number of threads per process"},
+			{Name: "runtime", Default: "5", Description: "Duration of the run, in seconds"},
+		},
+	},
+	{
+		Name:        "sysbench-cpu",
+		Description: "Sysbench CPU test: computes prime numbers",
+		Command:     "sysbench --threads={{threads}} --time={{time}} cpu --cpu-max-prime={{max_prime}} run",
+		Vars: []*WorkloadVar{
+			{Name: "threads", Default: "1", Description: "Number of threads"},
+			{Name: "time", Default: "10", Description: "Duration of the run, in seconds"},
+			{Name: "max_prime", Default: "10000", Description: "Upper limit of the prime numbers"},
+		},
+	},
+	{
+		Name:        "sysbench-memory",
+		Description: "Sysbench memory test: reads or writes blocks of memory",
+		Command:     "sysbench --threads={{threads}} --time={{time}} memory --memory-block-size={{block_size}} --memory-total-size={{total_size}} --memory-oper={{oper}} run",
+		Vars: []*WorkloadVar{
+			{Name: "threads", Default: "1", Description: "Number of threads"},
+			{Name: "time", Default: "10", Description: "Duration of the run, in seconds"},
+			{Name: "block_size", Default: "1K", Description: "Size of the blocks"},
+			{Name: "total_size", Default: "100G", Description: "Total size to transfer"},
+			{Name: "oper", Default: "write", Description: "Operation: read, write or none"},
+		},
+	},
+	{
+		Name:        "sysbench-fileio",
+		Description: "Sysbench file I/O test, run as a pipeline which prepares then removes the test files",
+		Command:     "sysbench --threads={{threads}} --time={{time}} fileio --file-total-size={{total_size}} --file-test-mode={{mode}}",
+		Pipeline:    true,
+		Vars: []*WorkloadVar{
+			{Name: "threads", Default: "1", Description: "Number of threads"},
+			{Name: "time", Default: "60", Description: "Duration of the run, in seconds"},
+			{Name: "total_size", Default: "2G", Description: "Total size of the test files"},
+			{Name: "mode", Default: "rndrw", Description: "Test mode: seqwr, seqrewr, seqrd, rndrd, rndwr or rndrw"},
+		},
+	},
+}
+
+const fileserverWorkload = `set $dir={{dir}}
+set $nfiles={{nfiles}}
+set $meandirwidth={{meandirwidth}}
+set $filesize=cvar(type=cvar-gamma,parameters=mean:{{meanfilesize}};gamma:1.5)
+set $nthreads={{nthreads}}
+set $iosize={{iosize}}
+set $meanappendsize={{meanappendsize}}
+
+define fileset name=bigfileset,path=$dir,size=$filesize,entries=$nfiles,dirwidth=$meandirwidth,prealloc=80
+
+define process name=filereader,instances=1
+{
+  thread name=filereaderthread,memsize=10m,instances=$nthreads
+  {
+    flowop createfile name=createfile1,filesetname=bigfileset,fd=1
+    flowop writewholefile name=wrtfile1,srcfd=1,fd=1,iosize=$iosize
+    flowop closefile name=closefile1,fd=1
+    flowop openfile name=openfile1,filesetname=bigfileset,fd=1
+    flowop appendfilerand name=appendfilerand1,iosize=$meanappendsize,fd=1
+    flowop closefile name=closefile2,fd=1
+    flowop openfile name=openfile2,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile1,fd=1,iosize=$iosize
+    flowop closefile name=closefile3,fd=1
+    flowop deletefile name=deletefile1,filesetname=bigfileset
+    flowop statfile name=statfile1,filesetname=bigfileset
+  }
+}
+
+run {{runtime}}
+`
+
+const webserverWorkload = `set $dir={{dir}}
+set $nfiles={{nfiles}}
+set $meandirwidth={{meandirwidth}}
+set $filesize=cvar(type=cvar-gamma,parameters=mean:{{meanfilesize}};gamma:1.5)
+set $nthreads={{nthreads}}
+set $iosize={{iosize}}
+set $meanappendsize={{meanappendsize}}
+
+define fileset name=bigfileset,path=$dir,size=$filesize,entries=$nfiles,dirwidth=$meandirwidth,prealloc=100,readonly
+define fileset name=logfiles,path=$dir,size=$filesize,entries=1,dirwidth=$meandirwidth,prealloc
+
+define process name=filereader,instances=1
+{
+  thread name=filereaderthread,memsize=10m,instances=$nthreads
+  {
+    flowop openfile name=openfile1,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile1,fd=1,iosize=$iosize
+    flowop closefile name=closefile1,fd=1
+    flowop openfile name=openfile2,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile2,fd=1,iosize=$iosize
+    flowop closefile name=closefile2,fd=1
+    flowop openfile name=openfile3,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile3,fd=1,iosize=$iosize
+    flowop closefile name=closefile3,fd=1
+    flowop openfile name=openfile4,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile4,fd=1,iosize=$iosize
+    flowop closefile name=closefile4,fd=1
+    flowop openfile name=openfile5,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile5,fd=1,iosize=$iosize
+    flowop closefile name=closefile5,fd=1
+    flowop openfile name=openfile6,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile6,fd=1,iosize=$iosize
+    flowop closefile name=closefile6,fd=1
+    flowop openfile name=openfile7,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile7,fd=1,iosize=$iosize
+    flowop closefile name=closefile7,fd=1
+    flowop openfile name=openfile8,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile8,fd=1,iosize=$iosize
+    flowop closefile name=closefile8,fd=1
+    flowop openfile name=openfile9,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile9,fd=1,iosize=$iosize
+    flowop closefile name=closefile9,fd=1
+    flowop openfile name=openfile10,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile10,fd=1,iosize=$iosize
+    flowop closefile name=closefile10,fd=1
+    flowop appendfilerand name=appendlog,filesetname=logfiles,iosize=$meanappendsize,fd=2
+  }
+}
+
+run {{runtime}}
+`
+
+const varmailWorkload = `set $dir={{dir}}
+set $nfiles={{nfiles}}
+set $meandirwidth={{meandirwidth}}
+set $filesize=cvar(type=cvar-gamma,parameters=mean:{{meanfilesize}};gamma:1.5)
+set $nthreads={{nthreads}}
+set $iosize={{iosize}}
+set $meanappendsize={{meanappendsize}}
+
+define fileset name=bigfileset,path=$dir,size=$filesize,entries=$nfiles,dirwidth=$meandirwidth,prealloc=80
+
+define process name=filereader,instances=1
+{
+  thread name=filereaderthread,memsize=10m,instances=$nthreads
+  {
+    flowop deletefile name=deletefile1,filesetname=bigfileset
+    flowop createfile name=createfile2,filesetname=bigfileset,fd=1
+    flowop appendfilerand name=appendfilerand2,iosize=$meanappendsize,fd=1
+    flowop fsync name=fsyncfile2,fd=1
+    flowop closefile name=closefile2,fd=1
+    flowop openfile name=openfile3,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile3,fd=1,iosize=$iosize
+    flowop appendfilerand name=appendfilerand3,iosize=$meanappendsize,fd=1
+    flowop fsync name=fsyncfile3,fd=1
+    flowop closefile name=closefile3,fd=1
+    flowop openfile name=openfile4,filesetname=bigfileset,fd=1
+    flowop readwholefile name=readfile4,fd=1,iosize=$iosize
+    flowop closefile name=closefile4,fd=1
+  }
+}
+
+run {{runtime}}
+`
+
+const oltpWorkload = `set $dir={{dir}}
+set $nfiles={{nfiles}}
+set $filesize={{filesize}}
+set $logfilesize={{logfilesize}}
+set $iosize={{iosize}}
+set $nshadows={{nshadows}}
+set $ndbwriters={{ndbwriters}}
+set $directio={{directio}}
+set $usermode=200000
+set $memperthread=1m
+set $workingset=0
+
+define fileset name=datafiles,path=$dir,size=$filesize,entries=$nfiles,dirwidth=1024,prealloc=100,reuse
+define fileset name=logfile,path=$dir,size=$logfilesize,entries=1,dirwidth=1024,prealloc=100,reuse
+
+define process name=lgwr,instances=1
+{
+  thread name=lgwr,memsize=$memperthread
+  {
+    flowop aiowrite name=lg-write,filesetname=logfile,iosize=256k,random,directio=$directio,dsync
+    flowop aiowait name=lg-aiowait
+    flowop semblock name=lg-block,value=3200,highwater=1000
+  }
+}
+
+define process name=dbwr,instances=$ndbwriters
+{
+  thread name=dbwr,memsize=$memperthread
+  {
+    flowop aiowrite name=dbwrite-a,filesetname=datafiles,iosize=$iosize,workingset=$workingset,random,iters=100,opennext,directio=$directio,dsync
+    flowop hog name=dbwr-hog,value=10000
+    flowop semblock name=dbwr-block,value=1000,highwater=2000
+    flowop aiowait name=dbwr-aiowait
+  }
+}
+
+define process name=shadow,instances=$nshadows
+{
+  thread name=shadow,memsize=$memperthread
+  {
+    flowop read name=shadowread,filesetname=datafiles,iosize=$iosize,workingset=$workingset,random,opennext,directio=$directio
+    flowop hog name=shadowhog,value=$usermode
+    flowop sempost name=shadow-post-lg,value=1,target=lg-block,blocking
+    flowop sempost name=shadow-post-dbwr,value=1,target=dbwr-block,blocking
+  }
+}
+
+run {{runtime}}
+`
+
+const videoserverWorkload = `set $dir={{dir}}
+set $filesize={{filesize}}
+set $numactivevids={{numactivevids}}
+set $numpassivevids={{numpassivevids}}
+set $nthreads={{nthreads}}
+set $eventrate={{eventrate}}
+set $readiosize=256k
+set $writeiosize=1m
+set $repintval=10
+
+eventgen rate=$eventrate
+
+define fileset name=activevids,path=$dir,size=$filesize,entries=$numactivevids,dirwidth=4,prealloc,paralloc
+define fileset name=passivevids,path=$dir,size=$filesize,entries=$numpassivevids,dirwidth=20,prealloc=50,paralloc
+
+define process name=vidwriter,instances=1
+{
+  thread name=vidwriter,memsize=10m,instances=1
+  {
+    flowop deletefile name=vidremover,filesetname=passivevids
+    flowop createfile name=wrtopen,filesetname=passivevids,fd=1
+    flowop writewholefile name=newvid,iosize=$writeiosize,fd=1,srcfd=1
+    flowop closefile name=wrtclose,fd=1
+    flowop delay name=replaceinterval,value=$repintval
+  }
+}
+
+define process name=vidreaders,instances=1
+{
+  thread name=vidreaders,memsize=10m,instances=$nthreads
+  {
+    flowop read name=vidreader,filesetname=activevids,iosize=$readiosize
+    flowop bwlimit name=serverlimit,target=vidreader
+  }
+}
+
+run {{runtime}}
+`
+
+const readfilesWorkload = `define fileset name = "testF", entries = {{nfiles}}, filesize = {{filesize}}, prealloc, path = "{{dir}}"
+
+define process name = "readerP", instances = {{nprocesses}} {
+	thread name = "readerT", instances = {{nthreads}} {
+		flowop openfile name = "openOP", filesetname = "testF"
+		flowop readwholefile name = "readOP", filesetname = "testF"
+		flowop closefile name = "closeOP"
+	}
+}
+
+run {{runtime}}
+`
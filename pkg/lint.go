@@ -0,0 +1,530 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	identRegexp    = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	varRefRegexp   = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)`)
+	filebenchInt   = regexp.MustCompile(`^[0-9]+(\.[0-9]+)?[kKmMgGtT]?[bB]?$`)
+	fileReferences = []string{"filesetname", "filename"}
+)
+
+// Commands of the Filebench workload language allowed outside of a block
+var filebenchCommands = map[string]bool{
+	"create": true, "debug": true, "define": true, "domultisync": true,
+	"echo": true, "enable": true, "eventgen": true, "fscheck": true,
+	"fsflush": true, "list": true, "load": true, "osprof_disable": true,
+	"osprof_enable": true, "psrun": true, "quit": true, "run": true,
+	"set": true, "shutdown": true, "sleep": true, "stats": true,
+	"system": true, "usage": true, "vars": true, "version": true,
+	"warmup": true,
+}
+
+var filebenchFlowops = map[string]bool{
+	"aiowait": true, "aiowrite": true, "appendfile": true, "appendfilerand": true,
+	"block": true, "bwlimit": true, "closefile": true, "createfile": true,
+	"delay": true, "deletefile": true, "eventlimit": true, "finishonbytes": true,
+	"finishoncount": true, "fsync": true, "fsyncset": true, "hog": true,
+	"iopslimit": true, "listdir": true, "makedir": true, "openfile": true,
+	"opslimit": true, "print": true, "read": true, "readwholefile": true,
+	"removedir": true, "semblock": true, "sempost": true, "statfile": true,
+	"testrandvar": true, "wakeup": true, "write": true, "writewholefile": true,
+}
+
+// Attributes whose value is a size or a count
+var filebenchNumbers = map[string]bool{
+	"dirwidth": true, "entries": true, "filesize": true, "highwater": true,
+	"instances": true, "iosize": true, "iters": true, "leafdirs": true,
+	"memsize": true, "rate": true, "size": true, "value": true,
+}
+
+// LintIssue is a problem found in a workload file
+type LintIssue struct {
+	Line    int
+	Error   bool
+	Message string
+}
+
+func (i *LintIssue) String() string {
+	severity := "warning"
+
+	if i.Error {
+		severity = "error"
+	}
+
+	return fmt.Sprintf("line %d: %s: %s", i.Line, severity, i.Message)
+}
+
+// LintErrors returns the number of errors (not warnings) among issues
+func LintErrors(issues []*LintIssue) int {
+	n := 0
+
+	for _, issue := range issues {
+		if issue.Error {
+			n++
+		}
+	}
+
+	return n
+}
+
+// lintItem is a statement, or an opening or closing brace, of a workload file
+type lintItem struct {
+	line int
+	text string
+}
+
+// lintBlock is a block being parsed: a process, a thread or a composite
+// flowop
+type lintBlock struct {
+	kind string
+	name string
+	line int
+}
+
+// lintRef is a name referenced by a statement
+type lintRef struct {
+	line int
+	name string
+}
+
+type linter struct {
+	issues    []*LintIssue
+	vars      map[string]string
+	filesets  map[string]int
+	flowops   map[string]bool
+	processes map[string]int
+	fileRefs  []lintRef
+	targets   []lintRef
+	varRefs   []lintRef
+}
+
+// LintWorkload checks a workload file of Filebench: its syntax, the blocks of
+// processes and threads, and the names it references, sorted by line
+func LintWorkload(content string) []*LintIssue {
+	l := &linter{
+		vars:      make(map[string]string),
+		filesets:  make(map[string]int),
+		flowops:   make(map[string]bool),
+		processes: make(map[string]int),
+	}
+
+	var (
+		stack   []*lintBlock
+		pending *lintBlock
+	)
+
+	items := l.split(content)
+
+	if len(items) == 0 {
+		l.errorf(1, "The workload is empty")
+	}
+
+	for _, item := range items {
+		if pending != nil && item.text != "{" {
+			l.errorf(pending.line, "Expected { to open the block of %s %s", pending.kind, pending.name)
+			pending = nil
+		}
+
+		switch item.text {
+		case "{":
+			if pending == nil {
+				l.errorf(item.line, "Unexpected {")
+				continue
+			}
+
+			stack = append(stack, pending)
+			pending = nil
+		case "}":
+			if len(stack) == 0 {
+				l.errorf(item.line, "Unexpected }")
+				continue
+			}
+
+			stack = stack[:len(stack)-1]
+		default:
+			var parent *lintBlock
+
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+
+			pending = l.statement(item, parent)
+		}
+	}
+
+	if pending != nil {
+		l.errorf(pending.line, "Expected { to open the block of %s %s", pending.kind, pending.name)
+	}
+
+	for _, block := range stack {
+		l.errorf(block.line, "The block of %s %s is not closed", block.kind, block.name)
+	}
+
+	l.checkReferences()
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		return l.issues[i].Line < l.issues[j].Line
+	})
+
+	return l.issues
+}
+
+func (l *linter) errorf(line int, format string, args ...interface{}) {
+	l.issues = append(l.issues, &LintIssue{Line: line, Error: true, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *linter) warnf(line int, format string, args ...interface{}) {
+	l.issues = append(l.issues, &LintIssue{Line: line, Message: fmt.Sprintf(format, args...)})
+}
+
+// split cuts a workload file into statements and braces, without comments
+func (l *linter) split(content string) []lintItem {
+	var items []lintItem
+
+	for n, line := range strings.Split(content, "\n") {
+		var current bytes.Buffer
+
+		quoted, depth := false, 0
+
+		flush := func() {
+			if text := strings.TrimSpace(current.String()); text != "" {
+				items = append(items, lintItem{line: n + 1, text: text})
+			}
+
+			current.Reset()
+		}
+
+	scan:
+		for _, r := range line {
+			switch {
+			case r == '"':
+				quoted = !quoted
+			case quoted:
+			case r == '#':
+				break scan
+			case r == '(':
+				depth++
+			case r == ')':
+				depth--
+			case (r == '{' || r == '}') && depth == 0:
+				flush()
+				items = append(items, lintItem{line: n + 1, text: string(r)})
+				continue
+			}
+
+			current.WriteRune(r)
+		}
+
+		if quoted {
+			l.errorf(n+1, "Unterminated string")
+		}
+
+		if depth != 0 {
+			l.errorf(n+1, "Unbalanced parentheses")
+		}
+
+		flush()
+	}
+
+	return items
+}
+
+// statement checks a statement in a block (nil outside of any block), it
+// returns the block the statement opens, if any
+func (l *linter) statement(item lintItem, parent *lintBlock) *lintBlock {
+	fields := strings.Fields(item.text)
+	command := fields[0]
+	rest := strings.TrimSpace(item.text[len(command):])
+
+	switch {
+	case parent == nil && command == "define":
+		return l.define(item.line, rest)
+	case parent == nil && filebenchCommands[command]:
+		l.command(item.line, command, fields[1:], rest)
+	case parent == nil && (command == "thread" || command == "flowop"):
+		l.errorf(item.line, "%s outside of a block, threads are defined in processes and flowops in threads", strings.Title(command))
+	case parent == nil:
+		l.errorf(item.line, "Unknown command %q", command)
+	case parent.kind == "process" && command == "thread":
+		attrs := l.attributes(item.line, rest)
+
+		if attrs["name"] == "" {
+			l.errorf(item.line, "Thread without name")
+		}
+
+		return &lintBlock{kind: "thread", name: attrs["name"], line: item.line}
+	case parent.kind == "process":
+		l.errorf(item.line, "Only threads can be defined in process %s, not %q", parent.name, command)
+	case command == "flowop":
+		l.flowop(item.line, rest)
+	default:
+		l.errorf(item.line, "Only flowops can be defined in %s %s, not %q", parent.kind, parent.name, command)
+	}
+
+	return nil
+}
+
+func (l *linter) define(line int, text string) *lintBlock {
+	fields := strings.Fields(text)
+
+	if len(fields) == 0 {
+		l.errorf(line, "Nothing to define")
+		return nil
+	}
+
+	kind := fields[0]
+	attrs := l.attributes(line, strings.TrimSpace(text[len(kind):]))
+	name := l.resolve(attrs["name"])
+
+	if attrs["name"] == "" {
+		l.errorf(line, "%s without name", strings.Title(kind))
+	}
+
+	switch kind {
+	case "file", "fileset":
+		if _, ok := attrs["path"]; !ok {
+			l.errorf(line, "%s %s has no path", strings.Title(kind), name)
+		}
+
+		if defined, ok := l.filesets[name]; ok && name != "" {
+			l.errorf(line, "%s %s is already defined line %d", strings.Title(kind), name, defined)
+		}
+
+		l.filesets[name] = line
+	case "process":
+		if defined, ok := l.processes[name]; ok && name != "" {
+			l.errorf(line, "Process %s is already defined line %d", name, defined)
+		}
+
+		l.processes[name] = line
+
+		return &lintBlock{kind: "process", name: name, line: line}
+	case "flowop":
+		// A composite flowop is a block of flowops
+		l.flowops[name] = true
+
+		return &lintBlock{kind: "flowop", name: name, line: line}
+	case "randvar", "cvar":
+	default:
+		l.errorf(line, "Cannot define %q, expected file, fileset, process, flowop, randvar or cvar", kind)
+	}
+
+	return nil
+}
+
+func (l *linter) flowop(line int, text string) {
+	fields := strings.Fields(text)
+
+	if len(fields) == 0 {
+		l.errorf(line, "Flowop without type")
+		return
+	}
+
+	kind := fields[0]
+
+	if !filebenchFlowops[kind] && !l.flowops[kind] {
+		l.errorf(line, "Unknown flowop %q", kind)
+	}
+
+	attrs := l.attributes(line, strings.TrimSpace(text[len(kind):]))
+
+	if attrs["name"] == "" {
+		l.errorf(line, "Flowop %s without name", kind)
+	}
+
+	l.flowops[l.resolve(attrs["name"])] = true
+
+	for _, key := range fileReferences {
+		if value, ok := attrs[key]; ok {
+			l.fileRefs = append(l.fileRefs, lintRef{line: line, name: value})
+		}
+	}
+
+	if target, ok := attrs["target"]; ok {
+		l.targets = append(l.targets, lintRef{line: line, name: target})
+	}
+}
+
+func (l *linter) command(line int, command string, args []string, text string) {
+	switch command {
+	case "set":
+		if len(args) > 0 && args[0] == "mode" {
+			return
+		}
+
+		i := strings.Index(text, "=")
+
+		if i < 0 {
+			l.errorf(line, "Expected set $name = value")
+			return
+		}
+
+		name, value := strings.TrimSpace(text[:i]), strings.TrimSpace(text[i+1:])
+
+		if !strings.HasPrefix(name, "$") || !identRegexp.MatchString(name[1:]) {
+			l.errorf(line, "Invalid variable name %q", name)
+			return
+		}
+
+		if value == "" {
+			l.errorf(line, "Variable %s has no value", name)
+		}
+
+		l.useVars(line, value)
+		l.vars[name[1:]] = unquote(value)
+	case "run", "sleep", "warmup":
+		if len(args) > 1 || (command != "run" && len(args) == 0) {
+			l.errorf(line, "Expected %s <seconds>", command)
+		} else if len(args) == 1 {
+			l.number(line, command, args[0])
+		}
+	case "eventgen":
+		attrs := l.attributes(line, text)
+
+		if _, ok := attrs["rate"]; !ok {
+			l.errorf(line, "Expected eventgen rate = <events per second>")
+		}
+	default:
+		l.useVars(line, text)
+	}
+}
+
+// attributes parses a list of attributes, "key = value" or "key", separated
+// by commas
+func (l *linter) attributes(line int, text string) map[string]string {
+	attrs := make(map[string]string)
+
+	if text == "" {
+		return attrs
+	}
+
+	for _, item := range splitAttributes(text) {
+		item = strings.TrimSpace(item)
+
+		if item == "" {
+			l.errorf(line, "Empty attribute, check the commas")
+			continue
+		}
+
+		key, value := item, ""
+
+		if i := strings.Index(item, "="); i >= 0 {
+			key, value = strings.TrimSpace(item[:i]), strings.TrimSpace(item[i+1:])
+
+			if value == "" {
+				l.errorf(line, "Attribute %s has no value", key)
+			}
+		}
+
+		if !identRegexp.MatchString(key) {
+			l.errorf(line, "Invalid attribute %q", key)
+			continue
+		}
+
+		if value != "" && filebenchNumbers[key] {
+			l.number(line, key, value)
+		}
+
+		l.useVars(line, value)
+		attrs[key] = unquote(value)
+	}
+
+	return attrs
+}
+
+// number checks a count or a size, e.g. "10000" or "16k", unless it is a
+// variable or an expression
+func (l *linter) number(line int, key, value string) {
+	value = unquote(value)
+
+	if strings.ContainsAny(value, "$(") || filebenchInt.MatchString(value) {
+		return
+	}
+
+	l.errorf(line, "Invalid number %q for %s", value, key)
+}
+
+func (l *linter) useVars(line int, text string) {
+	for _, match := range varRefRegexp.FindAllStringSubmatch(text, -1) {
+		l.varRefs = append(l.varRefs, lintRef{line: line, name: match[1]})
+	}
+}
+
+// resolve returns the value of a variable, or the given name
+func (l *linter) resolve(name string) string {
+	if strings.HasPrefix(name, "$") {
+		if value, ok := l.vars[name[1:]]; ok {
+			return value
+		}
+	}
+
+	return name
+}
+
+func (l *linter) checkReferences() {
+	for _, ref := range l.fileRefs {
+		name := l.resolve(ref.name)
+
+		if _, ok := l.filesets[name]; !ok && !strings.Contains(name, "$") {
+			l.errorf(ref.line, "Undefined fileset %s", name)
+		}
+	}
+
+	for _, ref := range l.targets {
+		if name := l.resolve(ref.name); !l.flowops[name] && !strings.Contains(name, "$") {
+			l.errorf(ref.line, "Undefined target flowop %s", name)
+		}
+	}
+
+	for _, ref := range l.varRefs {
+		if _, ok := l.vars[ref.name]; !ok {
+			l.warnf(ref.line, "Variable $%s is not set", ref.name)
+		}
+	}
+}
+
+// splitAttributes splits a list of attributes on the commas which are not
+// in a string or between parentheses
+func splitAttributes(text string) []string {
+	var (
+		items   []string
+		current bytes.Buffer
+	)
+
+	quoted, depth := false, 0
+
+	for _, r := range text {
+		switch {
+		case r == '"':
+			quoted = !quoted
+		case quoted:
+		case r == '(':
+			depth++
+		case r == ')':
+			depth--
+		case r == ',' && depth == 0:
+			items = append(items, current.String())
+			current.Reset()
+			continue
+		}
+
+		current.WriteRune(r)
+	}
+
+	return append(items, current.String())
+}
+
+func unquote(value string) string {
+	if len(value) >= 2 && strings.HasPrefix(value, `"`) && strings.HasSuffix(value, `"`) {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
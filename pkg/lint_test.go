@@ -0,0 +1,143 @@
+package benchdrilltasks
+
+import (
+	"strings"
+	"testing"
+)
+
+// lintWorkload is a valid workload, line by line, which the tests break
+var lintWorkload = []string{
+	"set $dir=/tmp",
+	"define fileset name=bigfileset,path=$dir,entries=10,dirwidth=2,size=1k,prealloc",
+	"define process name=reader,instances=1 {",
+	"  thread name=readerthread,memsize=10m,instances=2 {",
+	"    flowop openfile name=openfile1,filesetname=bigfileset,fd=1",
+	"    flowop readwholefile name=readfile1,fd=1",
+	"    flowop closefile name=closefile1,fd=1",
+	"  }",
+	"}",
+	"run 60",
+}
+
+// withLine returns the lines of the valid workload with line n (from 1)
+// replaced, or removed if the replacement is ""
+func withLine(n int, replacement string) string {
+	lines := append([]string{}, lintWorkload...)
+
+	if replacement == "" {
+		lines = append(lines[:n-1], lines[n:]...)
+	} else {
+		lines[n-1] = replacement
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestLintWorkload(t *testing.T) {
+	tests := []struct {
+		name     string
+		workload string
+		// Issue expected, as printed
+		issue string
+	}{
+		{
+			"undefined fileset",
+			withLine(5, "    flowop openfile name=openfile1,filesetname=smallfileset,fd=1"),
+			"line 5: error: Undefined fileset smallfileset",
+		},
+		{
+			"unknown flowop",
+			withLine(6, "    flowop readfast name=readfile1,fd=1"),
+			`line 6: error: Unknown flowop "readfast"`,
+		},
+		{
+			"unterminated define",
+			withLine(9, ""),
+			"line 3: error: The block of process reader is not closed",
+		},
+		{
+			"define without block",
+			withLine(3, "define process name=reader,instances=1"),
+			"line 3: error: Expected { to open the block of process reader",
+		},
+		{
+			"empty attribute",
+			withLine(2, "define fileset name=bigfileset,,path=$dir,entries=10"),
+			"line 2: error: Empty attribute, check the commas",
+		},
+		{
+			"attribute without value",
+			withLine(2, "define fileset name=bigfileset,path=,entries=10"),
+			"line 2: error: Attribute path has no value",
+		},
+		{
+			"invalid number",
+			withLine(4, "  thread name=readerthread,memsize=10m,instances=two {"),
+			`line 4: error: Invalid number "two" for instances`,
+		},
+		{
+			"invalid attribute",
+			withLine(6, "    flowop readwholefile name=readfile1,fd-1=1"),
+			`line 6: error: Invalid attribute "fd-1"`,
+		},
+		{
+			"flowop outside of a thread",
+			withLine(4, "  flowop openfile name=openfile2,filesetname=bigfileset,fd=1"),
+			"line 4: error: Only threads can be defined in process reader, not \"flowop\"",
+		},
+		{
+			"unset variable",
+			withLine(1, "set $root=/tmp"),
+			"line 2: warning: Variable $dir is not set",
+		},
+	}
+
+	for _, test := range tests {
+		var issues []string
+
+		for _, issue := range LintWorkload(test.workload) {
+			issues = append(issues, issue.String())
+		}
+
+		found := false
+
+		for _, issue := range issues {
+			found = found || issue == test.issue
+		}
+
+		if !found {
+			t.Errorf("%s: expected %q, got %q", test.name, test.issue, issues)
+		}
+	}
+}
+
+func TestLintWorkloadValid(t *testing.T) {
+	if issues := LintWorkload(strings.Join(lintWorkload, "\n")); len(issues) > 0 {
+		t.Errorf("Expected no issue, got %v", issues)
+	}
+}
+
+func TestLintBuiltinWorkloads(t *testing.T) {
+	for _, w := range builtinWorkloads {
+		if w.Tool() != "filebench" {
+			continue
+		}
+
+		values, err := w.Values(nil)
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		workload, err := RenderWorkload(w.File, values, "group_test", "task_test", 0, 1)
+
+		if err != nil {
+			t.Errorf("%s: %s", w.Name, err.Error())
+			continue
+		}
+
+		for _, issue := range LintWorkload(workload) {
+			t.Errorf("%s: %s", w.Name, issue)
+		}
+	}
+}
@@ -0,0 +1,869 @@
+package benchdrilltasks
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// LocalRedis is an in-memory server speaking enough of the Redis protocol
+// for machinery's Redis broker and backend and for the Store, so that the
+// client and workers can run in a single process without Redis. It has a
+// single database and no persistence
+type LocalRedis struct {
+	listener net.Listener
+	mu       sync.Mutex
+	values   map[string]*redisValue
+	// Bumped on each write to a key, for WATCH
+	versions map[string]int64
+	// Closed and replaced whenever a list is pushed to, to wake up BLPOP
+	pushed chan struct{}
+	// Number of clients blocked in BLPOP, by key
+	blocked map[string]int
+}
+
+type redisValue struct {
+	kind    string
+	str     []byte
+	list    [][]byte
+	zset    map[string]float64
+	expires time.Time
+}
+
+type (
+	redisStatus   string
+	redisError    string
+	redisNilArray struct{}
+)
+
+var redisOK = redisStatus("OK")
+
+// ListenLocalRedis starts a LocalRedis listening on a TCP address, such as
+// "127.0.0.1:0" for any free port
+func ListenLocalRedis(address string) (*LocalRedis, error) {
+	listener, err := net.Listen("tcp", address)
+
+	if err != nil {
+		return nil, err
+	}
+
+	r := &LocalRedis{
+		listener: listener,
+		values:   make(map[string]*redisValue),
+		versions: make(map[string]int64),
+		pushed:   make(chan struct{}),
+		blocked:  make(map[string]int),
+	}
+
+	go func() {
+		for {
+			conn, err := listener.Accept()
+
+			if err != nil {
+				return
+			}
+
+			go r.serve(conn)
+		}
+	}()
+
+	return r, nil
+}
+
+// URL returns the redis:// URL of the server
+func (r *LocalRedis) URL() string {
+	return "redis://" + r.listener.Addr().String() + "/"
+}
+
+// Waiting returns whether a client is blocked popping from a list, such as
+// a worker waiting for tasks on a queue
+func (r *LocalRedis) Waiting(key string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.blocked[key] > 0
+}
+
+// Close stops accepting connections
+func (r *LocalRedis) Close() error {
+	return r.listener.Close()
+}
+
+func (r *LocalRedis) serve(conn net.Conn) {
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	writer := bufio.NewWriter(conn)
+
+	var (
+		multi   bool
+		queued  [][][]byte
+		watched map[string]int64
+	)
+
+	for {
+		args, err := readRedisCommand(reader)
+
+		if err != nil {
+			return
+		}
+
+		if len(args) == 0 {
+			continue
+		}
+
+		var reply interface{}
+
+		switch name := strings.ToUpper(string(args[0])); {
+		case multi && name != "EXEC" && name != "DISCARD" && name != "MULTI":
+			queued = append(queued, args)
+			reply = redisStatus("QUEUED")
+		case name == "MULTI":
+			multi, queued = true, nil
+			reply = redisOK
+		case name == "DISCARD":
+			multi, queued, watched = false, nil, nil
+			reply = redisOK
+		case name == "WATCH":
+			r.mu.Lock()
+
+			if watched == nil {
+				watched = make(map[string]int64)
+			}
+
+			for _, key := range args[1:] {
+				watched[string(key)] = r.versions[string(key)]
+			}
+
+			r.mu.Unlock()
+			reply = redisOK
+		case name == "UNWATCH":
+			watched = nil
+			reply = redisOK
+		case name == "EXEC":
+			if multi {
+				reply = r.exec(queued, watched)
+			} else {
+				reply = redisError("ERR EXEC without MULTI")
+			}
+
+			multi, queued, watched = false, nil, nil
+		case name == "BLPOP":
+			reply = r.blpop(args[1:])
+		default:
+			r.mu.Lock()
+			reply = r.do(name, args[1:])
+			r.mu.Unlock()
+		}
+
+		writeRedisReply(writer, reply)
+
+		if err := writer.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// exec runs a transaction, unless a watched key was written to meanwhile
+func (r *LocalRedis) exec(queued [][][]byte, watched map[string]int64) interface{} {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, version := range watched {
+		if r.versions[key] != version {
+			return redisNilArray{}
+		}
+	}
+
+	replies := make([]interface{}, 0, len(queued))
+
+	for _, args := range queued {
+		replies = append(replies, r.do(strings.ToUpper(string(args[0])), args[1:]))
+	}
+
+	return replies
+}
+
+// blpop pops the first element of the first non-empty list, waiting for one
+// until the timeout (forever if 0)
+func (r *LocalRedis) blpop(args [][]byte) interface{} {
+	if len(args) < 2 {
+		return redisArgsError("blpop")
+	}
+
+	seconds, err := strconv.ParseFloat(string(args[len(args)-1]), 64)
+
+	if err != nil || seconds < 0 {
+		return redisError("ERR timeout is not a float or out of range")
+	}
+
+	var timeout <-chan time.Time
+
+	if seconds > 0 {
+		timer := time.NewTimer(time.Duration(seconds * float64(time.Second)))
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	for {
+		r.mu.Lock()
+
+		for _, key := range args[:len(args)-1] {
+			if value := r.lookup(string(key)); value != nil && value.kind == "list" {
+				element := r.lpop(string(key), value)
+				r.mu.Unlock()
+
+				return []interface{}{key, element}
+			}
+		}
+
+		pushed := r.pushed
+		r.block(args[:len(args)-1], 1)
+		r.mu.Unlock()
+
+		select {
+		case <-pushed:
+			r.mu.Lock()
+			r.block(args[:len(args)-1], -1)
+			r.mu.Unlock()
+		case <-timeout:
+			r.mu.Lock()
+			r.block(args[:len(args)-1], -1)
+			r.mu.Unlock()
+
+			return redisNilArray{}
+		}
+	}
+}
+
+// block counts the clients blocked on keys, r.mu being held
+func (r *LocalRedis) block(keys [][]byte, delta int) {
+	for _, key := range keys {
+		r.blocked[string(key)] += delta
+	}
+}
+
+// do runs a command, r.mu being held
+func (r *LocalRedis) do(name string, args [][]byte) interface{} {
+	arity := map[string]int{
+		"GET": 1, "SET": 2, "MGET": 1, "DEL": 1, "EXPIRE": 2, "EXPIREAT": 2,
+		"PEXPIRE": 2, "PERSIST": 1, "KEYS": 1, "TYPE": 1, "INCR": 1, "LLEN": 1, "RPUSH": 2,
+		"LPUSH": 2, "LPOP": 1, "LRANGE": 3, "ZADD": 3, "ZCARD": 1, "ZREM": 2,
+		"ZRANGEBYSCORE": 3, "EVAL": 2, "EVALSHA": 2, "SCRIPT": 1,
+	}
+
+	if len(args) < arity[name] {
+		return redisArgsError(strings.ToLower(name))
+	}
+
+	switch name {
+	case "PING":
+		return redisStatus("PONG")
+	case "SELECT", "AUTH":
+		return redisOK
+	case "FLUSHALL", "FLUSHDB":
+		for key := range r.values {
+			r.versions[key]++
+		}
+
+		r.values = make(map[string]*redisValue)
+
+		return redisOK
+	case "GET":
+		value, err := r.lookupKind(string(args[0]), "string")
+
+		if err != nil || value == nil {
+			return err
+		}
+
+		return value.str
+	case "SET":
+		return r.set(args)
+	case "MGET":
+		replies := make([]interface{}, len(args))
+
+		for i, key := range args {
+			if value := r.lookup(string(key)); value != nil && value.kind == "string" {
+				replies[i] = value.str
+			}
+		}
+
+		return replies
+	case "DEL":
+		deleted := 0
+
+		for _, key := range args {
+			if r.lookup(string(key)) != nil {
+				r.remove(string(key))
+				deleted++
+			}
+		}
+
+		return deleted
+	case "EXPIRE", "EXPIREAT", "PEXPIRE":
+		n, err := strconv.ParseInt(string(args[1]), 10, 64)
+
+		if err != nil {
+			return redisError("ERR value is not an integer or out of range")
+		}
+
+		value := r.lookup(string(args[0]))
+
+		if value == nil {
+			return 0
+		}
+
+		switch name {
+		case "EXPIRE":
+			value.expires = time.Now().Add(time.Duration(n) * time.Second)
+		case "EXPIREAT":
+			value.expires = time.Unix(n, 0)
+		default:
+			value.expires = time.Now().Add(time.Duration(n) * time.Millisecond)
+		}
+
+		r.versions[string(args[0])]++
+
+		return 1
+	case "PERSIST":
+		value := r.lookup(string(args[0]))
+
+		if value == nil || value.expires.IsZero() {
+			return 0
+		}
+
+		value.expires = time.Time{}
+		r.versions[string(args[0])]++
+
+		return 1
+	case "KEYS":
+		var keys []string
+
+		for key := range r.values {
+			if matched, _ := path.Match(string(args[0]), key); matched && r.lookup(key) != nil {
+				keys = append(keys, key)
+			}
+		}
+
+		sort.Strings(keys)
+		replies := make([]interface{}, len(keys))
+
+		for i, key := range keys {
+			replies[i] = []byte(key)
+		}
+
+		return replies
+	case "TYPE":
+		if value := r.lookup(string(args[0])); value != nil {
+			return redisStatus(value.kind)
+		}
+
+		return redisStatus("none")
+	case "INCR":
+		value, err := r.lookupKind(string(args[0]), "string")
+
+		if err != nil {
+			return err
+		}
+
+		n := int64(0)
+
+		if value != nil {
+			parsed, err := strconv.ParseInt(string(value.str), 10, 64)
+
+			if err != nil {
+				return redisError("ERR value is not an integer or out of range")
+			}
+
+			n = parsed
+		} else {
+			value = &redisValue{kind: "string"}
+			r.values[string(args[0])] = value
+		}
+
+		n++
+		value.str = []byte(strconv.FormatInt(n, 10))
+		r.versions[string(args[0])]++
+
+		return n
+	case "LLEN", "LPOP", "LRANGE", "RPUSH", "LPUSH":
+		return r.doList(name, args)
+	case "ZADD", "ZCARD", "ZREM", "ZRANGEBYSCORE":
+		return r.doZSet(name, args)
+	case "EVAL":
+		return r.eval(args)
+	case "EVALSHA":
+		return redisError("NOSCRIPT No matching script. Please use EVAL.")
+	case "SCRIPT":
+		if strings.ToUpper(string(args[0])) == "LOAD" && len(args) == 2 {
+			sum := sha1.Sum(args[1])
+			return []byte(hex.EncodeToString(sum[:]))
+		}
+
+		return redisError("ERR unsupported SCRIPT subcommand")
+	}
+
+	return redisError(fmt.Sprintf("ERR unknown command '%s'", strings.ToLower(name)))
+}
+
+// set runs SET key value [EX seconds] [PX milliseconds] [NX|XX]
+func (r *LocalRedis) set(args [][]byte) interface{} {
+	key := string(args[0])
+
+	var (
+		expires time.Time
+		nx, xx  bool
+	)
+
+	for i := 2; i < len(args); i++ {
+		switch option := strings.ToUpper(string(args[i])); option {
+		case "NX":
+			nx = true
+		case "XX":
+			xx = true
+		case "EX", "PX":
+			if i+1 >= len(args) {
+				return redisError("ERR syntax error")
+			}
+
+			n, err := strconv.ParseInt(string(args[i+1]), 10, 64)
+
+			if err != nil || n <= 0 {
+				return redisError("ERR invalid expire time in set")
+			}
+
+			unit := time.Second
+
+			if option == "PX" {
+				unit = time.Millisecond
+			}
+
+			expires = time.Now().Add(time.Duration(n) * unit)
+			i++
+		default:
+			return redisError("ERR syntax error")
+		}
+	}
+
+	exists := r.lookup(key) != nil
+
+	if (nx && exists) || (xx && !exists) {
+		return nil
+	}
+
+	r.values[key] = &redisValue{kind: "string", str: args[1], expires: expires}
+	r.versions[key]++
+
+	return redisOK
+}
+
+func (r *LocalRedis) doList(name string, args [][]byte) interface{} {
+	key := string(args[0])
+	value, err := r.lookupKind(key, "list")
+
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "LLEN":
+		if value == nil {
+			return 0
+		}
+
+		return len(value.list)
+	case "LPOP":
+		if value == nil {
+			return nil
+		}
+
+		return r.lpop(key, value)
+	case "LRANGE":
+		start, err1 := strconv.Atoi(string(args[1]))
+		stop, err2 := strconv.Atoi(string(args[2]))
+
+		if err1 != nil || err2 != nil {
+			return redisError("ERR value is not an integer or out of range")
+		}
+
+		replies := []interface{}{}
+
+		if value == nil {
+			return replies
+		}
+
+		start, stop = redisRange(start, stop, len(value.list))
+
+		for i := start; i <= stop; i++ {
+			replies = append(replies, value.list[i])
+		}
+
+		return replies
+	}
+
+	if value == nil {
+		value = &redisValue{kind: "list"}
+		r.values[key] = value
+	}
+
+	for _, element := range args[1:] {
+		if name == "RPUSH" {
+			value.list = append(value.list, element)
+		} else {
+			value.list = append([][]byte{element}, value.list...)
+		}
+	}
+
+	r.versions[key]++
+	close(r.pushed)
+	r.pushed = make(chan struct{})
+
+	return len(value.list)
+}
+
+// lpop removes the first element of a list, and the list once empty
+func (r *LocalRedis) lpop(key string, value *redisValue) []byte {
+	element := value.list[0]
+	value.list = value.list[1:]
+
+	if len(value.list) == 0 {
+		delete(r.values, key)
+	}
+
+	r.versions[key]++
+
+	return element
+}
+
+func (r *LocalRedis) doZSet(name string, args [][]byte) interface{} {
+	key := string(args[0])
+	value, err := r.lookupKind(key, "zset")
+
+	if err != nil {
+		return err
+	}
+
+	switch name {
+	case "ZCARD":
+		if value == nil {
+			return 0
+		}
+
+		return len(value.zset)
+	case "ZADD":
+		if len(args)%2 != 1 {
+			return redisError("ERR syntax error")
+		}
+
+		if value == nil {
+			value = &redisValue{kind: "zset", zset: make(map[string]float64)}
+			r.values[key] = value
+		}
+
+		added := 0
+
+		for i := 1; i < len(args); i += 2 {
+			score, err := strconv.ParseFloat(string(args[i]), 64)
+
+			if err != nil {
+				return redisError("ERR value is not a valid float")
+			}
+
+			if _, ok := value.zset[string(args[i+1])]; !ok {
+				added++
+			}
+
+			value.zset[string(args[i+1])] = score
+		}
+
+		r.versions[key]++
+
+		return added
+	case "ZREM":
+		removed := 0
+
+		if value == nil {
+			return removed
+		}
+
+		for _, member := range args[1:] {
+			if _, ok := value.zset[string(member)]; ok {
+				delete(value.zset, string(member))
+				removed++
+			}
+		}
+
+		if len(value.zset) == 0 {
+			delete(r.values, key)
+		}
+
+		r.versions[key]++
+
+		return removed
+	}
+
+	// ZRANGEBYSCORE key min max [LIMIT offset count]
+	min, minExclusive, err1 := parseRedisScore(string(args[1]))
+	max, maxExclusive, err2 := parseRedisScore(string(args[2]))
+
+	if err1 != nil || err2 != nil {
+		return redisError("ERR min or max is not a float")
+	}
+
+	offset, count := 0, -1
+
+	if len(args) == 6 && strings.ToUpper(string(args[3])) == "LIMIT" {
+		offset, _ = strconv.Atoi(string(args[4]))
+		count, _ = strconv.Atoi(string(args[5]))
+	} else if len(args) != 3 {
+		return redisError("ERR syntax error")
+	}
+
+	replies := []interface{}{}
+
+	if value == nil {
+		return replies
+	}
+
+	var members []string
+
+	for member, score := range value.zset {
+		if (score > min || (score == min && !minExclusive)) && (score < max || (score == max && !maxExclusive)) {
+			members = append(members, member)
+		}
+	}
+
+	sort.Slice(members, func(i, j int) bool {
+		if value.zset[members[i]] != value.zset[members[j]] {
+			return value.zset[members[i]] < value.zset[members[j]]
+		}
+
+		return members[i] < members[j]
+	})
+
+	for i, member := range members {
+		if i >= offset && (count < 0 || len(replies) < count) {
+			replies = append(replies, []byte(member))
+		}
+	}
+
+	return replies
+}
+
+// eval runs the only scripts in use, those of the locks of redsync: they
+// delete a key, or set its expiry, if it still holds a given value
+func (r *LocalRedis) eval(args [][]byte) interface{} {
+	script := string(args[0])
+
+	if n, err := strconv.Atoi(string(args[1])); err != nil || n != 1 || len(args) < 4 {
+		return redisError("ERR unsupported script")
+	}
+
+	key, expected := string(args[2]), args[3]
+	value := r.lookup(key)
+	holds := value != nil && value.kind == "string" && string(value.str) == string(expected)
+
+	switch {
+	case strings.Contains(script, `"DEL"`):
+		if !holds {
+			return 0
+		}
+
+		r.remove(key)
+
+		return 1
+	case strings.Contains(script, `"SET"`) && len(args) >= 5:
+		if !holds {
+			return []byte("ERR")
+		}
+
+		return r.set([][]byte{args[2], args[3], []byte("PX"), args[4]})
+	}
+
+	return redisError("ERR unsupported script")
+}
+
+// lookup returns the value at key, nil if there is none or it expired
+func (r *LocalRedis) lookup(key string) *redisValue {
+	value, ok := r.values[key]
+
+	if !ok {
+		return nil
+	}
+
+	if !value.expires.IsZero() && time.Now().After(value.expires) {
+		r.remove(key)
+		return nil
+	}
+
+	return value
+}
+
+// lookupKind returns the value at key, or an error if it is of another kind
+func (r *LocalRedis) lookupKind(key, kind string) (*redisValue, interface{}) {
+	value := r.lookup(key)
+
+	if value != nil && value.kind != kind {
+		return nil, redisError("WRONGTYPE Operation against a key holding the wrong kind of value")
+	}
+
+	return value, nil
+}
+
+func (r *LocalRedis) remove(key string) {
+	delete(r.values, key)
+	r.versions[key]++
+}
+
+func redisArgsError(command string) redisError {
+	return redisError(fmt.Sprintf("ERR wrong number of arguments for '%s' command", command))
+}
+
+// redisRange converts the start and stop indexes of LRANGE, which may count
+// from the end, to indexes within a list
+func redisRange(start, stop, length int) (int, int) {
+	if start < 0 {
+		start += length
+	}
+
+	if stop < 0 {
+		stop += length
+	}
+
+	if start < 0 {
+		start = 0
+	}
+
+	if stop >= length {
+		stop = length - 1
+	}
+
+	return start, stop
+}
+
+// parseRedisScore parses a score bound, which may be -inf, +inf or, prefixed
+// with "(", exclusive
+func parseRedisScore(s string) (float64, bool, error) {
+	exclusive := strings.HasPrefix(s, "(")
+	s = strings.TrimPrefix(s, "(")
+
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.Inf(-1), exclusive, nil
+	case "+inf", "inf":
+		return math.Inf(1), exclusive, nil
+	}
+
+	score, err := strconv.ParseFloat(s, 64)
+
+	return score, exclusive, err
+}
+
+// readRedisCommand reads a command, as an array of bulk strings or inline
+func readRedisCommand(reader *bufio.Reader) ([][]byte, error) {
+	line, err := readRedisLine(reader)
+
+	if err != nil {
+		return nil, err
+	}
+
+	if !strings.HasPrefix(line, "*") {
+		var args [][]byte
+
+		for _, field := range strings.Fields(line) {
+			args = append(args, []byte(field))
+		}
+
+		return args, nil
+	}
+
+	n, err := strconv.Atoi(line[1:])
+
+	if err != nil {
+		return nil, fmt.Errorf("Invalid multibulk length %q", line)
+	}
+
+	args := make([][]byte, 0, n)
+
+	for i := 0; i < n; i++ {
+		header, err := readRedisLine(reader)
+
+		if err != nil {
+			return nil, err
+		}
+
+		if !strings.HasPrefix(header, "$") {
+			return nil, fmt.Errorf("Expected a bulk string, got %q", header)
+		}
+
+		size, err := strconv.Atoi(header[1:])
+
+		if err != nil || size < 0 {
+			return nil, fmt.Errorf("Invalid bulk length %q", header)
+		}
+
+		arg := make([]byte, size+2)
+
+		if _, err := io.ReadFull(reader, arg); err != nil {
+			return nil, err
+		}
+
+		args = append(args, arg[:size])
+	}
+
+	return args, nil
+}
+
+func readRedisLine(reader *bufio.Reader) (string, error) {
+	line, err := reader.ReadString('\n')
+
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+func writeRedisReply(writer *bufio.Writer, reply interface{}) {
+	switch reply := reply.(type) {
+	case nil:
+		writer.WriteString("$-1\r\n")
+	case redisNilArray:
+		writer.WriteString("*-1\r\n")
+	case redisStatus:
+		fmt.Fprintf(writer, "+%s\r\n", reply)
+	case redisError:
+		fmt.Fprintf(writer, "-%s\r\n", reply)
+	case int:
+		fmt.Fprintf(writer, ":%d\r\n", reply)
+	case int64:
+		fmt.Fprintf(writer, ":%d\r\n", reply)
+	case []byte:
+		if reply == nil {
+			writer.WriteString("$-1\r\n")
+			return
+		}
+
+		fmt.Fprintf(writer, "$%d\r\n", len(reply))
+		writer.Write(reply)
+		writer.WriteString("\r\n")
+	case []interface{}:
+		fmt.Fprintf(writer, "*%d\r\n", len(reply))
+
+		for _, element := range reply {
+			writeRedisReply(writer, element)
+		}
+	default:
+		panic(fmt.Sprintf("Unexpected reply type %T", reply))
+	}
+}
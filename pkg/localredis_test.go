@@ -0,0 +1,246 @@
+package benchdrilltasks
+
+import (
+	"errors"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+func dialLocalRedis(t *testing.T) (*LocalRedis, redis.Conn) {
+	r, err := ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := redis.DialURL(r.URL())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r, conn
+}
+
+func TestLocalRedisStrings(t *testing.T) {
+	r, conn := dialLocalRedis(t)
+	defer r.Close()
+	defer conn.Close()
+
+	if _, err := conn.Do("SET", "a", "1"); err != nil {
+		t.Fatal(err)
+	}
+
+	if reply, _ := conn.Do("SET", "a", "2", "NX"); reply != nil {
+		t.Errorf("SET NX replaced an existing key")
+	}
+
+	if value, _ := redis.String(conn.Do("GET", "a")); value != "1" {
+		t.Errorf("GET returned %q, expected 1", value)
+	}
+
+	if _, err := redis.String(conn.Do("GET", "missing")); err != redis.ErrNil {
+		t.Errorf("GET of a missing key returned %v, expected nil", err)
+	}
+
+	conn.Do("SET", "b", "x", "PX", 50)
+	time.Sleep(100 * time.Millisecond)
+
+	if _, err := redis.String(conn.Do("GET", "b")); err != redis.ErrNil {
+		t.Errorf("Key did not expire")
+	}
+
+	if keys, _ := redis.Strings(conn.Do("KEYS", "*")); !reflect.DeepEqual(keys, []string{"a"}) {
+		t.Errorf("KEYS returned %v, expected [a]", keys)
+	}
+
+	if _, err := conn.Do("LLEN", "a"); err == nil {
+		t.Errorf("Expected an error for a list command on a string")
+	}
+}
+
+func TestLocalRedisLists(t *testing.T) {
+	r, conn := dialLocalRedis(t)
+	defer r.Close()
+	defer conn.Close()
+
+	conn.Do("RPUSH", "q", "1", "2")
+
+	if values, _ := redis.Strings(conn.Do("LRANGE", "q", 0, -1)); !reflect.DeepEqual(values, []string{"1", "2"}) {
+		t.Errorf("LRANGE returned %v, expected [1 2]", values)
+	}
+
+	if values, _ := redis.Strings(conn.Do("BLPOP", "q", 1)); !reflect.DeepEqual(values, []string{"q", "1"}) {
+		t.Errorf("BLPOP returned %v, expected [q 1]", values)
+	}
+
+	// BLPOP waits for a push
+	go func() {
+		pusher, err := redis.DialURL(r.URL())
+
+		if err != nil {
+			return
+		}
+
+		defer pusher.Close()
+
+		for !r.Waiting("empty") {
+			time.Sleep(time.Millisecond)
+		}
+
+		pusher.Do("RPUSH", "empty", "x")
+	}()
+
+	if values, _ := redis.Strings(conn.Do("BLPOP", "empty", 5)); !reflect.DeepEqual(values, []string{"empty", "x"}) {
+		t.Errorf("BLPOP returned %v, expected [empty x]", values)
+	}
+
+	start := time.Now()
+
+	if _, err := redis.Strings(conn.Do("BLPOP", "empty", "0.1")); err != redis.ErrNil {
+		t.Errorf("BLPOP of an empty list returned %v, expected nil", err)
+	}
+
+	if time.Since(start) < 100*time.Millisecond {
+		t.Errorf("BLPOP returned before its timeout")
+	}
+}
+
+func TestLocalRedisTransactions(t *testing.T) {
+	r, conn := dialLocalRedis(t)
+	defer r.Close()
+	defer conn.Close()
+
+	conn.Do("ZADD", "z", 2, "b", 1, "a", 3, "c")
+
+	if members, _ := redis.Strings(conn.Do("ZRANGEBYSCORE", "z", 0, 2, "LIMIT", 0, 1)); !reflect.DeepEqual(members, []string{"a"}) {
+		t.Errorf("ZRANGEBYSCORE returned %v, expected [a]", members)
+	}
+
+	// The transaction is aborted once a watched key changed
+	other, err := redis.DialURL(r.URL())
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer other.Close()
+
+	conn.Do("WATCH", "z")
+	other.Do("ZREM", "z", "a")
+	conn.Send("MULTI")
+	conn.Send("ZREM", "z", "b")
+
+	if reply, err := conn.Do("EXEC"); reply != nil || err != nil {
+		t.Errorf("EXEC returned %v, %v, expected an aborted transaction", reply, err)
+	}
+
+	conn.Do("WATCH", "z")
+	conn.Send("MULTI")
+	conn.Send("ZREM", "z", "b")
+
+	if replies, err := redis.Ints(conn.Do("EXEC")); err != nil || !reflect.DeepEqual(replies, []int{1}) {
+		t.Errorf("EXEC returned %v, %v, expected [1]", replies, err)
+	}
+
+	if n, _ := redis.Int(conn.Do("ZCARD", "z")); n != 1 {
+		t.Errorf("ZCARD returned %d, expected 1", n)
+	}
+}
+
+func TestLocalRedisStore(t *testing.T) {
+	r, err := ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	store := NewRedisStore("tcp", r.listener.Addr().String(), "", 0)
+
+	if stored, err := store.SetNX("lock", []byte("1"), 10); err != nil || !stored {
+		t.Errorf("SetNX returned %v, %v, expected the key to be stored", stored, err)
+	}
+
+	if stored, _ := store.SetNX("lock", []byte("2"), 10); stored {
+		t.Errorf("SetNX replaced an existing key")
+	}
+
+	if _, err := store.Get("missing"); err != ErrNotFound {
+		t.Errorf("Get of a missing key returned %v, expected ErrNotFound", err)
+	}
+
+	r.mu.Lock()
+	r.do("RPUSH", [][]byte{[]byte("queue"), []byte("task")})
+	r.do("ZADD", [][]byte{[]byte("delayed"), []byte("1"), []byte("task")})
+	r.mu.Unlock()
+
+	for _, queue := range []string{"queue", "delayed", "empty"} {
+		want := 1
+
+		if queue == "empty" {
+			want = 0
+		}
+
+		if n, err := store.QueueLen(queue); err != nil || n != want {
+			t.Errorf("QueueLen(%s) returned %d, %v, expected %d", queue, n, err, want)
+		}
+	}
+}
+
+func TestRedisStoreUpdate(t *testing.T) {
+	r, err := ListenLocalRedis("127.0.0.1:0")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer r.Close()
+
+	store := NewRedisStore("tcp", r.listener.Addr().String(), "", 0)
+
+	// Concurrent increments are all kept, updates of a key written
+	// meanwhile run again
+	var wg sync.WaitGroup
+
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			err := store.Update("counter", func(value []byte) ([]byte, error) {
+				n, _ := strconv.Atoi(string(value))
+				time.Sleep(time.Millisecond)
+
+				return []byte(strconv.Itoa(n + 1)), nil
+			}, 0)
+
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+
+	wg.Wait()
+
+	if value, _ := store.Get("counter"); string(value) != "20" {
+		t.Errorf("Counter %s, expected 20", value)
+	}
+
+	failed := errors.New("failed")
+
+	if err := store.Update("counter", func([]byte) ([]byte, error) { return nil, failed }, 0); err != failed {
+		t.Errorf("Update returned %v, expected the error of the update", err)
+	}
+
+	if value, _ := store.Get("counter"); string(value) != "20" {
+		t.Errorf("Counter %s after a failed update, expected 20", value)
+	}
+}
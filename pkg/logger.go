@@ -0,0 +1,360 @@
+package benchdrilltasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/log"
+)
+
+// LogLevel is the severity of a log line
+type LogLevel int
+
+// Log levels, lines below the level of the logger are dropped
+const (
+	LevelDebug LogLevel = iota
+	LevelInfo
+	LevelWarning
+	LevelError
+)
+
+var levelNames = []string{"debug", "info", "warning", "error"}
+
+// Fields of the log lines about a task, with which the logs of the client
+// and of the workers can be joined
+const (
+	LogTask   = "task"
+	LogGroup  = "group"
+	LogWorker = "worker"
+)
+
+// Log formats
+const (
+	LogText = "text"
+	LogJSON = "json"
+)
+
+// UUIDs of the tasks and of the groups, as generated by machinery
+var (
+	taskUUIDRegexp  = regexp.MustCompile(`task_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+	groupUUIDRegexp = regexp.MustCompile(`group_[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}`)
+)
+
+// Fields are the key-value pairs of a log line
+type Fields map[string]interface{}
+
+// Logger writes log lines as text or JSON, with fields such as the UUIDs of
+// the task and of the group they are about. Info and warning lines go to
+// out, error lines to errOut
+type Logger struct {
+	sink   *logSink
+	fields Fields
+}
+
+// logSink is shared by a logger and the loggers derived from it
+type logSink struct {
+	mu          sync.Mutex
+	out, errOut io.Writer
+	format      string
+	level       LogLevel
+	// Fields of the tasks being processed, added to the lines of machinery
+	// which mention them
+	tasks map[string]Fields
+}
+
+// Log is the logger of Benchdrill, and of machinery
+var Log = NewLogger(nil, nil)
+
+func init() {
+	SetLogger(Log)
+}
+
+// NewLogger creates Logger instance writing text at the info level, to the
+// standard output and error if the writers are nil
+func NewLogger(out, errOut io.Writer) *Logger {
+	if out == nil {
+		out = stdWriter{false}
+	}
+
+	if errOut == nil {
+		errOut = stdWriter{true}
+	}
+
+	return &Logger{
+		sink: &logSink{
+			out:    out,
+			errOut: errOut,
+			format: LogText,
+			level:  LevelInfo,
+			tasks:  make(map[string]Fields),
+		},
+	}
+}
+
+// stdWriter writes to the current standard output or error, which may be
+// redirected after the logger is created
+type stdWriter struct{ stderr bool }
+
+func (w stdWriter) Write(p []byte) (int, error) {
+	if w.stderr {
+		return os.Stderr.Write(p)
+	}
+
+	return os.Stdout.Write(p)
+}
+
+// ParseLogLevel parses a level: debug, info, warning or error
+func ParseLogLevel(name string) (LogLevel, error) {
+	for level, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return LogLevel(level), nil
+		}
+	}
+
+	return LevelInfo, fmt.Errorf("Invalid log level %q: expected debug, info, warning or error", name)
+}
+
+// SetLogger replaces Log and the loggers of machinery, whose lines then have
+// the same format
+func SetLogger(l *Logger) {
+	Log = l
+	log.INFO = &machineryLogger{l, LevelInfo}
+	log.WARNING = &machineryLogger{l, LevelWarning}
+	log.ERROR = &machineryLogger{l, LevelError}
+	log.FATAL = &machineryLogger{l, LevelError}
+}
+
+// Configure sets the format, text or json, and the level of the logger and
+// of the loggers sharing its output
+func (l *Logger) Configure(format string, level LogLevel) error {
+	if format != LogText && format != LogJSON {
+		return fmt.Errorf("Invalid log format %q: expected text or json", format)
+	}
+
+	l.sink.mu.Lock()
+	l.sink.format, l.sink.level = format, level
+	l.sink.mu.Unlock()
+
+	return nil
+}
+
+// With returns a logger adding fields to the lines, empty values are left
+// out
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+
+	for key, value := range l.fields {
+		merged[key] = value
+	}
+
+	for key, value := range fields {
+		if value != "" && value != nil {
+			merged[key] = value
+		}
+	}
+
+	return &Logger{sink: l.sink, fields: merged}
+}
+
+// Task returns a logger for the lines about a task of a group
+func (l *Logger) Task(taskUUID, groupUUID string) *Logger {
+	return l.With(Fields{LogTask: taskUUID, LogGroup: groupUUID})
+}
+
+// Group returns a logger for the lines about a group
+func (l *Logger) Group(groupUUID string) *Logger {
+	return l.With(Fields{LogGroup: groupUUID})
+}
+
+// Track adds the fields of the logger to the lines of machinery mentioning a
+// task, until the returned function is called
+func (l *Logger) Track(taskUUID string) func() {
+	l.sink.mu.Lock()
+	l.sink.tasks[taskUUID] = l.fields
+	l.sink.mu.Unlock()
+
+	return func() {
+		l.sink.mu.Lock()
+		delete(l.sink.tasks, taskUUID)
+		l.sink.mu.Unlock()
+	}
+}
+
+// Info logs a message at the info level
+func (l *Logger) Info(message string) {
+	l.write(LevelInfo, message)
+}
+
+// Warning logs a message at the warning level
+func (l *Logger) Warning(message string) {
+	l.write(LevelWarning, message)
+}
+
+// Error logs a message at the error level
+func (l *Logger) Error(message string) {
+	l.write(LevelError, message)
+}
+
+// Debugf logs a line at the debug level
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.write(LevelDebug, fmt.Sprintf(format, args...))
+}
+
+// Infof logs a line at the info level
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.write(LevelInfo, fmt.Sprintf(format, args...))
+}
+
+// Warningf logs a line at the warning level
+func (l *Logger) Warningf(format string, args ...interface{}) {
+	l.write(LevelWarning, fmt.Sprintf(format, args...))
+}
+
+// Errorf logs a line at the error level
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.write(LevelError, fmt.Sprintf(format, args...))
+}
+
+func (l *Logger) write(level LogLevel, message string) {
+	// Outputs end with a newline, the fields follow on the same line
+	message = strings.TrimRight(message, "\n")
+
+	l.sink.mu.Lock()
+	defer l.sink.mu.Unlock()
+
+	if level < l.sink.level {
+		return
+	}
+
+	var keys []string
+
+	for key := range l.fields {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var line string
+
+	if l.sink.format == LogJSON {
+		line = l.jsonLine(level, message, keys)
+	} else {
+		line = l.textLine(level, message, keys)
+	}
+
+	out := l.sink.out
+
+	if level >= LevelError {
+		out = l.sink.errOut
+	}
+
+	io.WriteString(out, line+"\n")
+}
+
+// textLine formats a line as "2006/01/02 15:04:05 LEVEL message key=value"
+func (l *Logger) textLine(level LogLevel, message string, keys []string) string {
+	line := fmt.Sprintf("%s %s %s", time.Now().Format("2006/01/02 15:04:05"), strings.ToUpper(levelNames[level]), message)
+
+	for _, key := range keys {
+		value := fmt.Sprint(l.fields[key])
+
+		if value == "" || strings.ContainsAny(value, " \t\n\"=") {
+			value = fmt.Sprintf("%q", value)
+		}
+
+		line += " " + key + "=" + value
+	}
+
+	return line
+}
+
+// jsonLine formats a line as an object with the time, level, message and
+// fields
+func (l *Logger) jsonLine(level LogLevel, message string, keys []string) string {
+	line := fmt.Sprintf(`{"time":%q,"level":%q,"msg":%s`, time.Now().UTC().Format(time.RFC3339Nano), levelNames[level], jsonValue(message))
+
+	for _, key := range keys {
+		line += fmt.Sprintf(",%s:%s", jsonValue(key), jsonValue(l.fields[key]))
+	}
+
+	return line + "}"
+}
+
+func jsonValue(value interface{}) string {
+	data, err := json.Marshal(value)
+
+	if err != nil {
+		data, _ = json.Marshal(fmt.Sprint(value))
+	}
+
+	return string(data)
+}
+
+// machineryLogger writes the lines of machinery, with the fields of the
+// task they mention if it is tracked, else with its UUID and the UUID of
+// the group they mention
+type machineryLogger struct {
+	logger *Logger
+	level  LogLevel
+}
+
+func (m *machineryLogger) print(message string) {
+	l := m.logger
+
+	if taskUUID := taskUUIDRegexp.FindString(message); taskUUID != "" {
+		m.logger.sink.mu.Lock()
+		fields, tracked := m.logger.sink.tasks[taskUUID]
+		m.logger.sink.mu.Unlock()
+
+		if tracked {
+			l = l.With(fields)
+		} else {
+			l = l.Task(taskUUID, groupUUIDRegexp.FindString(message))
+		}
+	}
+
+	l.write(m.level, message)
+}
+
+func (m *machineryLogger) Print(v ...interface{}) { m.print(fmt.Sprint(v...)) }
+
+func (m *machineryLogger) Printf(format string, v ...interface{}) { m.print(fmt.Sprintf(format, v...)) }
+
+func (m *machineryLogger) Println(v ...interface{}) { m.print(fmt.Sprintln(v...)) }
+
+func (m *machineryLogger) Fatal(v ...interface{}) {
+	m.Print(v...)
+	os.Exit(1)
+}
+
+func (m *machineryLogger) Fatalf(format string, v ...interface{}) {
+	m.Printf(format, v...)
+	os.Exit(1)
+}
+
+func (m *machineryLogger) Fatalln(v ...interface{}) {
+	m.Println(v...)
+	os.Exit(1)
+}
+
+func (m *machineryLogger) Panic(v ...interface{}) {
+	m.Print(v...)
+	panic(fmt.Sprint(v...))
+}
+
+func (m *machineryLogger) Panicf(format string, v ...interface{}) {
+	m.Printf(format, v...)
+	panic(fmt.Sprintf(format, v...))
+}
+
+func (m *machineryLogger) Panicln(v ...interface{}) {
+	m.Println(v...)
+	panic(fmt.Sprintln(v...))
+}
@@ -0,0 +1,99 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/RichardKnop/machinery/v1/log"
+)
+
+func TestLoggerText(t *testing.T) {
+	var out, errOut bytes.Buffer
+
+	l := NewLogger(&out, &errOut)
+	l.Debugf("Not logged at the info level")
+	l.Task("task_1", "group_1").Infof("Output:\n%s", "a b\n")
+	l.With(Fields{LogWorker: "w 1"}).Error("Failed")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != 2 || !strings.HasSuffix(lines[0], " INFO Output:") || lines[1] != "a b group=group_1 task=task_1" {
+		t.Errorf("Logged:\n%s\nexpected the output followed by its fields", out.String())
+	}
+
+	if !strings.HasSuffix(errOut.String(), ` ERROR Failed worker="w 1"`+"\n") {
+		t.Errorf("Logged %q to errOut, expected the error with the quoted worker", errOut.String())
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	var out bytes.Buffer
+
+	l := NewLogger(&out, &out)
+
+	if err := l.Configure(LogJSON, LevelWarning); err != nil {
+		t.Fatal(err)
+	}
+
+	l.Infof("Not logged at the warning level")
+	l.Task("task_1", "").Warningf("Retrying %q", "cmd")
+
+	var line map[string]string
+
+	if err := json.Unmarshal(out.Bytes(), &line); err != nil {
+		t.Fatalf("Invalid JSON %q: %s", out.String(), err.Error())
+	}
+
+	if line["level"] != "warning" || line["msg"] != `Retrying "cmd"` || line[LogTask] != "task_1" || line["time"] == "" {
+		t.Errorf("Logged %v, expected the warning about task_1", line)
+	}
+
+	if _, ok := line[LogGroup]; ok {
+		t.Error("Expected no group, its UUID is empty")
+	}
+
+	if err := l.Configure("xml", LevelInfo); err == nil {
+		t.Error("Expected an error for an unknown format")
+	}
+
+	if _, err := ParseLogLevel("verbose"); err == nil {
+		t.Error("Expected an error for an unknown level")
+	}
+}
+
+func TestLoggerMachinery(t *testing.T) {
+	var out bytes.Buffer
+
+	previous := Log
+	defer SetLogger(previous)
+
+	SetLogger(NewLogger(&out, &out))
+
+	const taskUUID = "task_0b8f2c1e-3a4d-4e5f-8a9b-0c1d2e3f4a5b"
+	const groupUUID = "group_1c9a3d2f-4b5e-4f60-9bac-1d2e3f4a5b6c"
+
+	untrack := Log.With(Fields{LogWorker: "w1"}).Task(taskUUID, groupUUID).Track(taskUUID)
+	log.INFO.Printf("Processed task %s. Results = [ok]", taskUUID)
+	untrack()
+
+	log.INFO.Printf("Received new message: {\"UUID\":%q,\"GroupUUID\":%q}", "task_2d0b4e3a-5c6f-4a71-8cbd-2e3f4a5b6c7d", groupUUID)
+	log.WARNING.Print("[*] Waiting for messages")
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+
+	if len(lines) != 3 {
+		t.Fatalf("Logged:\n%s\nexpected 3 lines", out.String())
+	}
+
+	for i, want := range []string{
+		"group=" + groupUUID + " task=" + taskUUID + " worker=w1",
+		"group=" + groupUUID + " task=task_2d0b4e3a-5c6f-4a71-8cbd-2e3f4a5b6c7d",
+		"WARNING [*] Waiting for messages",
+	} {
+		if !strings.HasSuffix(lines[i], want) {
+			t.Errorf("Logged %q, expected it to end with %q", lines[i], want)
+		}
+	}
+}
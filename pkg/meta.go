@@ -0,0 +1,244 @@
+package benchdrilltasks
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/config"
+)
+
+// Prefixes of the keys Benchdrill keeps in its Store
+const (
+	chordPrefix      = "benchdrill_chord_"
+	groupInfoPrefix  = "benchdrill_group_"
+	taskMetaPrefix   = "benchdrill_task_"
+	workerInfoPrefix = "benchdrill_worker_"
+	cancelPrefix     = "benchdrill_cancel_"
+)
+
+// ErrCancelled is the error of the instances of a cancelled group which had
+// not started yet
+var ErrCancelled = errors.New("Group cancelled")
+
+// ExpireIn returns for how long results are kept, in seconds, using the same
+// default as the machinery result backends
+func ExpireIn(cnf *config.Config) int {
+	if cnf.ResultsExpireIn == 0 {
+		return 3600
+	}
+
+	return cnf.ResultsExpireIn
+}
+
+// GroupInfo describes a group of instances sent by the client
+type GroupInfo struct {
+	GroupUUID string          `json:"group_uuid"`
+	TaskName  string          `json:"task_name"`
+	Command   string          `json:"command"`
+	CreatedAt time.Time       `json:"created_at"`
+	Instances []*InstanceInfo `json:"instances"`
+	// Chord callback summarizing the group on a worker, if aggregated
+	SummaryUUID string `json:"summary_uuid,omitempty"`
+	// Hash of the bundle of input files of the tasks, if any
+	Bundle string `json:"bundle,omitempty"`
+	// Labels given to the group when it was submitted through the API
+	Labels map[string]string `json:"labels,omitempty"`
+	// Trace context of the submission of the group, as a traceparent, below
+	// which the pickup of its results is traced
+	Trace string `json:"trace,omitempty"`
+}
+
+// InstanceInfo lists the tasks of an instance: a single task, or the steps
+// of a pipeline
+type InstanceInfo struct {
+	// Task whose result is the result of the instance
+	TaskUUID string      `json:"task_uuid"`
+	Steps    []*StepInfo `json:"steps,omitempty"`
+	// Workload file sent to the instance, as rendered from its template. The
+	// worker records it with the result as well, see TaskMeta
+	Workload string `json:"workload,omitempty"`
+}
+
+// StepInfo is one step of a pipeline
+type StepInfo struct {
+	Name     string `json:"name"`
+	TaskUUID string `json:"task_uuid"`
+}
+
+// IsPipeline returns true if the instances of the group are pipelines
+func (g *GroupInfo) IsPipeline() bool {
+	return len(g.Instances) > 0 && len(g.Instances[0].Steps) > 0
+}
+
+// TaskMeta records where and when a task instance ran (its last attempt)
+type TaskMeta struct {
+	TaskUUID   string    `json:"task_uuid"`
+	GroupUUID  string    `json:"group_uuid"`
+	Worker     string    `json:"worker"`
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	// Number of times the task has been run, more than 1 if it was retried
+	Attempts int `json:"attempts"`
+	// Workload file the task ran, kept along with its result
+	Workload string `json:"workload,omitempty"`
+}
+
+// Flaky returns true if the task had to be retried
+func (m *TaskMeta) Flaky() bool {
+	return m.Attempts > 1
+}
+
+// Elapsed returns for how long the task has been (or was) running
+func (m *TaskMeta) Elapsed() time.Duration {
+	if m.StartedAt.IsZero() {
+		return 0
+	}
+
+	if m.FinishedAt.IsZero() {
+		return time.Since(m.StartedAt)
+	}
+
+	return m.FinishedAt.Sub(m.StartedAt)
+}
+
+// WorkerInfo is the heartbeat a worker periodically writes to the Store
+type WorkerInfo struct {
+	ID           string    `json:"id"`
+	Hostname     string    `json:"hostname"`
+	Queue        string    `json:"queue"`
+	PrivateQueue string    `json:"private_queue"`
+	Busy         int       `json:"busy"`
+	LastSeen     time.Time `json:"last_seen"`
+}
+
+// SaveGroupInfo stores the description of a group
+func SaveGroupInfo(store Store, info *GroupInfo, expireIn int) error {
+	return saveJSON(store, groupInfoPrefix+info.GroupUUID, info, expireIn)
+}
+
+// GetGroupInfo returns the description of a group
+func GetGroupInfo(store Store, groupUUID string) (*GroupInfo, error) {
+	info := new(GroupInfo)
+
+	return info, loadJSON(store, groupInfoPrefix+groupUUID, info)
+}
+
+// ListGroups returns the groups which have not expired yet, newest first
+func ListGroups(store Store) ([]*GroupInfo, error) {
+	keys, err := store.Keys(groupInfoPrefix + "*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var groups []*GroupInfo
+
+	for _, key := range keys {
+		info := new(GroupInfo)
+
+		if err := loadJSON(store, key, info); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+
+			return nil, err
+		}
+
+		groups = append(groups, info)
+	}
+
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].CreatedAt.After(groups[j].CreatedAt)
+	})
+
+	return groups, nil
+}
+
+// SaveTaskMeta stores the metadata of a task instance
+func SaveTaskMeta(store Store, meta *TaskMeta, expireIn int) error {
+	return saveJSON(store, taskMetaPrefix+meta.TaskUUID, meta, expireIn)
+}
+
+// GetTaskMeta returns the metadata of a task instance, ErrNotFound if the
+// task has not been picked up by a worker yet
+func GetTaskMeta(store Store, taskUUID string) (*TaskMeta, error) {
+	meta := new(TaskMeta)
+
+	return meta, loadJSON(store, taskMetaPrefix+taskUUID, meta)
+}
+
+// CancelGroup marks a group as cancelled: its instances which have not
+// started yet fail once a worker picks them up
+func CancelGroup(store Store, groupUUID string, expireIn int) error {
+	return store.Set(cancelPrefix+groupUUID, []byte(time.Now().UTC().Format(time.RFC3339)), expireIn)
+}
+
+// IsCancelled returns true if the group was cancelled
+func IsCancelled(store Store, groupUUID string) bool {
+	if groupUUID == "" {
+		return false
+	}
+
+	_, err := store.Get(cancelPrefix + groupUUID)
+
+	return err == nil
+}
+
+// SaveWorkerInfo stores the heartbeat of a worker
+func SaveWorkerInfo(store Store, info *WorkerInfo, expireIn int) error {
+	return saveJSON(store, workerInfoPrefix+info.ID, info, expireIn)
+}
+
+// DeleteWorkerInfo removes a worker which quit, before its heartbeat expires
+func DeleteWorkerInfo(store Store, id string) error {
+	return store.Del(workerInfoPrefix + id)
+}
+
+// ListWorkers returns the workers whose heartbeat has not expired yet
+func ListWorkers(store Store) ([]*WorkerInfo, error) {
+	keys, err := store.Keys(workerInfoPrefix + "*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var workers []*WorkerInfo
+
+	for _, key := range keys {
+		info := new(WorkerInfo)
+
+		if err := loadJSON(store, key, info); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+
+			return nil, err
+		}
+
+		workers = append(workers, info)
+	}
+
+	return workers, nil
+}
+
+func saveJSON(store Store, key string, value interface{}, expireIn int) error {
+	encoded, err := json.Marshal(value)
+
+	if err != nil {
+		return err
+	}
+
+	return store.Set(key, encoded, expireIn)
+}
+
+func loadJSON(store Store, key string, value interface{}) error {
+	data, err := store.Get(key)
+
+	if err != nil {
+		return err
+	}
+
+	return json.Unmarshal(data, value)
+}
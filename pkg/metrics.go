@@ -0,0 +1,89 @@
+package benchdrilltasks
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var (
+	// A number, possibly followed by a unit, e.g. "10.0004s" or "19.29"
+	numberRegexp = regexp.MustCompile(`^[-+]?[0-9]*\.?[0-9]+([eE][-+]?[0-9]+)?`)
+	// Summary line of Filebench, e.g.
+	// "5.001: IO Summary: 28851 ops 5768.953 ops/s 1923/0 rd/wr 30.1mb/s 0.502ms/op"
+	filebenchSummaryRegexp = regexp.MustCompile(`IO Summary:\s+([0-9.]+) ops\s+([0-9.]+) ops/s\s+([0-9.]+)/([0-9.]+) rd/wr\s+([0-9.]+)mb/s\s+([0-9.]+)ms/op`)
+	nonAlnumRegexp         = regexp.MustCompile(`[^a-z0-9]+`)
+)
+
+// Metrics maps the name of a metric to its value
+type Metrics map[string]float64
+
+// ParseMetrics extracts the numeric metrics from the output of Sysbench or
+// Filebench. Sysbench reports "name: value" lines grouped under section
+// headers, which prefix the names, e.g. "latency_ms.avg"; Filebench reports
+// an "IO Summary" line. The accounting of the cgroup of the task, if any, is a
+// "Cgroup" section
+func ParseMetrics(output string) Metrics {
+	metrics := make(Metrics)
+	match := filebenchSummaryRegexp.FindStringSubmatch(output)
+
+	if match != nil {
+		for i, name := range []string{"ops", "ops_per_s", "reads", "writes", "mb_per_s", "ms_per_op"} {
+			value, _ := strconv.ParseFloat(match[i+1], 64)
+			metrics["filebench."+name] = value
+		}
+	}
+
+	section := ""
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+		i := strings.LastIndex(trimmed, ":")
+
+		// Skip the intermediate reports, e.g. "[ 1s ] thds: 1 eps: 1234.00 …"
+		if i < 0 || strings.HasPrefix(trimmed, "[") {
+			continue
+		}
+
+		key, value := metricName(trimmed[:i]), strings.TrimSpace(trimmed[i+1:])
+
+		// Section headers are not indented and have no value
+		if value == "" {
+			if line == trimmed {
+				section = key
+			}
+
+			continue
+		}
+
+		number := numberRegexp.FindString(value)
+
+		if number == "" || key == "" {
+			continue
+		}
+
+		parsed, err := strconv.ParseFloat(number, 64)
+
+		if err != nil {
+			continue
+		}
+
+		if section != "" && line != trimmed {
+			key = section + "." + key
+		}
+
+		// Only the accounting of the cgroup follows the summary of Filebench
+		if match != nil && !strings.HasPrefix(key, "cgroup.") {
+			continue
+		}
+
+		metrics[key] = parsed
+	}
+
+	return metrics
+}
+
+// metricName normalizes a label, e.g. "Latency (ms)" becomes "latency_ms"
+func metricName(label string) string {
+	return strings.Trim(nonAlnumRegexp.ReplaceAllString(strings.ToLower(label), "_"), "_")
+}
@@ -0,0 +1,67 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"time"
+)
+
+// OutputFile is the copy of the output of a running command, written in the
+// working directory of its task so that the worker can publish its tail
+const OutputFile = ".benchdrill_output"
+
+const outputTailPrefix = "benchdrill_tail_"
+
+// Size of the tail of the output published while a task runs, and period
+const (
+	outputTailSize   = 4096
+	outputTailPeriod = 2 * time.Second
+)
+
+// SaveOutputTail stores the last lines of the output of a running task
+func SaveOutputTail(store Store, taskUUID string, tail []byte, expireIn int) error {
+	return store.Set(outputTailPrefix+taskUUID, tail, expireIn)
+}
+
+// GetOutputTail returns the last lines of the output of a running task,
+// ErrNotFound once it completed
+func GetOutputTail(store Store, taskUUID string) ([]byte, error) {
+	return store.Get(outputTailPrefix + taskUUID)
+}
+
+// readTail reads at most the last size bytes of a file, from the start of a
+// line
+func readTail(path string, size int64) ([]byte, error) {
+	f, err := os.Open(path)
+
+	if err != nil {
+		return nil, err
+	}
+
+	defer f.Close()
+
+	info, err := f.Stat()
+
+	if err != nil {
+		return nil, err
+	}
+
+	offset := info.Size() - size
+
+	if offset < 0 {
+		offset = 0
+	}
+
+	tail := make([]byte, info.Size()-offset)
+
+	if _, err := io.ReadFull(io.NewSectionReader(f, offset, int64(len(tail))), tail); err != nil {
+		return nil, err
+	}
+
+	if i := bytes.IndexByte(tail, '\n'); offset > 0 && i >= 0 {
+		tail = tail[i+1:]
+	}
+
+	return tail, nil
+}
@@ -0,0 +1,258 @@
+package benchdrilltasks
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Registry holds operational metrics (counters, gauges and histograms with
+// labels) and exposes them in the text format of Prometheus
+type Registry struct {
+	mu       sync.Mutex
+	families []*family
+	// Called before each scrape, e.g. to read gauges from the Store
+	collectors []func()
+}
+
+// family is a metric and its series, one per combination of label values
+type family struct {
+	reg              *Registry
+	name, help, kind string
+	labels           []string
+	buckets          []float64
+	series           map[string]*series
+}
+
+type series struct {
+	labelValues []string
+	value       float64
+	// Histograms only: count of observations per bucket (not cumulative)
+	counts []uint64
+	count  uint64
+}
+
+// Counter is a metric which only goes up, e.g. a number of tasks
+type Counter struct{ family *family }
+
+// Gauge is a metric which goes up and down, e.g. a number of busy tasks
+type Gauge struct{ family *family }
+
+// Histogram counts observations, e.g. durations, in buckets
+type Histogram struct{ family *family }
+
+// NewRegistry creates Registry instance
+func NewRegistry() *Registry {
+	return &Registry{}
+}
+
+func (r *Registry) register(name, help, kind string, labels []string, buckets []float64) *family {
+	f := &family{
+		reg:     r,
+		name:    name,
+		help:    help,
+		kind:    kind,
+		labels:  labels,
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	r.mu.Lock()
+	r.families = append(r.families, f)
+	r.mu.Unlock()
+
+	return f
+}
+
+// Counter registers a counter with the given label names
+func (r *Registry) Counter(name, help string, labels ...string) *Counter {
+	return &Counter{r.register(name, help, "counter", labels, nil)}
+}
+
+// Gauge registers a gauge with the given label names
+func (r *Registry) Gauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{r.register(name, help, "gauge", labels, nil)}
+}
+
+// Histogram registers a histogram with the given upper bounds of its
+// buckets, sorted, and label names
+func (r *Registry) Histogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{r.register(name, help, "histogram", labels, buckets)}
+}
+
+// OnScrape adds a function called before the metrics are written
+func (r *Registry) OnScrape(collect func()) {
+	r.mu.Lock()
+	r.collectors = append(r.collectors, collect)
+	r.mu.Unlock()
+}
+
+// with returns the series of the label values, created if needed. The lock
+// of the registry must be held
+func (f *family) with(labelValues []string) *series {
+	key := strings.Join(labelValues, "\xff")
+	s := f.series[key]
+
+	if s == nil {
+		s = &series{labelValues: labelValues}
+
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+
+		f.series[key] = s
+	}
+
+	return s
+}
+
+// Add adds a value to the counter of the label values
+func (c *Counter) Add(value float64, labelValues ...string) {
+	c.family.reg.mu.Lock()
+	c.family.with(labelValues).value += value
+	c.family.reg.mu.Unlock()
+}
+
+// Inc increments the counter of the label values
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Set sets the gauge of the label values
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.family.reg.mu.Lock()
+	g.family.with(labelValues).value = value
+	g.family.reg.mu.Unlock()
+}
+
+// Reset removes all the series of the gauge, e.g. before it is set again
+// for the workers alive
+func (g *Gauge) Reset() {
+	g.family.reg.mu.Lock()
+	g.family.series = make(map[string]*series)
+	g.family.reg.mu.Unlock()
+}
+
+// Observe adds an observation to the histogram of the label values
+func (h *Histogram) Observe(value float64, labelValues ...string) {
+	h.family.reg.mu.Lock()
+	defer h.family.reg.mu.Unlock()
+
+	s := h.family.with(labelValues)
+	s.value += value
+	s.count++
+
+	for i, bound := range h.family.buckets {
+		if value <= bound {
+			s.counts[i]++
+			break
+		}
+	}
+}
+
+// Write writes the metrics in the text format of Prometheus
+func (r *Registry) Write(out io.Writer) error {
+	r.mu.Lock()
+	collectors := r.collectors
+	r.mu.Unlock()
+
+	for _, collect := range collectors {
+		collect()
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	w := bufio.NewWriter(out)
+
+	for _, f := range r.families {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", f.name, escapeHelp(f.help), f.name, f.kind)
+
+		var keys []string
+
+		for key := range f.series {
+			keys = append(keys, key)
+		}
+
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			s := f.series[key]
+
+			if f.kind != "histogram" {
+				fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatValue(s.value))
+				continue
+			}
+
+			var cumulative uint64
+
+			for i, bound := range f.buckets {
+				cumulative += s.counts[i]
+				fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, formatValue(bound)), cumulative)
+			}
+
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.labelValues, "+Inf"), s.count)
+			fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelPairs(s.labelValues, ""), formatValue(s.value))
+			fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelPairs(s.labelValues, ""), s.count)
+		}
+	}
+
+	return w.Flush()
+}
+
+// ServeHTTP answers the metrics to Prometheus
+func (r *Registry) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	r.Write(w)
+}
+
+// labelPairs formats the labels of a series, with the upper bound of a
+// bucket if given
+func (f *family) labelPairs(values []string, le string) string {
+	var pairs []string
+
+	for i, name := range f.labels {
+		value := ""
+
+		if i < len(values) {
+			value = values[i]
+		}
+
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, name, escapeLabel(value)))
+	}
+
+	if le != "" {
+		pairs = append(pairs, fmt.Sprintf(`le="%s"`, le))
+	}
+
+	if len(pairs) == 0 {
+		return ""
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+func escapeHelp(help string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(help)
+}
+
+func escapeLabel(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(value)
+}
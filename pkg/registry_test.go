@@ -0,0 +1,46 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRegistry(t *testing.T) {
+	r := NewRegistry()
+	tasksTotal := r.Counter("test_tasks_total", "Tasks", "task")
+	busy := r.Gauge("test_busy", "Busy tasks")
+	duration := r.Histogram("test_duration_seconds", "Durations", []float64{1, 10}, "task")
+
+	tasksTotal.Inc("b")
+	tasksTotal.Add(2, `a"\`)
+	r.OnScrape(func() { busy.Set(3) })
+	duration.Observe(0.5, "a")
+	duration.Observe(5, "a")
+	duration.Observe(50, "a")
+
+	var buf bytes.Buffer
+
+	if err := r.Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `# HELP test_tasks_total Tasks
+# TYPE test_tasks_total counter
+test_tasks_total{task="a\"\\"} 2
+test_tasks_total{task="b"} 1
+# HELP test_busy Busy tasks
+# TYPE test_busy gauge
+test_busy 3
+# HELP test_duration_seconds Durations
+# TYPE test_duration_seconds histogram
+test_duration_seconds_bucket{task="a",le="1"} 1
+test_duration_seconds_bucket{task="a",le="10"} 2
+test_duration_seconds_bucket{task="a",le="+Inf"} 3
+test_duration_seconds_sum{task="a"} 55.5
+test_duration_seconds_count{task="a"} 3
+`
+
+	if buf.String() != want {
+		t.Errorf("Metrics:\n%s\nexpected:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,181 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/brokers"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+const (
+	// Retries of pinned tasks waiting to be sent, by task
+	pinnedRetryPrefix = "benchdrill_retry_"
+	// Set by the worker sending a retry, so that it is sent once
+	retryClaimPrefix = "benchdrill_retryclaim_"
+)
+
+// retryClaim returns the key claiming a retry, each retry of a task has its
+// own
+func retryClaim(signature *tasks.Signature) string {
+	return fmt.Sprintf("%s%s_%d", retryClaimPrefix, signature.UUID, signature.RetryCount)
+}
+
+// pinnedBroker keeps the retries of the tasks pinned to a worker on that
+// worker. Retries get an ETA, which the broker turns into an entry of the
+// delayed tasks shared by all the workers, any of which could run them
+// without the working directory of the pipeline. They are published to the
+// private queue once due instead, and kept in the Store meanwhile so that
+// another worker sends them if this one stops (see adoptRetries)
+type pinnedBroker struct {
+	brokers.Interface
+	// Prefix of the private queues of the workers
+	prefix   string
+	store    Store
+	expireIn int
+	// Retries waiting to be sent, by task
+	timers map[string]*time.Timer
+	mu     sync.Mutex
+}
+
+// pinBroker wraps the broker of a server, once
+func pinBroker(server *machinery.Server, store Store) {
+	if _, pinned := server.GetBroker().(*pinnedBroker); !pinned {
+		server.SetBroker(&pinnedBroker{
+			Interface: server.GetBroker(),
+			prefix:    server.GetConfig().DefaultQueue + "_",
+			store:     store,
+			expireIn:  ExpireIn(server.GetConfig()),
+			timers:    make(map[string]*time.Timer),
+		})
+	}
+}
+
+// Publish delays the pinned tasks in the worker
+func (b *pinnedBroker) Publish(signature *tasks.Signature) error {
+	if signature.ETA == nil || !strings.HasPrefix(signature.RoutingKey, b.prefix) {
+		return b.Interface.Publish(signature)
+	}
+
+	delay := time.Until(*signature.ETA)
+
+	if delay <= 0 {
+		return b.Interface.Publish(signature)
+	}
+
+	// Kept until the results expire, a retry sent later is of no use
+	expireIn := b.expireIn
+
+	if expireIn > 0 {
+		expireIn += int(delay / time.Second)
+	}
+
+	if err := saveJSON(b.store, pinnedRetryPrefix+signature.UUID, signature, expireIn); err != nil {
+		return err
+	}
+
+	b.mu.Lock()
+	b.timers[signature.UUID] = time.AfterFunc(delay, func() {
+		b.mu.Lock()
+		delete(b.timers, signature.UUID)
+		b.mu.Unlock()
+
+		b.send(signature)
+	})
+	b.mu.Unlock()
+
+	return nil
+}
+
+// send publishes a retry which is due, unless another worker took it over
+func (b *pinnedBroker) send(signature *tasks.Signature) {
+	taskLog := Log.Task(signature.UUID, taskGroup(signature))
+
+	if claimed, err := b.store.SetNX(retryClaim(signature), []byte(signature.RoutingKey), b.expireIn); err != nil || !claimed {
+		return
+	}
+
+	if err := b.Interface.Publish(signature); err != nil {
+		taskLog.Errorf("Could not send the retry of task %s: %s", signature.UUID, err.Error())
+		b.store.Del(retryClaim(signature))
+		return
+	}
+
+	if err := b.store.Del(pinnedRetryPrefix + signature.UUID); err != nil {
+		taskLog.Warningf("Could not remove the retry of task %s: %s", signature.UUID, err.Error())
+	}
+}
+
+// stop drops the retries waiting to be sent, the workers still running take
+// them over from the Store
+func (b *pinnedBroker) stop() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for uuid, timer := range b.timers {
+		timer.Stop()
+		delete(b.timers, uuid)
+	}
+}
+
+// adoptRetries sends the retries of the pinned tasks whose worker stopped
+// before sending them, which would otherwise stay in RETRY. The working
+// directory of their pipeline is lost with the worker: they are sent to the
+// default queue, and the worker running them runs the following steps too
+func (w *Worker) adoptRetries() {
+	keys, err := w.store.Keys(pinnedRetryPrefix + "*")
+
+	if err != nil || len(keys) == 0 {
+		return
+	}
+
+	workers, err := ListWorkers(w.store)
+
+	if err != nil {
+		w.log().Warningf("Could not list workers: %s", err.Error())
+		return
+	}
+
+	alive := make(map[string]bool)
+
+	for _, info := range workers {
+		alive[info.PrivateQueue] = true
+	}
+
+	alive[w.PrivateQueue()] = true
+
+	for _, key := range keys {
+		signature := new(tasks.Signature)
+
+		if err := loadJSON(w.store, key, signature); err != nil || alive[signature.RoutingKey] {
+			continue
+		}
+
+		claimed, err := w.store.SetNX(retryClaim(signature), []byte(w.PrivateQueue()), ExpireIn(w.server.GetConfig()))
+
+		if err != nil || !claimed {
+			continue
+		}
+
+		taskLog := w.taskLog(signature)
+		taskLog.Warningf("The worker of task %s stopped before retrying it, the retry is sent to any worker", signature.UUID)
+
+		if signature.Headers == nil {
+			signature.Headers = make(tasks.Headers)
+		}
+
+		signature.RoutingKey = ""
+		signature.Headers[PinHeader] = true
+
+		if _, err := w.server.SendTask(signature); err != nil {
+			taskLog.Errorf("Could not send the retry of task %s: %s", signature.UUID, err.Error())
+			w.store.Del(retryClaim(signature))
+			continue
+		}
+
+		w.store.Del(key)
+	}
+}
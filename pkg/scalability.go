@@ -0,0 +1,92 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+)
+
+// ScalabilityPoint is the aggregate throughput measured at a level of
+// concurrency (parallel instances, or replicas of the workers)
+type ScalabilityPoint struct {
+	Level int
+	// Throughput of the repetitions of the level
+	Throughput *Aggregate
+	// Mean throughput divided by the level
+	PerInstance float64
+	// Mean throughput relative to the one of the first level
+	Speedup float64
+	// Speedup relative to the ideal, linear one
+	Efficiency float64
+	// Efficiency below the minimum: the throughput per instance degraded
+	Degraded bool
+}
+
+// ParseLevels parses the levels of a scalability study, as the values of a
+// sweep parameter (e.g. "1,2,4,8" or "1..8"), sorted
+func ParseLevels(spec string) ([]int, error) {
+	param, err := ParseParam("levels=" + spec)
+
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[int]bool)
+
+	var levels []int
+
+	for _, value := range param.Values {
+		level, err := strconv.Atoi(value)
+
+		if err != nil || level < 1 {
+			return nil, fmt.Errorf("Invalid level %q: expected a positive integer", value)
+		}
+
+		if !seen[level] {
+			seen[level] = true
+			levels = append(levels, level)
+		}
+	}
+
+	sort.Ints(levels)
+
+	return levels, nil
+}
+
+// Scalability computes the speedup and efficiency curves of the throughputs
+// of the repetitions at each level, relative to the first level. The points
+// whose efficiency is below minEfficiency are marked degraded
+func Scalability(levels []int, throughputs [][]float64, minEfficiency float64) []*ScalabilityPoint {
+	points := make([]*ScalabilityPoint, len(levels))
+
+	for i, level := range levels {
+		p := &ScalabilityPoint{
+			Level:      level,
+			Throughput: NewAggregate(throughputs[i]),
+		}
+
+		p.PerInstance = p.Throughput.Mean / float64(level)
+		points[i] = p
+
+		// Nothing can be said without a throughput at the first level
+		if base := points[0]; base.Throughput.Mean > 0 {
+			p.Speedup = p.Throughput.Mean / base.Throughput.Mean
+			p.Efficiency = p.Speedup * float64(base.Level) / float64(level)
+			p.Degraded = p.Throughput.Count > 0 && p.Efficiency < minEfficiency
+		}
+	}
+
+	return points
+}
+
+// DegradationPoint returns the first point whose throughput per instance
+// degraded, nil if it scales over all the levels
+func DegradationPoint(points []*ScalabilityPoint) *ScalabilityPoint {
+	for _, p := range points {
+		if p.Degraded {
+			return p
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,66 @@
+package benchdrilltasks
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("8,1..2,4,2")
+
+	if err != nil || !reflect.DeepEqual(levels, []int{1, 2, 4, 8}) {
+		t.Errorf("Parsed %v (%v), expected [1 2 4 8]", levels, err)
+	}
+
+	for _, invalid := range []string{"", "0,1", "two", "1..x"} {
+		if _, err := ParseLevels(invalid); err == nil {
+			t.Errorf("Expected an error for levels %q", invalid)
+		}
+	}
+}
+
+func TestScalability(t *testing.T) {
+	points := Scalability([]int{1, 2, 4, 8}, [][]float64{
+		{100, 100},
+		{196, 204},
+		{360, 360},
+		{400, 440},
+	}, 0.8)
+
+	expected := []struct {
+		perInstance, speedup, efficiency float64
+		degraded                         bool
+	}{
+		{100, 1, 1, false},
+		{100, 2, 1, false},
+		{90, 3.6, 0.9, false},
+		{52.5, 4.2, 0.525, true},
+	}
+
+	for i, e := range expected {
+		p := points[i]
+
+		if math.Abs(p.PerInstance-e.perInstance) > 1e-9 || math.Abs(p.Speedup-e.speedup) > 1e-9 || math.Abs(p.Efficiency-e.efficiency) > 1e-9 || p.Degraded != e.degraded {
+			t.Errorf("Level %d: per instance %v, speedup %v, efficiency %v, degraded %v, expected %+v", p.Level, p.PerInstance, p.Speedup, p.Efficiency, p.Degraded, e)
+		}
+	}
+
+	if p := DegradationPoint(points); p == nil || p.Level != 8 {
+		t.Errorf("Degradation at %v, expected level 8", p)
+	}
+
+	// Relative to the first level, whatever it is
+	points = Scalability([]int{2, 4}, [][]float64{{200}, {300}}, 0.8)
+
+	if points[1].Speedup != 1.5 || points[1].Efficiency != 0.75 || !points[1].Degraded {
+		t.Errorf("Got speedup %v and efficiency %v, expected 1.5 and 0.75", points[1].Speedup, points[1].Efficiency)
+	}
+
+	// Nothing is flagged without a throughput at the first level
+	points = Scalability([]int{1, 2}, [][]float64{nil, {100}}, 0.8)
+
+	if DegradationPoint(points) != nil || points[1].Speedup != 0 {
+		t.Errorf("Expected no speedup without a base throughput")
+	}
+}
@@ -0,0 +1,149 @@
+package benchdrilltasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+)
+
+// Policies for a run of a schedule which is due while its previous run has
+// not completed
+const (
+	OverlapSkip  = "skip"  // the run is skipped
+	OverlapQueue = "queue" // the run is submitted once the previous one completes
+	OverlapAllow = "allow" // the run is submitted anyway
+)
+
+const (
+	schedulePrefix      = "benchdrill_schedule_"
+	scheduleStatePrefix = "benchdrill_schedulestate_"
+	scheduleRunPrefix   = "benchdrill_schedulerun_"
+)
+
+// ScheduleLabel is the label of the groups submitted by a schedule, whose
+// value is the ID of the schedule
+const ScheduleLabel = "schedule"
+
+// Schedule is a job submitted again and again, as given by a cron
+// expression. Schedules are kept until removed
+type Schedule struct {
+	ID   string `json:"id"`
+	Cron string `json:"cron"`
+	// Time zone of the cron expression, the one of the scheduler if empty
+	Timezone string `json:"timezone,omitempty"`
+	Overlap  string `json:"overlap"`
+	// Job submitted on each run, as the body of POST /jobs of serve
+	Job json.RawMessage `json:"job"`
+	// Bundle of input files of the job, if any
+	Bundle      []byte    `json:"bundle,omitempty"`
+	BundleHash  string    `json:"bundle_hash,omitempty"`
+	BundleFiles int       `json:"bundle_files,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ScheduleState records the runs of a schedule, written by the schedulers
+type ScheduleState struct {
+	// Last activation of the cron expression handled
+	LastDue   time.Time `json:"last_due"`
+	LastRun   time.Time `json:"last_run,omitempty"`
+	LastGroup string    `json:"last_group,omitempty"`
+	// A run waits for the previous one to complete, with the queue policy
+	Pending   bool   `json:"pending,omitempty"`
+	Skipped   int    `json:"skipped,omitempty"`
+	LastError string `json:"last_error,omitempty"`
+}
+
+// Location returns the time zone of the cron expression of the schedule
+func (s *Schedule) Location() (*time.Location, error) {
+	if s.Timezone == "" {
+		return time.Local, nil
+	}
+
+	return time.LoadLocation(s.Timezone)
+}
+
+// SaveSchedule stores a schedule, without expiration
+func SaveSchedule(store Store, schedule *Schedule) error {
+	return saveJSON(store, schedulePrefix+schedule.ID, schedule, 0)
+}
+
+// GetSchedule returns a schedule, ErrNotFound if it does not exist
+func GetSchedule(store Store, id string) (*Schedule, error) {
+	schedule := new(Schedule)
+
+	return schedule, loadJSON(store, schedulePrefix+id, schedule)
+}
+
+// ListSchedules returns the schedules sorted by ID
+func ListSchedules(store Store) ([]*Schedule, error) {
+	keys, err := store.Keys(schedulePrefix + "*")
+
+	if err != nil {
+		return nil, err
+	}
+
+	var schedules []*Schedule
+
+	for _, key := range keys {
+		schedule := new(Schedule)
+
+		if err := loadJSON(store, key, schedule); err != nil {
+			if err == ErrNotFound {
+				continue
+			}
+
+			return nil, err
+		}
+
+		schedules = append(schedules, schedule)
+	}
+
+	sort.Slice(schedules, func(i, j int) bool {
+		return schedules[i].ID < schedules[j].ID
+	})
+
+	return schedules, nil
+}
+
+// DeleteSchedule removes a schedule and its state
+func DeleteSchedule(store Store, id string) error {
+	if err := store.Del(schedulePrefix + id); err != nil {
+		return err
+	}
+
+	return store.Del(scheduleStatePrefix + id)
+}
+
+// UpdateScheduleState changes the state of a schedule atomically, update is
+// given the current state, a new one if the schedule was never handled
+func UpdateScheduleState(store Store, id string, update func(state *ScheduleState)) error {
+	return store.Update(scheduleStatePrefix+id, func(value []byte) ([]byte, error) {
+		state := new(ScheduleState)
+
+		if value != nil {
+			if err := json.Unmarshal(value, state); err != nil {
+				return nil, err
+			}
+		}
+
+		update(state)
+
+		return json.Marshal(state)
+	}, 0)
+}
+
+// GetScheduleState returns the state of a schedule, ErrNotFound until the
+// schedule is handled by a scheduler
+func GetScheduleState(store Store, id string) (*ScheduleState, error) {
+	state := new(ScheduleState)
+
+	return state, loadJSON(store, scheduleStatePrefix+id, state)
+}
+
+// ClaimScheduleRun returns true for the first scheduler claiming a run of a
+// schedule, so that concurrent schedulers do not submit it twice. A run is
+// identified by the activation it is due for, or the group it waited for
+func ClaimScheduleRun(store Store, id, run string) (bool, error) {
+	return store.SetNX(fmt.Sprintf("%s%s_%s", scheduleRunPrefix, id, run), []byte("1"), 24*3600)
+}
@@ -0,0 +1,306 @@
+package benchdrilltasks
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/config"
+
+	"github.com/garyburd/redigo/redis"
+)
+
+// ErrNotFound is returned by a Store when a key does not exist
+var ErrNotFound = errors.New("Key not found")
+
+// Store keeps Benchdrill's own bookkeeping (where and when tasks run, which
+// workers are alive…) next to the task states kept by the result backend
+type Store interface {
+	Get(key string) ([]byte, error)
+	Set(key string, value []byte, expireIn int) error
+	SetNX(key string, value []byte, expireIn int) (bool, error)
+	Expire(key string, expireIn int) error
+	Del(key string) error
+	Keys(pattern string) ([]string, error)
+	// Update replaces atomically the value at key by the one update returns
+	// from the current value, nil if the key does not exist
+	Update(key string, update func(value []byte) ([]byte, error), expireIn int) error
+}
+
+// RedisStore is a Store backed by the Redis instance used as result backend.
+// On the Redis instance used as broker, it reads the depths of the queues
+type RedisStore struct {
+	pool *redis.Pool
+}
+
+// ErrQueuesUnavailable is returned by NewQueues for brokers other than Redis
+var ErrQueuesUnavailable = errors.New("Queue depths are unavailable, the broker is not Redis")
+
+// errNotRedis is returned by redisStoreOf for URLs other than Redis
+var errNotRedis = errors.New("Not a Redis URL")
+
+// Queues reads the depths of the queues of the broker
+type Queues interface {
+	QueueLen(queue string) (int, error)
+}
+
+// NewStore creates the Store matching the result backend of the
+// configuration, a NopStore if it is not Redis
+func NewStore(cnf *config.Config) (Store, error) {
+	store, err := redisStoreOf(cnf.ResultBackend)
+
+	if err == errNotRedis {
+		return NopStore{}, nil
+	}
+
+	return store, err
+}
+
+// RequireStore refuses a feature built on the Store when the result backend
+// is not Redis
+func RequireStore(store Store, feature string) error {
+	if _, ok := store.(NopStore); ok {
+		return fmt.Errorf("%s requires a redis:// or redis+socket:// result backend", feature)
+	}
+
+	return nil
+}
+
+// NewQueues connects to the broker of the configuration to read the depths
+// of its queues, ErrQueuesUnavailable if it is not Redis
+func NewQueues(cnf *config.Config) (Queues, error) {
+	queues, err := redisStoreOf(cnf.Broker)
+
+	if err == errNotRedis {
+		return nil, ErrQueuesUnavailable
+	}
+
+	return queues, err
+}
+
+// redisStoreOf creates the RedisStore of a redis:// or redis+socket:// URL
+func redisStoreOf(redisURL string) (*RedisStore, error) {
+	var (
+		network, address, password string
+		db                         int
+		err                        error
+	)
+
+	switch {
+	case strings.HasPrefix(redisURL, "redis://"):
+		network = "tcp"
+		address, password, db, err = machinery.ParseRedisURL(redisURL)
+	case strings.HasPrefix(redisURL, "redis+socket://"):
+		network = "unix"
+		address, password, db, err = machinery.ParseRedisSocketURL(redisURL)
+	default:
+		return nil, errNotRedis
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	return NewRedisStore(network, address, password, db), nil
+}
+
+// NewRedisStore creates RedisStore instance
+func NewRedisStore(network, address, password string, db int) *RedisStore {
+	return &RedisStore{
+		pool: &redis.Pool{
+			MaxIdle:     3,
+			IdleTimeout: 240 * time.Second,
+			Dial: func() (redis.Conn, error) {
+				return redis.Dial(network, address, redis.DialPassword(password), redis.DialDatabase(db))
+			},
+		},
+	}
+}
+
+// Get returns the value stored at key
+func (s *RedisStore) Get(key string) ([]byte, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	value, err := redis.Bytes(conn.Do("GET", key))
+
+	if err == redis.ErrNil {
+		return nil, ErrNotFound
+	}
+
+	return value, err
+}
+
+// Set stores value at key, expiring after expireIn seconds (never if 0)
+func (s *RedisStore) Set(key string, value []byte, expireIn int) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	var err error
+
+	if expireIn > 0 {
+		_, err = conn.Do("SET", key, value, "EX", expireIn)
+	} else {
+		_, err = conn.Do("SET", key, value)
+	}
+
+	return err
+}
+
+// SetNX stores value at key only if the key does not exist yet, it returns
+// whether the value was stored
+func (s *RedisStore) SetNX(key string, value []byte, expireIn int) (bool, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	args := []interface{}{key, value, "NX"}
+
+	if expireIn > 0 {
+		args = append(args, "EX", expireIn)
+	}
+
+	reply, err := conn.Do("SET", args...)
+
+	return reply != nil, err
+}
+
+// Expire makes key expire after expireIn seconds, or never if 0. It returns
+// ErrNotFound if key does not exist, unless expireIn is 0
+func (s *RedisStore) Expire(key string, expireIn int) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	if expireIn <= 0 {
+		_, err := conn.Do("PERSIST", key)
+		return err
+	}
+
+	set, err := redis.Int(conn.Do("EXPIRE", key, expireIn))
+
+	if err == nil && set == 0 {
+		return ErrNotFound
+	}
+
+	return err
+}
+
+// Del deletes key
+func (s *RedisStore) Del(key string) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	_, err := conn.Do("DEL", key)
+
+	return err
+}
+
+// Keys returns the keys matching a glob-style pattern
+func (s *RedisStore) Keys(pattern string) ([]string, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	return redis.Strings(conn.Do("KEYS", pattern))
+}
+
+// Update watches key while update computes its new value, and calls update
+// again if key was written meanwhile
+func (s *RedisStore) Update(key string, update func(value []byte) ([]byte, error), expireIn int) error {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	for {
+		if _, err := conn.Do("WATCH", key); err != nil {
+			return err
+		}
+
+		value, err := redis.Bytes(conn.Do("GET", key))
+
+		if err == redis.ErrNil {
+			value = nil
+		} else if err != nil {
+			return err
+		}
+
+		if value, err = update(value); err != nil {
+			return err
+		}
+
+		conn.Send("MULTI")
+
+		if expireIn > 0 {
+			conn.Send("SET", key, value, "EX", expireIn)
+		} else {
+			conn.Send("SET", key, value)
+		}
+
+		reply, err := conn.Do("EXEC")
+
+		// A nil reply when the transaction was aborted
+		if err != nil || reply != nil {
+			return err
+		}
+	}
+}
+
+// QueueLen returns the number of messages waiting in a queue of a Redis
+// broker, which is a sorted set for delayed tasks
+func (s *RedisStore) QueueLen(queue string) (int, error) {
+	conn := s.pool.Get()
+	defer conn.Close()
+
+	kind, err := redis.String(conn.Do("TYPE", queue))
+
+	if err != nil {
+		return 0, err
+	}
+
+	if kind == "zset" {
+		return redis.Int(conn.Do("ZCARD", queue))
+	}
+
+	return redis.Int(conn.Do("LLEN", queue))
+}
+
+// NopStore is the Store of the result backends other than Redis, which keeps
+// nothing: tasks are sent, run and collected as before, while the features
+// built on the Store are refused by RequireStore
+type NopStore struct{}
+
+// Get finds no key
+func (NopStore) Get(key string) ([]byte, error) {
+	return nil, ErrNotFound
+}
+
+// Set keeps nothing
+func (NopStore) Set(key string, value []byte, expireIn int) error {
+	return nil
+}
+
+// SetNX keeps nothing, as if the key was set
+func (NopStore) SetNX(key string, value []byte, expireIn int) (bool, error) {
+	return true, nil
+}
+
+// Expire finds no key
+func (NopStore) Expire(key string, expireIn int) error {
+	return ErrNotFound
+}
+
+// Del deletes nothing
+func (NopStore) Del(key string) error {
+	return nil
+}
+
+// Keys finds no key
+func (NopStore) Keys(pattern string) ([]string, error) {
+	return nil, nil
+}
+
+// Update keeps nothing
+func (NopStore) Update(key string, update func(value []byte) ([]byte, error), expireIn int) error {
+	_, err := update(nil)
+
+	return err
+}
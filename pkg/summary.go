@@ -0,0 +1,136 @@
+package benchdrilltasks
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/RichardKnop/machinery/v1/backends"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// Aggregate sums up the values of a metric over the instances of a group
+type Aggregate struct {
+	Count  int     `json:"count"`
+	Mean   float64 `json:"mean"`
+	Min    float64 `json:"min"`
+	Max    float64 `json:"max"`
+	Stddev float64 `json:"stddev"`
+}
+
+// Failure is an instance which failed
+type Failure struct {
+	TaskUUID string `json:"task_uuid"`
+	Error    string `json:"error"`
+}
+
+// Summary holds the aggregate metrics of a group
+type Summary struct {
+	GroupUUID string                `json:"group_uuid"`
+	Instances int                   `json:"instances"`
+	Succeeded int                   `json:"succeeded"`
+	Failures  []*Failure            `json:"failures,omitempty"`
+	Metrics   map[string]*Aggregate `json:"metrics"`
+}
+
+// NewAggregate computes the aggregate of some values
+func NewAggregate(values []float64) *Aggregate {
+	a := &Aggregate{Count: len(values)}
+
+	if a.Count == 0 {
+		return a
+	}
+
+	a.Min, a.Max = values[0], values[0]
+	sum := 0.0
+
+	for _, value := range values {
+		sum += value
+		a.Min = math.Min(a.Min, value)
+		a.Max = math.Max(a.Max, value)
+	}
+
+	a.Mean = sum / float64(a.Count)
+
+	variance := 0.0
+
+	for _, value := range values {
+		variance += (value - a.Mean) * (value - a.Mean)
+	}
+
+	a.Stddev = math.Sqrt(variance / float64(a.Count))
+
+	return a
+}
+
+// Summarize aggregates the metrics parsed from the output of the successful
+// instances of a group
+func Summarize(groupUUID string, states []*tasks.TaskState) *Summary {
+	summary := &Summary{
+		GroupUUID: groupUUID,
+		Instances: len(states),
+		Metrics:   make(map[string]*Aggregate),
+	}
+
+	values := make(map[string][]float64)
+
+	for _, state := range states {
+		if state.IsFailure() {
+			summary.Failures = append(summary.Failures, &Failure{TaskUUID: state.TaskUUID, Error: state.Error})
+			continue
+		}
+
+		if !state.IsSuccess() {
+			continue
+		}
+
+		summary.Succeeded++
+
+		for _, result := range state.Results {
+			for name, value := range ParseMetrics(fmt.Sprint(result.Value)) {
+				values[name] = append(values[name], value)
+			}
+		}
+	}
+
+	for name, v := range values {
+		summary.Metrics[name] = NewAggregate(v)
+	}
+
+	return summary
+}
+
+// MetricNames returns the names of the metrics of the summary, sorted
+func (s *Summary) MetricNames() []string {
+	var names []string
+
+	for name := range s.Metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// NewTaskSummary returns the chord callback of aggregated groups: once the
+// group has completed, it summarizes the results of the group and returns
+// the summary as JSON, stored by the result backend as its own result
+func NewTaskSummary(backend backends.Interface) func(string) (string, error) {
+	return func(groupUUID string) (string, error) {
+		states, err := backend.GroupTaskStates(groupUUID, 0)
+
+		if err != nil {
+			return "Error when getting states of " + groupUUID, err
+		}
+
+		encoded, err := json.Marshal(Summarize(groupUUID, states))
+
+		if err != nil {
+			return "Error when encoding summary", err
+		}
+
+		return string(encoded), nil
+	}
+}
@@ -0,0 +1,210 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// A placeholder of a command template, e.g. "{{threads}}"
+var placeholderRegexp = regexp.MustCompile(`{{\s*([A-Za-z_][A-Za-z0-9_]*)\s*}}`)
+
+// Param is a parameter of a sweep and the values it takes
+type Param struct {
+	Name   string
+	Values []string
+}
+
+// Combination binds each parameter of a sweep to one of its values
+type Combination map[string]string
+
+// ParseParam parses the definition of a parameter, "name=values", where the
+// values are separated by commas and may be integer ranges "first..last" or
+// "first..last..step", e.g. "threads=1,2,4..8" or "size=64..1024..64"
+func ParseParam(spec string) (*Param, error) {
+	i := strings.Index(spec, "=")
+
+	if i < 1 {
+		return nil, fmt.Errorf("Invalid parameter %q, expected name=values", spec)
+	}
+
+	param := &Param{Name: strings.TrimSpace(spec[:i])}
+
+	for _, item := range strings.Split(spec[i+1:], ",") {
+		item = strings.TrimSpace(item)
+
+		if !strings.Contains(item, "..") {
+			param.Values = append(param.Values, item)
+			continue
+		}
+
+		values, err := expandRange(item)
+
+		if err != nil {
+			return nil, fmt.Errorf("Invalid values of parameter %s: %s", param.Name, err.Error())
+		}
+
+		param.Values = append(param.Values, values...)
+	}
+
+	return param, nil
+}
+
+// expandRange lists the integers of a range "first..last" or
+// "first..last..step", both ends included
+func expandRange(item string) ([]string, error) {
+	parts := strings.Split(item, "..")
+
+	if len(parts) > 3 {
+		return nil, fmt.Errorf("invalid range %q", item)
+	}
+
+	bounds := make([]int, len(parts))
+
+	for i, part := range parts {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+
+		if err != nil {
+			return nil, fmt.Errorf("invalid range %q", item)
+		}
+
+		bounds[i] = n
+	}
+
+	first, last, step := bounds[0], bounds[1], 1
+
+	if len(bounds) == 3 {
+		step = bounds[2]
+	}
+
+	if step < 1 || last < first {
+		return nil, fmt.Errorf("empty range %q", item)
+	}
+
+	var values []string
+
+	for n := first; n <= last; n += step {
+		values = append(values, strconv.Itoa(n))
+	}
+
+	return values, nil
+}
+
+// Expand lists the combinations of the values of the parameters: their
+// cartesian product, the first parameter varying the slowest, or if zip is
+// set, the first values of every parameter, then the second ones and so on.
+// When zipped, a parameter with a single value is part of every combination
+func Expand(params []*Param, zip bool) ([]Combination, error) {
+	if zip {
+		return expandZip(params)
+	}
+
+	combinations := []Combination{{}}
+
+	for _, param := range params {
+		var next []Combination
+
+		for _, combination := range combinations {
+			for _, value := range param.Values {
+				c := Combination{param.Name: value}
+
+				for name, v := range combination {
+					c[name] = v
+				}
+
+				next = append(next, c)
+			}
+		}
+
+		combinations = next
+	}
+
+	return combinations, nil
+}
+
+func expandZip(params []*Param) ([]Combination, error) {
+	length := 1
+
+	for _, param := range params {
+		if len(param.Values) == 1 {
+			continue
+		}
+
+		if length != 1 && len(param.Values) != length {
+			return nil, fmt.Errorf("Cannot zip parameters of different lengths: %s has %d values, expected %d", param.Name, len(param.Values), length)
+		}
+
+		length = len(param.Values)
+	}
+
+	combinations := make([]Combination, length)
+
+	for i := range combinations {
+		combinations[i] = make(Combination)
+
+		for _, param := range params {
+			if len(param.Values) == 1 {
+				combinations[i][param.Name] = param.Values[0]
+			} else {
+				combinations[i][param.Name] = param.Values[i]
+			}
+		}
+	}
+
+	return combinations, nil
+}
+
+// Placeholders returns the names of the placeholders of a command template,
+// in the order they first appear
+func Placeholders(template string) []string {
+	var names []string
+
+	seen := make(map[string]bool)
+
+	for _, match := range placeholderRegexp.FindAllStringSubmatch(template, -1) {
+		if !seen[match[1]] {
+			seen[match[1]] = true
+			names = append(names, match[1])
+		}
+	}
+
+	return names
+}
+
+// Render replaces the placeholders of a command template by the values of a
+// combination
+func Render(template string, c Combination) (string, error) {
+	var missing []string
+
+	reported := make(map[string]bool)
+
+	rendered := placeholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		name := placeholderRegexp.FindStringSubmatch(placeholder)[1]
+		value, ok := c[name]
+
+		if !ok && !reported[name] {
+			reported[name] = true
+			missing = append(missing, name)
+		}
+
+		return value
+	})
+
+	if len(missing) > 0 {
+		return "", fmt.Errorf("No value for %s", strings.Join(missing, ", "))
+	}
+
+	return rendered, nil
+}
+
+// Label formats a combination as "name=value" pairs, in the order of params
+func (c Combination) Label(params []*Param) string {
+	var pairs []string
+
+	for _, param := range params {
+		pairs = append(pairs, param.Name+"="+c[param.Name])
+	}
+
+	return strings.Join(pairs, " ")
+}
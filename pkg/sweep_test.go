@@ -0,0 +1,163 @@
+package benchdrilltasks
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseParam(t *testing.T) {
+	for spec, want := range map[string][]string{
+		"threads=1,2,4":        {"1", "2", "4"},
+		"mode=rndrd, seqwr":    {"rndrd", "seqwr"},
+		"threads=4..8":         {"4", "5", "6", "7", "8"},
+		"size=64..256..64":     {"64", "128", "192", "256"},
+		"size=64..250..64":     {"64", "128", "192"},
+		"threads=1,2,4..16..4": {"1", "2", "4", "8", "12", "16"},
+		"threads=8..8":         {"8"},
+		"offset=-2..2..2":      {"-2", "0", "2"},
+		"time=10":              {"10"},
+	} {
+		param, err := ParseParam(spec)
+
+		if err != nil {
+			t.Errorf("%s: %s", spec, err.Error())
+			continue
+		}
+
+		if name := spec[:strings.Index(spec, "=")]; param.Name != name || !reflect.DeepEqual(param.Values, want) {
+			t.Errorf("%s: got %s=%v, expected %s=%v", spec, param.Name, param.Values, name, want)
+		}
+	}
+}
+
+func TestParseParamInvalid(t *testing.T) {
+	for _, spec := range []string{
+		"threads",
+		"=1,2",
+		"threads=8..1",     // descending
+		"threads=8..1..2",  // descending with a step
+		"threads=1..8..0",  // zero step
+		"threads=1..8..-1", // negative step
+		"threads=1..8..2..4",
+		"threads=1..x",
+		"threads=..8",
+	} {
+		if param, err := ParseParam(spec); err == nil {
+			t.Errorf("Expected %q to be refused, got %v", spec, param.Values)
+		}
+	}
+}
+
+func TestExpandRange(t *testing.T) {
+	for item, want := range map[string]string{
+		"1..3":       "1 2 3",
+		"0..10..5":   "0 5 10",
+		"0..9..5":    "0 5",
+		"3..1":       "empty range",
+		"1..3..0":    "empty range",
+		"a..3":       "invalid range",
+		"1..2..3..4": "invalid range",
+	} {
+		values, err := expandRange(item)
+
+		if err != nil {
+			if !strings.HasPrefix(err.Error(), want) {
+				t.Errorf("%s: error %q, expected %s", item, err.Error(), want)
+			}
+
+			continue
+		}
+
+		if got := strings.Join(values, " "); got != want {
+			t.Errorf("%s: got %s, expected %s", item, got, want)
+		}
+	}
+}
+
+func TestExpand(t *testing.T) {
+	threads := &Param{Name: "threads", Values: []string{"1", "2", "4"}}
+	mode := &Param{Name: "mode", Values: []string{"rd", "wr"}}
+	size := &Param{Name: "size", Values: []string{"1G", "2G", "4G"}}
+	time := &Param{Name: "time", Values: []string{"10"}}
+
+	for _, test := range []struct {
+		name   string
+		params []*Param
+		zip    bool
+		want   []string
+	}{
+		{
+			name:   "cartesian",
+			params: []*Param{threads, mode},
+			want:   []string{"threads=1 mode=rd", "threads=1 mode=wr", "threads=2 mode=rd", "threads=2 mode=wr", "threads=4 mode=rd", "threads=4 mode=wr"},
+		},
+		{
+			name:   "cartesian with a single value",
+			params: []*Param{mode, time},
+			want:   []string{"mode=rd time=10", "mode=wr time=10"},
+		},
+		{
+			name: "no parameter",
+			want: []string{""},
+		},
+		{
+			name:   "zip",
+			params: []*Param{threads, size},
+			zip:    true,
+			want:   []string{"threads=1 size=1G", "threads=2 size=2G", "threads=4 size=4G"},
+		},
+		{
+			name:   "zip with a single value",
+			params: []*Param{threads, time},
+			zip:    true,
+			want:   []string{"threads=1 time=10", "threads=2 time=10", "threads=4 time=10"},
+		},
+	} {
+		combinations, err := Expand(test.params, test.zip)
+
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err.Error())
+			continue
+		}
+
+		var got []string
+
+		for _, c := range combinations {
+			got = append(got, c.Label(test.params))
+		}
+
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: got %q, expected %q", test.name, got, test.want)
+		}
+	}
+
+	// Lists of different lengths cannot be zipped
+	if _, err := Expand([]*Param{threads, mode}, true); err == nil || !strings.Contains(err.Error(), "mode has 2 values, expected 3") {
+		t.Errorf("Expected zipping lists of different lengths to fail, got %v", err)
+	}
+}
+
+func TestRender(t *testing.T) {
+	template := "sysbench --threads={{threads}} --time={{ time }} cpu run --threads={{threads}}"
+
+	if names := Placeholders(template); !reflect.DeepEqual(names, []string{"threads", "time"}) {
+		t.Errorf("Placeholders %v, expected threads and time", names)
+	}
+
+	rendered, err := Render(template, Combination{"threads": "4", "time": "10"})
+
+	if err != nil || rendered != "sysbench --threads=4 --time=10 cpu run --threads=4" {
+		t.Errorf("Rendered %q (%v)", rendered, err)
+	}
+
+	// Each missing variable is reported once
+	for want, c := range map[string]Combination{
+		"No value for time":          {"threads": "4"},
+		"No value for threads, time": {"size": "1G"},
+	} {
+		if _, err := Render(template, c); err == nil || err.Error() != want {
+			t.Errorf("Error %v, expected %q", err, want)
+		}
+	}
+}
@@ -1,28 +1,137 @@
 package benchdrilltasks
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 )
 
-// Command passed to workers
-func TaskArgs(cmd string) (string, error) {
+// ErrTimedOut is the error of a command killed after the timeout of its task
+var ErrTimedOut = errors.New("Command timed out")
+
+// Command passed to workers. The worker appends the options of the task: the
+// working directory, where the command runs in the cgroup of the task if the
+// worker created one, then the time after which the command is killed. The
+// accounting of the cgroup is appended to the output
+func TaskArgs(cmd string, opts ...string) (string, error) {
 	splitted_args := strings.Split(cmd, " ")
 
-	res, err := exec.Command(splitted_args[0], splitted_args[1:]...).Output()
+	dir, timeout, err := taskOptions(opts)
 
 	if err != nil {
 		return "Error when executing " + splitted_args[0], err
 	}
 
-	return string(res), nil
+	command := exec.Command(splitted_args[0], splitted_args[1:]...)
+
+	var res bytes.Buffer
+	command.Stdout = &res
+
+	var cgroup *Cgroup
+
+	if dir != "" {
+		command.Dir = dir
+		cgroup = cgroupOf(dir)
+
+		// A copy of the output is tailed by the worker while the command runs
+		if f, err := os.Create(filepath.Join(dir, OutputFile)); err == nil {
+			defer f.Close()
+			command.Stdout = io.MultiWriter(&res, f)
+		}
+	}
+
+	if cgroup != nil {
+		cgroup.wrap(command)
+	}
+
+	if err := run(command, timeout); err != nil {
+		return "Error when executing " + splitted_args[0], err
+	}
+
+	if cgroup != nil {
+		res.WriteString(cgroup.Usage())
+	}
+
+	return res.String(), nil
+}
+
+// taskOptions reads the options appended to the arguments of a task: its
+// working directory, "" if none, and the timeout of its command, 0 if none
+func taskOptions(opts []string) (string, time.Duration, error) {
+	var (
+		dir     string
+		timeout time.Duration
+		err     error
+	)
+
+	if len(opts) > 0 {
+		dir = opts[0]
+	}
+
+	if len(opts) > 1 {
+		if timeout, err = time.ParseDuration(opts[1]); err != nil {
+			return "", 0, fmt.Errorf("Invalid timeout %q: %s", opts[1], err.Error())
+		}
+	}
+
+	return dir, timeout, nil
 }
 
-func TaskFile(cmd, file string) (string, error) {
-	if err := ioutil.WriteFile("/root/workload.f", []byte(file), 0644); err != nil {
-		return "Error when writing workload.f", err
+// run runs a command, killed with the processes it started once it runs
+// longer than timeout, without limit if 0
+func run(command *exec.Cmd, timeout time.Duration) error {
+	if timeout <= 0 {
+		return command.Run()
+	}
+
+	// In its own process group, so that its children are killed as well
+	command.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	if err := command.Start(); err != nil {
+		return err
 	}
 
-	return TaskArgs(cmd + "/root/workload.f")
+	timer := time.AfterFunc(timeout, func() {
+		syscall.Kill(-command.Process.Pid, syscall.SIGKILL)
+	})
+
+	err := command.Wait()
+
+	if !timer.Stop() {
+		return fmt.Errorf("%s after %s", ErrTimedOut.Error(), timeout)
+	}
+
+	return err
+}
+
+// WorkloadFile is the file the workload of TaskFile is written to, in the
+// working directory of its task
+const WorkloadFile = "workload.f"
+
+func TaskFile(cmd, file string, opts ...string) (string, error) {
+	path := filepath.Join("/root", WorkloadFile)
+
+	if len(opts) > 0 && opts[0] != "" {
+		path = filepath.Join(opts[0], WorkloadFile)
+	}
+
+	if err := ioutil.WriteFile(path, []byte(file), 0644); err != nil {
+		return "Error when writing " + WorkloadFile, err
+	}
+
+	return TaskArgs(cmd+path, opts...)
+}
+
+// TaskCleanup runs the cleanup step of a pipeline after a previous step
+// failed, machinery passes the error of that step first
+func TaskCleanup(stepErr, cmd string, opts ...string) (string, error) {
+	return TaskArgs(cmd, opts...)
 }
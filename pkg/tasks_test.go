@@ -0,0 +1,131 @@
+package benchdrilltasks
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTaskArgs(t *testing.T) {
+	out, err := TaskArgs("echo hello world")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "hello world\n" {
+		t.Errorf("Output %q, expected %q", out, "hello world\n")
+	}
+}
+
+func TestTaskArgsDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	out, err := TaskArgs("pwd", dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if want, _ := filepath.EvalSymlinks(dir); strings.TrimSpace(out) != want && strings.TrimSpace(out) != dir {
+		t.Errorf("Ran in %s, expected %s", strings.TrimSpace(out), dir)
+	}
+}
+
+func TestTaskArgsError(t *testing.T) {
+	if _, err := TaskArgs("false"); err == nil {
+		t.Error("Expected the error of a failing command")
+	}
+
+	if _, err := TaskArgs("benchdrill_no_such_command"); err == nil {
+		t.Error("Expected the error of a missing command")
+	}
+}
+
+func TestTaskFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	// The path of the workload file is appended to the command
+	out, err := TaskFile("cat ", "run 60\n", dir)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "run 60\n" {
+		t.Errorf("Output %q, expected the workload file", out)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "workload.f")); err != nil {
+		t.Errorf("Workload file not written in the working directory: %s", err.Error())
+	}
+}
+
+func TestTaskCleanup(t *testing.T) {
+	// The error of the failed step comes first and is ignored
+	out, err := TaskCleanup("exit status 1", "echo cleaned")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if out != "cleaned\n" {
+		t.Errorf("Output %q, expected %q", out, "cleaned\n")
+	}
+}
+
+func TestTaskArgsOutputFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill_test")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	if _, err := TaskArgs("seq 1000", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	// The worker publishes the tail of the copy, from the start of a line
+	tail, err := readTail(filepath.Join(dir, OutputFile), 20)
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if string(tail) != "997\n998\n999\n1000\n" {
+		t.Errorf("Tail %q, expected the last lines of the output", tail)
+	}
+}
+
+func TestTaskArgsTimeout(t *testing.T) {
+	start := time.Now()
+
+	// The shell leaves a child holding its output (arguments are split on
+	// spaces only)
+	_, err := TaskArgs("sh -c sleep\t5;true", "", "200ms")
+
+	if err == nil || !strings.HasPrefix(err.Error(), ErrTimedOut.Error()) {
+		t.Errorf("Error %v, expected a timeout", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > 2*time.Second {
+		t.Errorf("Returned after %s, expected around the 200ms timeout", elapsed)
+	}
+}
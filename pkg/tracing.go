@@ -0,0 +1,496 @@
+package benchdrilltasks
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// TraceparentHeader carries the trace context of a task, in the format of the
+// traceparent header of W3C Trace Context, so that the spans of the workers
+// join the trace of the client which sent it
+const TraceparentHeader = "traceparent"
+
+// Attributes of the spans about a task, with which they can be joined with
+// the logs
+const (
+	TraceTaskAttribute     = "benchdrill.task"
+	TraceTaskNameAttribute = "benchdrill.task.name"
+	TraceGroupAttribute    = "benchdrill.group"
+	TraceWorkerAttribute   = "benchdrill.worker"
+	TraceAttemptAttribute  = "benchdrill.attempt"
+)
+
+// Kinds of spans, as numbered by OTLP
+const (
+	SpanInternal = 1
+	SpanServer   = 2
+	SpanClient   = 3
+	SpanProducer = 4
+	SpanConsumer = 5
+)
+
+// Spans are buffered and exported in batches, every period or once there are
+// too many
+const (
+	traceFlushPeriod = 5 * time.Second
+	maxPendingSpans  = 512
+)
+
+// SpanContext identifies a span and its trace
+type SpanContext struct {
+	TraceID [16]byte
+	SpanID  [8]byte
+}
+
+// IsValid returns true unless the context is zero
+func (c SpanContext) IsValid() bool {
+	return c != SpanContext{}
+}
+
+// Traceparent formats the context as a traceparent header, sampled
+func (c SpanContext) Traceparent() string {
+	return fmt.Sprintf("00-%x-%x-01", c.TraceID, c.SpanID)
+}
+
+// ParseTraceparent parses a traceparent header, the context is zero if it is
+// invalid
+func ParseTraceparent(value string) SpanContext {
+	var c SpanContext
+
+	parts := strings.Split(strings.TrimSpace(value), "-")
+
+	if len(parts) < 4 || len(parts[0]) != 2 || parts[0] == "ff" {
+		return SpanContext{}
+	}
+
+	traceID, err := hex.DecodeString(parts[1])
+
+	if err != nil || len(traceID) != len(c.TraceID) {
+		return SpanContext{}
+	}
+
+	spanID, err := hex.DecodeString(parts[2])
+
+	if err != nil || len(spanID) != len(c.SpanID) {
+		return SpanContext{}
+	}
+
+	copy(c.TraceID[:], traceID)
+	copy(c.SpanID[:], spanID)
+
+	return c
+}
+
+// TraceContext returns the trace context carried by the headers of a task,
+// zero if there is none
+func TraceContext(headers tasks.Headers) SpanContext {
+	value, _ := headers[TraceparentHeader].(string)
+
+	return ParseTraceparent(value)
+}
+
+// SetTraceContext sets the trace context carried by the headers of a task
+func SetTraceContext(headers tasks.Headers, c SpanContext) {
+	if c.IsValid() {
+		headers[TraceparentHeader] = c.Traceparent()
+	}
+}
+
+// Span is a timed operation of a trace
+type Span struct {
+	Name       string
+	Kind       int
+	Context    SpanContext
+	Parent     SpanContext
+	Start      time.Time
+	End        time.Time
+	Attributes map[string]interface{}
+	// Error of the operation, "" if it succeeded
+	Error  string
+	tracer *Tracer
+	ended  bool
+}
+
+// SetAttribute sets an attribute of the span, empty values are left out
+func (s *Span) SetAttribute(key string, value interface{}) *Span {
+	if value != "" && value != nil {
+		s.Attributes[key] = value
+	}
+
+	return s
+}
+
+// SetError records the error of the operation, if any
+func (s *Span) SetError(err error) {
+	if err != nil {
+		s.Error = err.Error()
+	}
+}
+
+// Child starts a span of the same tracer below this span
+func (s *Span) Child(name string) *Span {
+	return s.tracer.Start(name, s.Context, SpanInternal)
+}
+
+// Finish ends the span now
+func (s *Span) Finish() {
+	s.FinishAt(time.Now())
+}
+
+// FinishAt ends the span at the given time and hands it to the tracer, it
+// does nothing if the span already ended
+func (s *Span) FinishAt(end time.Time) {
+	s.tracer.mu.Lock()
+
+	if s.ended {
+		s.tracer.mu.Unlock()
+		return
+	}
+
+	s.ended = true
+	s.End = end
+	s.tracer.mu.Unlock()
+
+	s.tracer.record(s)
+}
+
+// SpanExporter exports the spans of a tracer, encoded as an OTLP
+// ExportTraceServiceRequest in JSON
+type SpanExporter interface {
+	ExportSpans(request []byte) error
+}
+
+// Tracer creates the spans of the process and exports them in batches. The
+// trace context is propagated whether or not there are exporters
+type Tracer struct {
+	mu        sync.Mutex
+	resource  map[string]interface{}
+	exporters []SpanExporter
+	pending   []*Span
+	stop      chan struct{}
+}
+
+// Tracing is the tracer of Benchdrill
+var Tracing = NewTracer("benchdrill")
+
+// NewTracer creates Tracer instance of a service, without exporters
+func NewTracer(service string) *Tracer {
+	hostname, _ := os.Hostname()
+
+	return &Tracer{
+		resource: map[string]interface{}{
+			"service.name": service,
+			"host.name":    hostname,
+		},
+	}
+}
+
+// SetResource sets an attribute of the resource of the spans, e.g. the ID of
+// the worker
+func (t *Tracer) SetResource(key string, value interface{}) {
+	t.mu.Lock()
+	t.resource[key] = value
+	t.mu.Unlock()
+}
+
+// SetExporters replaces the exporters of the tracer, the spans are dropped if
+// there are none. Pending spans are exported every period in the background
+func (t *Tracer) SetExporters(exporters ...SpanExporter) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.exporters = exporters
+	t.pending = nil
+
+	if t.stop != nil {
+		close(t.stop)
+		t.stop = nil
+	}
+
+	if len(exporters) == 0 {
+		return
+	}
+
+	t.stop = make(chan struct{})
+
+	go func(stop <-chan struct{}) {
+		ticker := time.NewTicker(traceFlushPeriod)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+				t.Flush()
+			}
+		}
+	}(t.stop)
+}
+
+// Enabled returns true if the spans are exported
+func (t *Tracer) Enabled() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return len(t.exporters) > 0
+}
+
+// Start starts a span below a parent, or the root span of a new trace if the
+// parent is zero
+func (t *Tracer) Start(name string, parent SpanContext, kind int) *Span {
+	return t.StartAt(name, parent, kind, time.Now())
+}
+
+// StartAt starts a span at the given time, e.g. for an operation measured
+// afterwards
+func (t *Tracer) StartAt(name string, parent SpanContext, kind int, start time.Time) *Span {
+	s := &Span{
+		Name:       name,
+		Kind:       kind,
+		Parent:     parent,
+		Start:      start,
+		Attributes: make(map[string]interface{}),
+		tracer:     t,
+	}
+
+	if parent.IsValid() {
+		s.Context.TraceID = parent.TraceID
+	} else {
+		rand.Read(s.Context.TraceID[:])
+	}
+
+	rand.Read(s.Context.SpanID[:])
+
+	return s
+}
+
+func (t *Tracer) record(s *Span) {
+	t.mu.Lock()
+
+	if len(t.exporters) == 0 {
+		t.mu.Unlock()
+		return
+	}
+
+	t.pending = append(t.pending, s)
+	full := len(t.pending) >= maxPendingSpans
+	t.mu.Unlock()
+
+	if full {
+		go t.Flush()
+	}
+}
+
+// Flush exports the pending spans, it returns the first error of the
+// exporters
+func (t *Tracer) Flush() error {
+	t.mu.Lock()
+	spans, exporters := t.pending, t.exporters
+	t.pending = nil
+	request := t.request(spans)
+	t.mu.Unlock()
+
+	if len(spans) == 0 {
+		return nil
+	}
+
+	var firstErr error
+
+	for _, exporter := range exporters {
+		if err := exporter.ExportSpans(request); err != nil {
+			Log.Warningf("Could not export %d spans: %s", len(spans), err.Error())
+
+			if firstErr == nil {
+				firstErr = err
+			}
+		}
+	}
+
+	return firstErr
+}
+
+// OTLP messages, in their JSON encoding
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+
+	otlpResource struct {
+		Attributes []otlpAttribute `json:"attributes"`
+	}
+
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+
+	otlpSpan struct {
+		TraceID           string          `json:"traceId"`
+		SpanID            string          `json:"spanId"`
+		ParentSpanID      string          `json:"parentSpanId,omitempty"`
+		Name              string          `json:"name"`
+		Kind              int             `json:"kind"`
+		StartTimeUnixNano string          `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+		Attributes        []otlpAttribute `json:"attributes,omitempty"`
+		Status            otlpStatus      `json:"status"`
+	}
+
+	otlpStatus struct {
+		Code    int    `json:"code,omitempty"`
+		Message string `json:"message,omitempty"`
+	}
+
+	otlpAttribute struct {
+		Key   string                 `json:"key"`
+		Value map[string]interface{} `json:"value"`
+	}
+)
+
+// Status codes of the spans: unset for those which succeeded
+const otlpStatusError = 2
+
+// request encodes spans as an ExportTraceServiceRequest of the resource of
+// the tracer
+func (t *Tracer) request(spans []*Span) []byte {
+	scope := otlpScopeSpans{Scope: otlpScope{Name: "benchdrill"}}
+
+	for _, s := range spans {
+		span := otlpSpan{
+			TraceID:           hex.EncodeToString(s.Context.TraceID[:]),
+			SpanID:            hex.EncodeToString(s.Context.SpanID[:]),
+			Name:              s.Name,
+			Kind:              s.Kind,
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(s.Attributes),
+		}
+
+		if s.Parent.IsValid() {
+			span.ParentSpanID = hex.EncodeToString(s.Parent.SpanID[:])
+		}
+
+		if s.Error != "" {
+			span.Status = otlpStatus{Code: otlpStatusError, Message: s.Error}
+		}
+
+		scope.Spans = append(scope.Spans, span)
+	}
+
+	data, _ := json.Marshal(otlpRequest{
+		ResourceSpans: []otlpResourceSpans{{
+			Resource:   otlpResource{Attributes: otlpAttributes(t.resource)},
+			ScopeSpans: []otlpScopeSpans{scope},
+		}},
+	})
+
+	return data
+}
+
+// otlpAttributes encodes attributes as key-values, sorted by key
+func otlpAttributes(attributes map[string]interface{}) []otlpAttribute {
+	var keys []string
+
+	for key := range attributes {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	var encoded []otlpAttribute
+
+	for _, key := range keys {
+		var value map[string]interface{}
+
+		switch v := attributes[key].(type) {
+		case bool:
+			value = map[string]interface{}{"boolValue": v}
+		case int:
+			value = map[string]interface{}{"intValue": strconv.Itoa(v)}
+		case int64:
+			value = map[string]interface{}{"intValue": strconv.FormatInt(v, 10)}
+		case float64:
+			value = map[string]interface{}{"doubleValue": v}
+		default:
+			value = map[string]interface{}{"stringValue": fmt.Sprint(v)}
+		}
+
+		encoded = append(encoded, otlpAttribute{Key: key, Value: value})
+	}
+
+	return encoded
+}
+
+// otlpExporter posts the spans to the OTLP/HTTP endpoint of a collector,
+// in JSON
+type otlpExporter struct {
+	url string
+}
+
+// NewOTLPExporter creates the exporter of a collector, e.g.
+// http://collector:4318, to which /v1/traces is appended
+func NewOTLPExporter(endpoint string) SpanExporter {
+	url := strings.TrimSuffix(endpoint, "/")
+
+	if !strings.HasSuffix(url, "/v1/traces") {
+		url += "/v1/traces"
+	}
+
+	return &otlpExporter{url: url}
+}
+
+func (e *otlpExporter) ExportSpans(request []byte) error {
+	return send(http.MethodPost, e.url, "application/json", "", bytes.NewReader(request))
+}
+
+// spanFileExporter appends the spans to a file, a request per line as the
+// file exporter of the OpenTelemetry Collector writes them
+type spanFileExporter struct {
+	path string
+	mu   sync.Mutex
+}
+
+// NewSpanFileExporter creates the exporter of a file
+func NewSpanFileExporter(path string) SpanExporter {
+	return &spanFileExporter{path: path}
+}
+
+func (e *spanFileExporter) ExportSpans(request []byte) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	f, err := os.OpenFile(e.path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(append(request, '\n')); err != nil {
+		f.Close()
+		return err
+	}
+
+	return f.Close()
+}
@@ -0,0 +1,157 @@
+package benchdrilltasks
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+func TestTraceparent(t *testing.T) {
+	span := NewTracer("test").Start("submit", SpanContext{}, SpanClient)
+	headers := make(tasks.Headers)
+	SetTraceContext(headers, span.Context)
+
+	value, _ := headers[TraceparentHeader].(string)
+
+	if len(value) != 55 || !strings.HasPrefix(value, "00-") || !strings.HasSuffix(value, "-01") {
+		t.Errorf("Traceparent %q, expected a sampled W3C traceparent", value)
+	}
+
+	if TraceContext(headers) != span.Context {
+		t.Errorf("Parsed %q as %v, expected %v", value, TraceContext(headers), span.Context)
+	}
+
+	for _, invalid := range []string{"", "00-123-456-01", "ff-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01", "00-0af7651916cd43dd8448eb211c80319c-b7ad6b71692033zz-01"} {
+		if ParseTraceparent(invalid).IsValid() {
+			t.Errorf("Parsed invalid traceparent %q", invalid)
+		}
+	}
+
+	if child := span.Child("publish"); child.Context.TraceID != span.Context.TraceID || child.Parent != span.Context {
+		t.Error("Expected the child in the trace of its parent")
+	}
+}
+
+// decodeSpans decodes the spans of an OTLP request, by name
+func decodeSpans(t *testing.T, request string) map[string]otlpSpan {
+	var decoded otlpRequest
+
+	if err := json.Unmarshal([]byte(request), &decoded); err != nil {
+		t.Fatalf("Could not decode %s: %s", request, err.Error())
+	}
+
+	spans := make(map[string]otlpSpan)
+
+	for _, resourceSpans := range decoded.ResourceSpans {
+		for _, scopeSpans := range resourceSpans.ScopeSpans {
+			for _, span := range scopeSpans.Spans {
+				spans[span.Name] = span
+			}
+		}
+	}
+
+	return spans
+}
+
+func TestOTLPExporter(t *testing.T) {
+	server, requests := standIn(t)
+	defer server.Close()
+
+	tracer := NewTracer("test")
+	tracer.SetExporters(NewOTLPExporter(server.URL + "/"))
+	defer tracer.SetExporters()
+
+	root := tracer.StartAt("submit", SpanContext{}, SpanClient, time.Unix(1500000000, 0))
+	root.SetAttribute(TraceGroupAttribute, "group_1")
+	root.SetAttribute("benchdrill.instances", 2)
+	child := root.Child("exec")
+	child.SetError(ErrTimedOut)
+	child.Finish()
+	root.Finish()
+	root.Finish()
+
+	if err := tracer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r := <-requests
+
+	if r.method != "POST" || r.path != "/v1/traces" {
+		t.Errorf("Request %s %s, expected POST /v1/traces", r.method, r.path)
+	}
+
+	spans := decodeSpans(t, r.body)
+
+	if len(spans) != 2 {
+		t.Fatalf("Exported %d spans, expected 2: %s", len(spans), r.body)
+	}
+
+	if spans["exec"].TraceID != spans["submit"].TraceID || spans["exec"].ParentSpanID != spans["submit"].SpanID || spans["submit"].ParentSpanID != "" {
+		t.Errorf("Expected exec below submit: %s", r.body)
+	}
+
+	if spans["exec"].Status.Code != otlpStatusError || spans["exec"].Status.Message != ErrTimedOut.Error() {
+		t.Errorf("Status %v, expected the error", spans["exec"].Status)
+	}
+
+	if spans["submit"].StartTimeUnixNano != "1500000000000000000" || spans["submit"].Kind != SpanClient {
+		t.Errorf("Expected the start and kind of the root: %s", r.body)
+	}
+
+	for _, expected := range []string{`{"key":"benchdrill.group","value":{"stringValue":"group_1"}}`, `{"key":"benchdrill.instances","value":{"intValue":"2"}}`, `{"key":"service.name","value":{"stringValue":"test"}}`} {
+		if !strings.Contains(r.body, expected) {
+			t.Errorf("Expected %s in %s", expected, r.body)
+		}
+	}
+
+	// Nothing is left to export
+	if err := tracer.Flush(); err != nil || len(requests) != 0 {
+		t.Errorf("Expected no request, got %d (%v)", len(requests), err)
+	}
+}
+
+func TestSpanFileExporter(t *testing.T) {
+	dir, err := ioutil.TempDir("", "benchdrill-tracing")
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "spans.json")
+	tracer := NewTracer("test")
+
+	// Spans are dropped without exporters
+	tracer.Start("dropped", SpanContext{}, SpanInternal).Finish()
+
+	tracer.SetExporters(NewSpanFileExporter(path))
+	defer tracer.SetExporters()
+
+	for _, name := range []string{"first", "second"} {
+		tracer.Start(name, SpanContext{}, SpanInternal).Finish()
+
+		if err := tracer.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	content, _ := ioutil.ReadFile(path)
+	lines := strings.Split(strings.TrimSpace(string(content)), "\n")
+
+	if len(lines) != 2 {
+		t.Fatalf("Wrote %d lines, expected a request per flush:\n%s", len(lines), content)
+	}
+
+	for i, name := range []string{"first", "second"} {
+		if _, ok := decodeSpans(t, lines[i])[name]; !ok {
+			t.Errorf("Expected span %s on line %d: %s", name, i+1, lines[i])
+		}
+	}
+}
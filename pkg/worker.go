@@ -0,0 +1,749 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/brokers"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// PinHeader is set on the first step of a pipeline so that the worker running
+// it runs the following steps as well
+const PinHeader = "benchdrill_pin"
+
+// WorkdirHeader names the working directory of a task when it is not named
+// after the task, so that the steps of a pipeline share the same directory
+const WorkdirHeader = "benchdrill_workdir"
+
+// GroupHeader is set on the following steps of a pipeline, which are not
+// part of the group, to the group of its first step
+const GroupHeader = "benchdrill_group"
+
+// Set once the working directory was appended to the arguments of a task
+const workdirArgHeader = "benchdrill_workdir_arg"
+
+// Set once the timeout of the worker was appended to the arguments of a task
+const timeoutArgHeader = "benchdrill_timeout_arg"
+
+// Heartbeat period of the workers; a worker is considered gone once its
+// heartbeat is older than three periods
+const heartbeatPeriod = 10 * time.Second
+
+// Worker wraps a machinery worker to record which worker runs each task,
+// when it started and finished, and to advertise itself in the Store.
+// Besides the default queue, a worker consumes its own private queue, where
+// the following steps of the pipelines it started are sent. Each task runs
+// in its own working directory, where its input files are unpacked and its
+// artifacts are collected from
+type Worker struct {
+	*machinery.Worker
+	ID string
+	// Directory where the working directories of the tasks are created
+	Workdir string
+	// Where the input files and the artifacts of the tasks are stored
+	Blobs BlobStore
+	// Operational metrics of the worker, served by the worker command
+	Metrics *Registry
+	// Where the metrics of the commands which succeed are published
+	Exporters []Exporter
+	// Where the commands of the tasks run with their limits, nil to run them
+	// in the cgroup of the worker
+	Cgroups *Cgroups
+	// Time after which the commands of the tasks are killed, without limit
+	// if 0
+	TaskTimeout   time.Duration
+	metrics       *workerMetrics
+	server        *machinery.Server
+	store         Store
+	privateBroker brokers.Interface
+	stopHeartbeat chan struct{}
+	busy          int32
+}
+
+// NewWorker creates Worker instance, the ID defaults to the hostname (the
+// container ID when running in a Swarm) and the process ID
+func NewWorker(server *machinery.Server, store Store, id string) (*Worker, error) {
+	if id == "" {
+		hostname, _ := os.Hostname()
+		id = fmt.Sprintf("%s_%d", hostname, os.Getpid())
+	}
+
+	w := &Worker{
+		Worker:  server.NewWorker(id),
+		ID:      id,
+		Workdir: filepath.Join(os.TempDir(), "benchdrill"),
+		Blobs:   NewBlobStore(store, ""),
+		Metrics: NewRegistry(),
+		server:  server,
+		store:   store,
+	}
+
+	w.metrics = newWorkerMetrics(w)
+	traceBackend(server)
+	pinBroker(server, store)
+
+	privateCnf := *server.GetConfig()
+	privateCnf.DefaultQueue = w.PrivateQueue()
+
+	privateBroker, err := machinery.BrokerFactory(&privateCnf)
+
+	if err != nil {
+		return nil, err
+	}
+
+	privateBroker.SetRegisteredTaskNames(server.GetRegisteredTaskNames())
+	w.privateBroker = privateBroker
+
+	return w, nil
+}
+
+// PrivateQueue returns the name of the queue only consumed by this worker
+func (w *Worker) PrivateQueue() string {
+	return w.server.GetConfig().DefaultQueue + "_" + w.ID
+}
+
+// Launch starts consuming tasks from the default queue and the private
+// queue until a signal is received
+func (w *Worker) Launch() error {
+	cnf := w.server.GetConfig()
+
+	w.log().Infof("Launching worker %s", w.ID)
+	w.log().Infof("- Broker: %s", cnf.Broker)
+	w.log().Infof("- DefaultQueue: %s", cnf.DefaultQueue)
+	w.log().Infof("- PrivateQueue: %s", w.PrivateQueue())
+	w.log().Infof("- ResultBackend: %s", cnf.ResultBackend)
+
+	errorsChan := make(chan error)
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, os.Interrupt, syscall.SIGTERM)
+
+	w.Start(errorsChan)
+
+	go func() {
+		err := fmt.Errorf("Signal received: %v. Quitting the worker", <-sig)
+		w.log().Warning(err.Error())
+		w.Quit()
+		errorsChan <- err
+	}()
+
+	return <-errorsChan
+}
+
+// Start consumes tasks from the default queue and the private queue in the
+// background until Quit is called, errors stopping a queue are sent to
+// errorsChan
+func (w *Worker) Start(errorsChan chan<- error) {
+	w.stopHeartbeat = make(chan struct{})
+	go w.heartbeat(w.stopHeartbeat)
+
+	for _, broker := range []brokers.Interface{w.server.GetBroker(), w.privateBroker} {
+		go func(broker brokers.Interface) {
+			for {
+				retry, err := broker.StartConsuming(w.ConsumerTag, w)
+
+				if retry {
+					w.log().Warningf("Going to retry launching the worker. Error: %v", err)
+				} else {
+					errorsChan <- err
+					return
+				}
+			}
+		}(broker)
+	}
+}
+
+// Quit stops consuming both queues
+func (w *Worker) Quit() {
+	w.Worker.Quit()
+	w.privateBroker.StopConsuming()
+
+	// The pending retries are sent by the workers still running
+	if broker, ok := w.server.GetBroker().(*pinnedBroker); ok {
+		broker.stop()
+	}
+
+	if w.stopHeartbeat != nil {
+		close(w.stopHeartbeat)
+		w.stopHeartbeat = nil
+	}
+
+	// Gone at once, e.g. for scale to see the service scaled down
+	if err := DeleteWorkerInfo(w.store, w.ID); err != nil {
+		w.log().Warningf("Could not remove heartbeat: %s", err.Error())
+	}
+}
+
+// Process records the task metadata around the processing of the task
+func (w *Worker) Process(signature *tasks.Signature) error {
+	taskLog := w.taskLog(signature)
+	defer taskLog.Track(signature.UUID)()
+
+	atomic.AddInt32(&w.busy, 1)
+	defer atomic.AddInt32(&w.busy, -1)
+
+	start := time.Now()
+	w.metrics.receive(signature, start)
+
+	span := w.traceTask(signature, start)
+	defer span.Finish()
+
+	receive := span.Child("receive")
+
+	// Retried tasks come back with the same UUID, keep counting their attempts
+	attempts := 0
+
+	if previous, err := GetTaskMeta(w.store, signature.UUID); err == nil {
+		attempts = previous.Attempts
+	}
+
+	meta := &TaskMeta{
+		TaskUUID:  signature.UUID,
+		GroupUUID: signature.GroupUUID,
+		Worker:    w.ID,
+		StartedAt: time.Now().UTC(),
+		Attempts:  attempts + 1,
+		Workload:  w.taskWorkload(signature),
+	}
+	w.saveTaskMeta(meta)
+	taskLog.Infof("Task %s started, attempt %d", signature.Name, meta.Attempts)
+	span.SetAttribute(TraceAttemptAttribute, meta.Attempts)
+	receive.Finish()
+
+	setup := span.Child("workspace setup")
+	dir := w.taskDir(signature)
+
+	// The following steps of a pipeline run on the worker of its first step,
+	// in the same directory
+	if pinned, _ := signature.Headers[PinHeader].(bool); pinned {
+		workdir := ""
+
+		if dir != "" {
+			workdir = filepath.Base(dir)
+		}
+
+		pin(signature.OnSuccess, w.PrivateQueue(), workdir, taskGroup(signature))
+		pin(signature.OnError, w.PrivateQueue(), workdir, taskGroup(signature))
+	}
+
+	var setupErr error
+
+	// Following steps of pipelines are not part of the group, a pipeline
+	// which started runs to its end
+	if IsCancelled(w.store, signature.GroupUUID) {
+		setupErr = ErrCancelled
+	} else {
+		setupErr = w.unpackBundle(signature, dir)
+	}
+
+	setup.SetError(setupErr)
+	setup.Finish()
+
+	if setupErr == nil {
+		w.logStepError(signature)
+	}
+
+	var err error
+
+	if setupErr != nil {
+		err = w.fail(signature, setupErr)
+		span.SetError(setupErr)
+	} else if dir != "" {
+		stopTail := w.tailOutput(signature, dir)
+		removeCgroup := w.taskCgroup(signature, dir)
+		endExec := traceExec(signature, span)
+		err = w.Worker.Process(signature)
+		endExec()
+		removeCgroup()
+		stopTail()
+	} else {
+		endExec := traceExec(signature, span)
+		err = w.Worker.Process(signature)
+		endExec()
+	}
+
+	meta.FinishedAt = time.Now().UTC()
+	w.saveTaskMeta(meta)
+	w.metrics.done(w, signature, meta.FinishedAt.Sub(start))
+
+	if dir != "" && len(w.Exporters) > 0 {
+		w.exportResult(signature, meta.FinishedAt)
+	}
+
+	w.collectArtifacts(signature, dir)
+
+	if dir != "" && len(signature.OnSuccess) == 0 && len(signature.OnError) == 0 {
+		os.RemoveAll(dir)
+	}
+
+	if signature.ChordCallback != nil {
+		w.triggerChord(signature)
+	}
+
+	w.releaseBundle(signature)
+
+	return err
+}
+
+// taskCgroup creates the cgroup of a task with its limits, in which the command
+// run in dir executes, and returns the function removing it. Without cgroups,
+// or if they fail, the task runs in the cgroup of the worker, without limits
+func (w *Worker) taskCgroup(signature *tasks.Signature, dir string) func() {
+	taskLog := w.taskLog(signature)
+	limits, err := TaskLimits(signature.Headers)
+
+	if err != nil {
+		taskLog.Warningf("Limits of task %s ignored: %s", signature.UUID, err.Error())
+	}
+
+	if w.Cgroups == nil {
+		if !limits.IsZero() {
+			taskLog.Warningf("Limits of task %s ignored: cgroups are not enabled on this worker", signature.UUID)
+		}
+
+		return func() {}
+	}
+
+	cgroup, err := w.Cgroups.Create(signature.UUID, limits)
+
+	if err != nil {
+		taskLog.Warningf("Task %s runs without its cgroup: %s", signature.UUID, err.Error())
+		return func() {}
+	}
+
+	unregister := registerCgroup(dir, cgroup)
+
+	return func() {
+		unregister()
+
+		if err := cgroup.Remove(); err != nil {
+			taskLog.Warningf("Could not remove cgroup %s: %s", cgroup.Dir(), err.Error())
+		}
+	}
+}
+
+// taskWorkload returns the workload file of a task of TaskFile, "" for other
+// tasks
+func (w *Worker) taskWorkload(signature *tasks.Signature) string {
+	taskFunc, err := w.server.GetRegisteredTask(signature.Name)
+
+	if err != nil || reflect.ValueOf(taskFunc).Pointer() != reflect.ValueOf(TaskFile).Pointer() || len(signature.Args) < 2 {
+		return ""
+	}
+
+	workload, _ := signature.Args[1].Value.(string)
+
+	return workload
+}
+
+// taskDir creates the working directory of a task and, if the task accepts
+// it (its last parameter is a variadic string), appends it to the arguments
+// of the task, followed by the timeout of the worker if any. It returns the
+// directory, or "" if the task does not use one
+func (w *Worker) taskDir(signature *tasks.Signature) string {
+	taskFunc, err := w.server.GetRegisteredTask(signature.Name)
+
+	if err != nil {
+		return ""
+	}
+
+	t := reflect.TypeOf(taskFunc)
+
+	if !t.IsVariadic() || t.In(t.NumIn()-1).Elem().Kind() != reflect.String {
+		return ""
+	}
+
+	name, _ := signature.Headers[WorkdirHeader].(string)
+
+	if name == "" {
+		name = signature.UUID
+	}
+
+	dir := filepath.Join(w.Workdir, name)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		w.taskLog(signature).Warningf("Could not create working directory of task %s: %s", signature.UUID, err.Error())
+		dir = ""
+	}
+
+	// A retried task comes back with the arguments of its previous attempt,
+	// possibly appended by another worker
+	for _, header := range []string{timeoutArgHeader, workdirArgHeader} {
+		if appended, _ := signature.Headers[header].(bool); appended {
+			signature.Args = signature.Args[:len(signature.Args)-1]
+			delete(signature.Headers, header)
+		}
+	}
+
+	if signature.Headers == nil {
+		signature.Headers = make(tasks.Headers)
+	}
+
+	// The timeout follows the directory, "" if it could not be created
+	if dir != "" || w.TaskTimeout > 0 {
+		signature.Headers[workdirArgHeader] = true
+		signature.Args = append(signature.Args, tasks.Arg{Type: "string", Value: dir})
+	}
+
+	if w.TaskTimeout > 0 {
+		signature.Headers[timeoutArgHeader] = true
+		signature.Args = append(signature.Args, tasks.Arg{Type: "string", Value: w.TaskTimeout.String()})
+	}
+
+	return dir
+}
+
+// unpackBundle unpacks the input files of a task in its working directory.
+// Bundles are cached by the worker, since tasks sent together share them
+func (w *Worker) unpackBundle(signature *tasks.Signature, dir string) error {
+	hash, _ := signature.Headers[BundleHeader].(string)
+
+	if hash == "" {
+		return nil
+	}
+
+	if dir == "" {
+		return fmt.Errorf("Task %s has input files but no working directory", signature.Name)
+	}
+
+	cache := filepath.Join(w.Workdir, "bundles", hash+".tar.gz")
+	archive, err := ioutil.ReadFile(cache)
+
+	if err != nil {
+		if archive, err = GetBundle(w.Blobs, hash); err != nil {
+			return fmt.Errorf("Could not get input files: %s", err.Error())
+		}
+
+		if err := os.MkdirAll(filepath.Dir(cache), 0755); err == nil {
+			ioutil.WriteFile(cache, archive, 0644)
+		}
+	}
+
+	if _, err := UnpackArchive(archive, dir); err != nil {
+		return fmt.Errorf("Could not unpack input files: %s", err.Error())
+	}
+
+	return nil
+}
+
+// fail marks a task which could not run as failed and sends its error
+// callbacks, as machinery does when the task returns an error
+func (w *Worker) fail(signature *tasks.Signature, taskErr error) error {
+	if err := w.server.GetBackend().SetStateFailure(signature, taskErr.Error()); err != nil {
+		return fmt.Errorf("Set state failure error: %v", err)
+	}
+
+	w.taskLog(signature).Errorf("Failed processing %s. Error = %v", signature.UUID, taskErr)
+
+	for _, errorTask := range signature.OnError {
+		errorTask.Args = append([]tasks.Arg{{Type: "string", Value: taskErr.Error()}}, errorTask.Args...)
+
+		if _, err := w.server.SendTask(errorTask); err != nil {
+			w.taskLog(signature).Errorf("Could not send error callback of task %s: %s", signature.UUID, err.Error())
+		}
+	}
+
+	return nil
+}
+
+// tailOutput publishes the tail of the output of a task every period until
+// the returned function is called
+func (w *Worker) tailOutput(signature *tasks.Signature, dir string) func() {
+	taskUUID := signature.UUID
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	path := filepath.Join(dir, OutputFile)
+
+	// Left by the previous step of a pipeline
+	os.Remove(path)
+
+	go func() {
+		defer close(done)
+
+		ticker := time.NewTicker(outputTailPeriod)
+		defer ticker.Stop()
+
+		var published []byte
+
+		for {
+			select {
+			case <-stop:
+				w.store.Del(outputTailPrefix + taskUUID)
+				return
+			case <-ticker.C:
+			}
+
+			tail, err := readTail(path, outputTailSize)
+
+			if err != nil || string(tail) == string(published) {
+				continue
+			}
+
+			// Kept a few periods only, in case the worker goes away
+			if err := SaveOutputTail(w.store, taskUUID, tail, int(3*outputTailPeriod/time.Second)); err != nil {
+				w.taskLog(signature).Warningf("Could not save output of task %s: %s", taskUUID, err.Error())
+				continue
+			}
+
+			published = tail
+		}
+	}()
+
+	return func() {
+		close(stop)
+		<-done
+	}
+}
+
+// collectArtifacts stores the archive of the artifacts a task declares,
+// whether it succeeded or not
+func (w *Worker) collectArtifacts(signature *tasks.Signature, dir string) {
+	patterns := ArtifactPatterns(signature.Headers)
+
+	if len(patterns) == 0 {
+		return
+	}
+
+	archive, files, err := PackArtifacts(dir, patterns)
+
+	if err != nil {
+		w.taskLog(signature).Warningf("Could not collect artifacts of task %s: %s", signature.UUID, err.Error())
+		return
+	}
+
+	if len(files) == 0 {
+		w.taskLog(signature).Warningf("No artifacts of task %s match %s", signature.UUID, strings.Join(patterns, ", "))
+	}
+
+	info := &ArtifactsInfo{
+		TaskUUID:  signature.UUID,
+		Worker:    w.ID,
+		Files:     files,
+		Size:      len(archive),
+		CreatedAt: time.Now().UTC(),
+	}
+
+	if err := SaveArtifacts(w.store, w.Blobs, info, archive, ExpireIn(w.server.GetConfig())); err != nil {
+		w.taskLog(signature).Warningf("Could not save artifacts of task %s: %s", signature.UUID, err.Error())
+	}
+}
+
+// releaseBundle drops the reference of the group of a task to its input
+// files once every task of the group completed, the bundle then expires
+func (w *Worker) releaseBundle(signature *tasks.Signature) {
+	hash, _ := signature.Headers[BundleHeader].(string)
+
+	if hash == "" || signature.GroupUUID == "" {
+		return
+	}
+
+	completed, err := w.server.GetBackend().GroupCompleted(signature.GroupUUID, signature.GroupTaskCount)
+
+	if err != nil || !completed {
+		return
+	}
+
+	if err := ReleaseBundle(w.store, w.Blobs, hash, signature.GroupUUID, ExpireIn(w.server.GetConfig())); err != nil {
+		w.taskLog(signature).Warningf("Could not release input files of group %s: %s", signature.GroupUUID, err.Error())
+	}
+}
+
+// triggerChord sends the chord callback of a group which completed with
+// failures: machinery only triggers it when every task of the group succeeded
+func (w *Worker) triggerChord(signature *tasks.Signature) {
+	states, err := w.server.GetBackend().GroupTaskStates(signature.GroupUUID, signature.GroupTaskCount)
+
+	if err != nil {
+		w.taskLog(signature).Warningf("Could not get states of group %s: %s", signature.GroupUUID, err.Error())
+		return
+	}
+
+	failed := false
+
+	for _, state := range states {
+		if !state.IsCompleted() {
+			return
+		}
+
+		failed = failed || state.IsFailure()
+	}
+
+	if !failed {
+		return
+	}
+
+	// Make sure only one worker sends the callback
+	triggered, err := w.store.SetNX(chordPrefix+signature.GroupUUID, []byte(w.ID), ExpireIn(w.server.GetConfig()))
+
+	if err != nil || !triggered {
+		return
+	}
+
+	if _, err := w.server.SendTask(signature.ChordCallback); err != nil {
+		w.taskLog(signature).Errorf("Could not send chord callback of group %s: %s", signature.GroupUUID, err.Error())
+	}
+}
+
+// isCleanup tells whether a task is the cleanup step of a pipeline, run with
+// the error of the previous step
+func (w *Worker) isCleanup(signature *tasks.Signature) bool {
+	taskFunc, _ := w.server.GetRegisteredTask(signature.Name)
+
+	return taskFunc != nil && reflect.ValueOf(taskFunc).Pointer() == reflect.ValueOf(TaskCleanup).Pointer()
+}
+
+// logStepError logs the error of the failed step a cleanup step runs after,
+// which TaskCleanup is passed but does not report
+func (w *Worker) logStepError(signature *tasks.Signature) {
+	if !w.isCleanup(signature) || len(signature.Args) == 0 {
+		return
+	}
+
+	if stepErr, _ := signature.Args[0].Value.(string); stepErr != "" {
+		w.taskLog(signature).Warningf("Task %s cleans up after a failed step: %s", signature.UUID, stepErr)
+	}
+}
+
+// exportResult exports the metrics of a command which succeeded, labelled
+// with the labels of its group
+func (w *Worker) exportResult(signature *tasks.Signature, finishedAt time.Time) {
+	state, err := w.server.GetBackend().GetState(signature.UUID)
+
+	if err != nil || !state.IsSuccess() || len(state.Results) == 0 {
+		return
+	}
+
+	output, _ := state.Results[0].Value.(string)
+
+	// The command is the first argument, after the error of the previous
+	// step for a cleanup step
+	args := signature.Args
+
+	if w.isCleanup(signature) && len(args) > 0 {
+		args = args[1:]
+	}
+
+	if len(args) == 0 {
+		return
+	}
+
+	command, _ := args[0].Value.(string)
+
+	group := taskGroup(signature)
+	labels := make(map[string]string)
+
+	if group != "" {
+		if info, err := GetGroupInfo(w.store, group); err == nil {
+			for name, value := range info.Labels {
+				labels[name] = value
+			}
+		}
+	}
+
+	labels[ResultWorkerLabel] = w.ID
+	labels[ResultRunLabel] = group
+	labels[ResultTaskLabel] = signature.UUID
+
+	result := NewResult(command, output, labels, finishedAt)
+
+	if result == nil {
+		return
+	}
+
+	for _, exporter := range w.Exporters {
+		if err := exporter.Export(result); err != nil {
+			w.taskLog(signature).Warningf("Could not export result of task %s: %s", signature.UUID, err.Error())
+		}
+	}
+}
+
+// pin routes the callbacks, and their own callbacks, to a queue and makes
+// them run in the given working directory, recording their group
+func pin(signatures []*tasks.Signature, queue, workdir, group string) {
+	for _, signature := range signatures {
+		signature.RoutingKey = queue
+
+		if signature.Headers == nil {
+			signature.Headers = make(tasks.Headers)
+		}
+
+		if workdir != "" {
+			signature.Headers[WorkdirHeader] = workdir
+		}
+
+		if group != "" {
+			signature.Headers[GroupHeader] = group
+		}
+
+		pin(signature.OnSuccess, queue, workdir, group)
+		pin(signature.OnError, queue, workdir, group)
+	}
+}
+
+// taskGroup returns the group of a task, or of the first step of its
+// pipeline
+func taskGroup(signature *tasks.Signature) string {
+	if signature.GroupUUID != "" {
+		return signature.GroupUUID
+	}
+
+	group, _ := signature.Headers[GroupHeader].(string)
+
+	return group
+}
+
+// log returns the logger of the lines of the worker
+func (w *Worker) log() *Logger {
+	return Log.With(Fields{LogWorker: w.ID})
+}
+
+// taskLog returns the logger of the lines about a task
+func (w *Worker) taskLog(signature *tasks.Signature) *Logger {
+	return w.log().Task(signature.UUID, taskGroup(signature))
+}
+
+func (w *Worker) saveTaskMeta(meta *TaskMeta) {
+	if err := SaveTaskMeta(w.store, meta, ExpireIn(w.server.GetConfig())); err != nil {
+		w.log().Task(meta.TaskUUID, meta.GroupUUID).Warningf("Could not save metadata of task %s: %s", meta.TaskUUID, err.Error())
+	}
+}
+
+func (w *Worker) heartbeat(stop <-chan struct{}) {
+	hostname, _ := os.Hostname()
+	ticker := time.NewTicker(heartbeatPeriod)
+	defer ticker.Stop()
+
+	for {
+		info := &WorkerInfo{
+			ID:           w.ID,
+			Hostname:     hostname,
+			Queue:        w.server.GetConfig().DefaultQueue,
+			PrivateQueue: w.PrivateQueue(),
+			Busy:         int(atomic.LoadInt32(&w.busy)),
+			LastSeen:     time.Now().UTC(),
+		}
+
+		if err := SaveWorkerInfo(w.store, info, int(3*heartbeatPeriod/time.Second)); err != nil {
+			w.log().Warningf("Could not save heartbeat: %s", err.Error())
+		} else {
+			w.metrics.lastHeartbeat.Set(float64(info.LastSeen.UnixNano()) / 1e9)
+		}
+
+		w.adoptRetries()
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package benchdrilltasks
+
+import (
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// SentAtHeader is the time a task was sent, RFC 3339, from which the time
+// it waited in the queue is measured
+const SentAtHeader = "benchdrill_sent_at"
+
+// Upper bounds, in seconds, of the buckets of the durations of the tasks
+// (benchmarks last from seconds to hours) and of their wait in the queue
+var (
+	taskDurationBuckets = []float64{1, 5, 15, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+	queueWaitBuckets    = []float64{0.1, 0.5, 1, 5, 15, 30, 60, 300, 900, 1800, 3600}
+)
+
+// workerMetrics are the operational metrics of a worker, by task name
+type workerMetrics struct {
+	received, succeeded, failed, timedOut, retried *Counter
+	duration, queueWait                            *Histogram
+	lastHeartbeat                                  *Gauge
+}
+
+func newWorkerMetrics(w *Worker) *workerMetrics {
+	r := w.Metrics
+	m := &workerMetrics{
+		received:      r.Counter("benchdrill_worker_tasks_received_total", "Tasks received by the worker", "task"),
+		succeeded:     r.Counter("benchdrill_worker_tasks_succeeded_total", "Tasks which succeeded", "task"),
+		failed:        r.Counter("benchdrill_worker_tasks_failed_total", "Tasks which failed, timed out ones excepted", "task"),
+		timedOut:      r.Counter("benchdrill_worker_tasks_timed_out_total", "Tasks whose command was killed after the task timeout", "task"),
+		retried:       r.Counter("benchdrill_worker_tasks_retried_total", "Attempts of tasks which failed and are retried", "task"),
+		duration:      r.Histogram("benchdrill_worker_task_duration_seconds", "Time taken to process a task", taskDurationBuckets, "task"),
+		queueWait:     r.Histogram("benchdrill_worker_queue_wait_seconds", "Time a task waited in the queue before it was received", queueWaitBuckets, "task"),
+		lastHeartbeat: r.Gauge("benchdrill_worker_last_heartbeat_timestamp_seconds", "Time of the last heartbeat saved in the Store"),
+	}
+
+	busy := r.Gauge("benchdrill_worker_busy_tasks", "Tasks being processed")
+	r.OnScrape(func() {
+		busy.Set(float64(atomic.LoadInt32(&w.busy)))
+	})
+
+	return m
+}
+
+// receive counts a task and the time it waited since it was sent, or since
+// its ETA if it was delayed (retried tasks are)
+func (m *workerMetrics) receive(signature *tasks.Signature, now time.Time) {
+	m.received.Inc(signature.Name)
+
+	if sent := queuedAt(signature); !sent.IsZero() && now.After(sent) {
+		m.queueWait.Observe(now.Sub(sent).Seconds(), signature.Name)
+	}
+}
+
+// done counts a processed task by its outcome, as recorded by the backend
+func (m *workerMetrics) done(w *Worker, signature *tasks.Signature, duration time.Duration) {
+	m.duration.Observe(duration.Seconds(), signature.Name)
+
+	state, err := w.server.GetBackend().GetState(signature.UUID)
+
+	if err != nil {
+		return
+	}
+
+	switch {
+	case state.IsSuccess():
+		m.succeeded.Inc(signature.Name)
+	case state.IsFailure() && strings.HasPrefix(state.Error, ErrTimedOut.Error()):
+		m.timedOut.Inc(signature.Name)
+	case state.IsFailure():
+		m.failed.Inc(signature.Name)
+	default:
+		// Sent back to the queue, which resets its state to PENDING
+		m.retried.Inc(signature.Name)
+	}
+}
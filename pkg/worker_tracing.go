@@ -0,0 +1,151 @@
+package benchdrilltasks
+
+import (
+	"sync"
+	"time"
+
+	"github.com/RichardKnop/machinery/v1"
+	"github.com/RichardKnop/machinery/v1/backends"
+	"github.com/RichardKnop/machinery/v1/tasks"
+)
+
+// Spans of the execution of the tasks being processed, by task UUID, ended
+// by the result backend once the task returned
+var (
+	execSpans   = make(map[string]*Span)
+	execSpansMu sync.Mutex
+)
+
+// queuedAt returns the time a task was sent, or its ETA if it was delayed
+// (retried tasks are), zero if unknown
+func queuedAt(signature *tasks.Signature) time.Time {
+	var sent time.Time
+
+	if value, ok := signature.Headers[SentAtHeader].(string); ok {
+		sent, _ = time.Parse(time.RFC3339Nano, value)
+	}
+
+	if signature.ETA != nil && signature.ETA.After(sent) {
+		sent = *signature.ETA
+	}
+
+	return sent
+}
+
+// traceTask starts the span of the processing of a task, below the span of
+// the client which sent it, and records the time it waited in the queue
+func (w *Worker) traceTask(signature *tasks.Signature, received time.Time) *Span {
+	parent := TraceContext(signature.Headers)
+
+	if sent := queuedAt(signature); !sent.IsZero() && received.After(sent) {
+		wait := Tracing.StartAt("queue wait", parent, SpanInternal, sent)
+		w.setTaskAttributes(wait, signature)
+		wait.FinishAt(received)
+	}
+
+	span := Tracing.StartAt("process "+signature.Name, parent, SpanConsumer, received)
+	w.setTaskAttributes(span, signature)
+
+	// The callbacks are processed below this task
+	for _, callbacks := range [][]*tasks.Signature{signature.OnSuccess, signature.OnError, {signature.ChordCallback}} {
+		for _, callback := range callbacks {
+			if callback == nil {
+				continue
+			}
+
+			if callback.Headers == nil {
+				callback.Headers = make(tasks.Headers)
+			}
+
+			SetTraceContext(callback.Headers, span.Context)
+		}
+	}
+
+	return span
+}
+
+func (w *Worker) setTaskAttributes(span *Span, signature *tasks.Signature) {
+	span.SetAttribute(TraceTaskAttribute, signature.UUID)
+	span.SetAttribute(TraceTaskNameAttribute, signature.Name)
+	span.SetAttribute(TraceGroupAttribute, taskGroup(signature))
+	span.SetAttribute(TraceWorkerAttribute, w.ID)
+}
+
+// traceExec starts the span of the execution of a task by machinery, ended
+// when its result is stored, or by the returned function
+func traceExec(signature *tasks.Signature, parent *Span) func() {
+	span := parent.Child("exec")
+
+	execSpansMu.Lock()
+	execSpans[signature.UUID] = span
+	execSpansMu.Unlock()
+
+	return func() {
+		execSpansMu.Lock()
+		delete(execSpans, signature.UUID)
+		execSpansMu.Unlock()
+
+		span.Finish()
+	}
+}
+
+// tracedBackend wraps the result backend to time the storage of the results
+// of the tasks, which ends their execution
+type tracedBackend struct {
+	backends.Interface
+}
+
+// traceBackend wraps the result backend of a server, once
+func traceBackend(server *machinery.Server) {
+	if _, traced := server.GetBackend().(*tracedBackend); !traced {
+		server.SetBackend(&tracedBackend{server.GetBackend()})
+	}
+}
+
+func (b *tracedBackend) SetStateRetry(signature *tasks.Signature) error {
+	defer b.store(signature, "")()
+	return b.Interface.SetStateRetry(signature)
+}
+
+func (b *tracedBackend) SetStateSuccess(signature *tasks.Signature, results []*tasks.TaskResult) error {
+	defer b.store(signature, "")()
+	return b.Interface.SetStateSuccess(signature, results)
+}
+
+func (b *tracedBackend) SetStateFailure(signature *tasks.Signature, err string) error {
+	defer b.store(signature, err)()
+	return b.Interface.SetStateFailure(signature, err)
+}
+
+// store ends the execution span of a task, if it is traced, and starts the
+// span of the storage of its result, ended by the returned function. The
+// callbacks, sent by machinery once the result is stored, are marked sent
+func (b *tracedBackend) store(signature *tasks.Signature, taskErr string) func() {
+	execSpansMu.Lock()
+	exec := execSpans[signature.UUID]
+	delete(execSpans, signature.UUID)
+	execSpansMu.Unlock()
+
+	if exec == nil {
+		return func() {}
+	}
+
+	exec.Error = taskErr
+	exec.Finish()
+
+	span := exec.tracer.Start("result store", exec.Parent, SpanClient)
+
+	return func() {
+		span.Finish()
+
+		sentAt := time.Now().UTC().Format(time.RFC3339Nano)
+
+		for _, callbacks := range [][]*tasks.Signature{signature.OnSuccess, signature.OnError} {
+			for _, callback := range callbacks {
+				if callback.Headers != nil {
+					callback.Headers[SentAtHeader] = sentAt
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package benchdrilltasks
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Variables of a workload template set for each instance
+const (
+	// Index of the instance in its group, from 0
+	InstanceVar = "instance"
+	// Number of instances of the group
+	InstancesVar = "instances"
+	GroupVar     = "group"
+	TaskVar      = "task"
+)
+
+// ParseVars parses "name=value" definitions of workload variables
+func ParseVars(specs []string) (map[string]string, error) {
+	vars := make(map[string]string)
+
+	for _, spec := range specs {
+		i := strings.Index(spec, "=")
+
+		if i < 1 {
+			return nil, fmt.Errorf("Invalid variable %q, expected name=value", spec)
+		}
+
+		name := strings.TrimSpace(spec[:i])
+
+		switch name {
+		case InstanceVar, InstancesVar, GroupVar, TaskVar:
+			return nil, fmt.Errorf("Variable %s is set for each instance and cannot be set", name)
+		}
+
+		vars[name] = spec[i+1:]
+	}
+
+	return vars, nil
+}
+
+// RenderWorkload renders a workload template for one instance of a group,
+// with the given variables and the variables of the instance
+func RenderWorkload(template string, vars map[string]string, groupUUID, taskUUID string, instance, instances int) (string, error) {
+	c := Combination{
+		InstanceVar:  strconv.Itoa(instance),
+		InstancesVar: strconv.Itoa(instances),
+		GroupVar:     groupUUID,
+		TaskVar:      taskUUID,
+	}
+
+	for name, value := range vars {
+		c[name] = value
+	}
+
+	rendered, err := Render(template, c)
+
+	if err != nil {
+		return "", fmt.Errorf("Could not render workload: %s", err.Error())
+	}
+
+	return rendered, nil
+}